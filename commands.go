@@ -0,0 +1,156 @@
+// +build !js
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nwidger/nintengo/nes"
+)
+
+func infoCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "print ROM info as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: info [-json] <rom-file>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("info requires a <rom-file>")
+	}
+
+	buf, gamename, err := nes.ReadROMSource(fs.Arg(0))
+
+	if err != nil {
+		return
+	}
+
+	info, err := nes.NewROMInfo(buf, filepath.Base(gamename))
+
+	if err != nil {
+		return
+	}
+
+	if *jsonOutput {
+		var out []byte
+
+		if out, err = json.MarshalIndent(info, "", "  "); err != nil {
+			return
+		}
+
+		fmt.Println(string(out))
+	} else {
+		fmt.Print(info)
+	}
+
+	return
+}
+
+func fixHeaderCommand(args []string) (err error) {
+	var mapper, submapper uint64
+
+	fix := &nes.HeaderFix{}
+
+	fs := flag.NewFlagSet("fix-header", flag.ExitOnError)
+	output := fs.String("o", "", "file to write the fixed ROM to (default: <rom-file> with .fixed.nes suffix)")
+	noDB := fs.Bool("no-db", false, "do not take header values from the ROM database")
+	mapperFlag := fs.String("mapper", "", "mapper number")
+	submapperFlag := fs.String("submapper", "", "NES 2.0 submapper number")
+	fix.Mirroring = fs.String("mirroring", "", "mirroring: Horizontal | Vertical | FourScreen")
+	fix.Battery = fs.Bool("battery", false, "battery-backed PRG-RAM present")
+	fix.VSCart = fs.Bool("vs", false, "Vs. System cartridge")
+	fix.Region = fs.String("region", "", "region: NTSC | PAL")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: fix-header [options] <rom-file>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("fix-header requires a <rom-file>")
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// only override header values given on the command line
+	if !set["mirroring"] {
+		fix.Mirroring = nil
+	}
+
+	if !set["battery"] {
+		fix.Battery = nil
+	}
+
+	if !set["vs"] {
+		fix.VSCart = nil
+	}
+
+	if !set["region"] {
+		fix.Region = nil
+	}
+
+	if set["mapper"] {
+		if mapper, err = strconv.ParseUint(*mapperFlag, 0, 8); err != nil {
+			return fmt.Errorf("Invalid mapper: %v", err)
+		}
+
+		m := uint8(mapper)
+		fix.Mapper = &m
+	}
+
+	if set["submapper"] {
+		if submapper, err = strconv.ParseUint(*submapperFlag, 0, 4); err != nil {
+			return fmt.Errorf("Invalid submapper: %v", err)
+		}
+
+		s := uint8(submapper)
+		fix.Submapper = &s
+	}
+
+	source := fs.Arg(0)
+	filename, _ := filepath.Abs(nes.ROMSourceFile(source))
+
+	buf, gamename, err := nes.ReadROMSource(source)
+
+	if err != nil {
+		return
+	}
+
+	fixed, game, err := nes.FixHeader(buf, !*noDB, fix)
+
+	if err != nil {
+		return
+	}
+
+	if *output == "" {
+		*output = gamename + ".fixed.nes"
+	}
+
+	if abs, _ := filepath.Abs(*output); abs == filename {
+		return errors.New("Refusing to overwrite the original ROM or its archive")
+	}
+
+	if game != nil {
+		fmt.Println("*** Using database entry for", game.Name)
+	}
+
+	if err = ioutil.WriteFile(*output, fixed, 0644); err != nil {
+		return
+	}
+
+	fmt.Println("*** Wrote fixed ROM to", *output)
+
+	return
+}
@@ -50,6 +50,23 @@ func (neserv *NEServer) Run() (err error) {
 		w.Write([]byte(neserv.NES.Pause().String()))
 	})
 
+	http.HandleFunc("/step", func(w http.ResponseWriter, req *http.Request) {
+		switch req.FormValue("mode") {
+		case "frame":
+			neserv.NES.Step(nes.FrameStep)
+		case "scanline":
+			neserv.NES.Step(nes.ScanlineStep)
+		case "instruction":
+			neserv.NES.Step(nes.InstructionStep)
+		default:
+			http.Error(w, "mode must be frame, scanline or instruction", http.StatusBadRequest)
+		}
+	})
+
+	http.HandleFunc("/step-state", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(fmt.Sprintf("Scanline %v, cycle %v", neserv.NES.PPU.Scanline, neserv.NES.PPU.Cycle)))
+	})
+
 	http.HandleFunc("/run-state", func(w http.ResponseWriter, req *http.Request) {
 		w.Write([]byte(neserv.NES.RunState().String()))
 	})
@@ -62,6 +79,10 @@ func (neserv *NEServer) Run() (err error) {
 		neserv.NES.SaveState()
 	})
 
+	if tas := neserv.NES.TAS(); tas != nil {
+		neserv.handleTAS(tas)
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		page := Page{
 			NES: neserv.NES,
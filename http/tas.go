@@ -0,0 +1,161 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/nwidger/nintengo/nes"
+)
+
+// A frame of input as sent to and from the browser: its commands, the
+// buttons of each controller written as in .fm2 files and whether it
+// was a lag frame when last played.
+type tasFrame struct {
+	Frame    int      `json:"frame"`
+	Commands uint8    `json:"commands"`
+	Buttons  []string `json:"buttons"`
+	Lag      bool     `json:"lag"`
+}
+
+func intValue(req *http.Request, name string, value int) (int, error) {
+	if s := req.FormValue(name); s != "" {
+		v, err := strconv.Atoi(s)
+
+		if err != nil {
+			return 0, fmt.Errorf("Invalid %v '%v'", name, s)
+		}
+
+		value = v
+	}
+
+	return value, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("*** Error writing JSON: %s\n", err)
+	}
+}
+
+// Registers the TAS editor's handlers:
+//
+//	/tas                               status as JSON
+//	/tas/input?frame=N&count=M         input of M frames from N on
+//	/tas/set-input?frame=N&controller=C&buttons=R..U...A[&commands=1]
+//	/tas/insert?frame=N&count=M        insert M blank frames before N
+//	/tas/delete?frame=N&count=M        delete M frames from N on
+//	/tas/seek?frame=N
+//	/tas/bookmark?slot=S               set bookmark S at the current frame
+//	/tas/load-bookmark?slot=S
+//	/tas/movie.fm2                     the movie as an .fm2 file
+func (neserv *NEServer) handleTAS(tas *nes.TASEditor) {
+	// runs f with the frame, count and slot parameters
+	handle := func(path string, f func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error) {
+		http.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+			frame, err := intValue(req, "frame", tas.Status().Frame)
+			var count, slot int
+
+			if err == nil {
+				count, err = intValue(req, "count", 1)
+			}
+
+			if err == nil {
+				slot, err = intValue(req, "slot", 0)
+			}
+
+			if err == nil {
+				err = f(w, req, frame, count, slot)
+			}
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		})
+	}
+
+	handle("/tas", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		writeJSON(w, tas.Status())
+		return nil
+	})
+
+	handle("/tas/input", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		frames := []tasFrame{}
+		lag := tas.Lag(frame, count)
+
+		for i, input := range tas.Input(frame, count) {
+			f := tasFrame{
+				Frame:    frame + i,
+				Commands: input.Commands,
+				Lag:      i < len(lag) && lag[i],
+			}
+
+			for _, buttons := range input.Buttons {
+				f.Buttons = append(f.Buttons, nes.FormatFM2Gamepad(buttons))
+			}
+
+			frames = append(frames, f)
+		}
+
+		writeJSON(w, frames)
+		return nil
+	})
+
+	handle("/tas/set-input", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		var input nes.MovieFrame
+
+		if frames := tas.Input(frame, 1); len(frames) > 0 {
+			input = frames[0]
+		}
+
+		controller, err := intValue(req, "controller", 0)
+
+		if err != nil {
+			return err
+		}
+
+		if controller < 0 || controller >= len(input.Buttons) {
+			return fmt.Errorf("Invalid controller %v", controller)
+		}
+
+		input.Buttons[controller] = nes.ParseFM2Gamepad(req.FormValue("buttons"))
+
+		commands, err := intValue(req, "commands", int(input.Commands))
+
+		if err != nil {
+			return err
+		}
+
+		input.Commands = uint8(commands)
+
+		return tas.SetInput(frame, input)
+	})
+
+	handle("/tas/insert", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		return tas.Insert(frame, count)
+	})
+
+	handle("/tas/delete", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		return tas.Delete(frame, count)
+	})
+
+	handle("/tas/seek", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		return tas.Seek(frame)
+	})
+
+	handle("/tas/bookmark", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		return tas.SetBookmark(slot)
+	})
+
+	handle("/tas/load-bookmark", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		return tas.LoadBookmark(slot)
+	})
+
+	handle("/tas/movie.fm2", func(w http.ResponseWriter, req *http.Request, frame, count, slot int) error {
+		w.Header().Set("Content-Type", "text/plain")
+		return tas.WriteFM2(w)
+	})
+}
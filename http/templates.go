@@ -41,6 +41,9 @@ var index = `
 	      <ul class="nav navbar-nav">
 	  	<li class='active'><a href='#'>{{.NES.GameName}}</a></li>
 	  	<li><a href='#' id='pause-link'>Pause</a></li>
+		<li><a href='#' class='step-link' data-mode='frame'>Frame</a></li>
+		<li><a href='#' class='step-link' data-mode='scanline'>Scanline</a></li>
+		<li><a href='#' class='step-link' data-mode='instruction'>Instruction</a></li>
 		<li><a href='#' id='save-state-link'>Save State</a></li>
 		<li><a href='#' id='load-state-link'>Load State</a></li>
 		<li><a href='#' id='reset-link'>Reset</a></li>
@@ -48,6 +51,7 @@ var index = `
 	      <ul class="nav navbar-nav navbar-right">
 		<li><a href='#' id='run-state'></a></li>
 		<li><a href='#' id='step-state'></a></li>
+		<li><a href='#'>Lag frames {{.NES.LagFrames}}</a></li>
 	      </ul>
 	    </div><!-- /.navbar-collapse -->
 	  </div><!-- /.container-fluid -->
@@ -182,12 +186,22 @@ var index = `
      $('#run-state').load('/run-state');
      $('#run-state').show();
 
+     $('#step-state').load('/step-state');
+     $('#step-state').show();
+
      $('#pause-link').click(function(e) {
        e.preventDefault();
        $('#run-state').load('/pause');
        location.reload();
      });
 
+     $('.step-link').click(function(e) {
+       e.preventDefault();
+       $.get('/step', { mode: $(this).data('mode') }, function() {
+	 location.reload();
+       });
+     });
+
      $('#save-state-link').click(function(e) {
        e.preventDefault();
        $('#load-result').load('/save-state');
@@ -44,6 +44,34 @@ func main() {
 	flag.StringVar(&options.HTTPAddress, "http", "", "HTTP service address (e.g., ':6060')")
 	flag.StringVar(&options.Listen, "listen", "", "Listen at address as master (e.g., ':8080')")
 	flag.StringVar(&options.Connect, "connect", "", "Connect to address as slave, <rom-file> will be ignored (e.g., 'localhost:8080')")
+	flag.StringVar(&options.Patch, "patch", "", "IPS, UPS or BPS patch to apply to <rom-file> (default: same-named .ips/.ups/.bps file if present)")
+	flag.StringVar(&options.Database, "db", "", "ROM database file (default: ~/.nintengodb.yml if present)")
+	flag.StringVar(&options.VSPPU, "vs-ppu", "", "Vs. System PPU: RC2C03 | RP2C04-0001 | RP2C04-0002 | RP2C04-0003 | RP2C04-0004 (default: from ROM database)")
+	flag.StringVar(&options.VSDIP, "vs-dip", "", "Vs. System DIP switches 1-8 as 0s and 1s, e.g. '01000000' (default: from ROM database)")
+	flag.StringVar(&options.Port1, "port1", "", "input device in controller port 1: joypad | fourscore | zapper | vaus | powerpad | powerpad-a | none (default: from ROM database, else joypad)")
+	flag.StringVar(&options.Port2, "port2", "", "input device in controller port 2: joypad | famicom | fourscore | zapper | vaus | powerpad | powerpad-a | none (default: from ROM database, else joypad)")
+	flag.StringVar(&options.Expansion, "expansion", "", "input device in the Famicom expansion port: hori | zapper | vaus | none (default: from ROM database, else none)")
+	flag.Float64Var(&options.VausSensitivity, "vaus-sensitivity", 1.0, "Arkanoid Vaus knob travel across the width of the picture, as a multiple of its range")
+	flag.StringVar(&options.VausRange, "vaus-range", "", "Arkanoid Vaus calibrated range as two potentiometer counts, e.g. '98-242' (default: 98-242)")
+	flag.IntVar(&options.TurboOn, "turbo-on", nes.DefaultTurboOn, "frames turbo buttons hold A or B down for")
+	flag.IntVar(&options.TurboOff, "turbo-off", nes.DefaultTurboOff, "frames turbo buttons let A or B up for")
+	flag.StringVar(&options.Opposing, "opposing", "filter", "opposing directions on the Control Pad: filter | allow | last")
+	flag.StringVar(&options.MicrophoneWAV, "microphone-wav", "", "WAV file heard by the microphone of a famicom controller in port 2")
+	flag.StringVar(&options.RecordMovie, "record-movie", "", "record input to an .fm2 movie file")
+	flag.StringVar(&options.PlayMovie, "play-movie", "", "play back input from an .fm2 movie file")
+	flag.StringVar(&options.MovieSavestate, "movie-savestate", "", "save state the recorded movie starts from (default: power on)")
+	flag.StringVar(&options.HashLog, "hash-log", "", "report where the movie desyncs from a log of RAM hashes")
+	flag.StringVar(&options.WriteHashLog, "write-hash-log", "", "write a log of RAM hashes as the movie plays")
+	flag.BoolVar(&options.Deterministic, "deterministic", false, "apply input only at the end of each frame")
+	flag.BoolVar(&options.Headless, "headless", false, "run as fast as possible without video or audio, quitting when a played movie finishes or after -frames frames")
+	flag.IntVar(&options.Frames, "frames", 0, "with -headless, quit after running this many frames")
+	flag.BoolVar(&options.LagCounter, "lag-counter", false, "show the number of lag frames")
+	flag.IntVar(&options.RunAhead, "run-ahead", 0, "show the frame this many frames (0-4) ahead to hide input lag")
+	flag.StringVar(&options.Speed, "speed", "", "run at this fraction or percentage of full speed, 5% or more, or 'unlimited' (default: 100%, unlimited when headless)")
+	flag.BoolVar(&options.AudioSync, "audio-sync", false, "pace emulation by the audio device rather than the clock")
+	flag.StringVar(&options.Splits, "splits", "", "YAML splits file for the speedrun timer (default: same-named .splits.yml file if present)")
+	flag.StringVar(&options.LSS, "lss", "", "LiveSplit file to write split times to (default: the splits file with an .lss extension)")
+	flag.BoolVar(&options.TAS, "tas", false, "edit the movie given by -play-movie or -record-movie through the HTTP service")
 	flag.Parse()
 
 	filename, err := homedir.Expand("~/.nintengorc")
@@ -58,9 +86,43 @@ func main() {
 		}
 	}
 
+	if options.Database == "" {
+		if filename, err = homedir.Expand("~/.nintengodb.yml"); err == nil {
+			if _, err = os.Stat(filename); err == nil {
+				options.Database = filename
+			}
+		}
+	}
+
+	if options.Database != "" {
+		if err = nes.LoadDatabase(options.Database); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading ROM database: %v\n", err)
+		}
+	}
+
+	if flag.NArg() > 0 {
+		var command func(args []string) error
+
+		switch flag.Arg(0) {
+		case "info":
+			command = infoCommand
+		case "fix-header":
+			command = fixHeaderCommand
+		}
+
+		if command != nil {
+			if err = command(flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+
+			return
+		}
+	}
+
 	if len(flag.Args()) != 1 {
 		if len(options.Connect) == 0 {
-			fmt.Fprintf(os.Stderr, "usage: <rom-file>\n")
+			fmt.Fprintf(os.Stderr, "usage: <rom-file>\n       info [-json] <rom-file>\n       fix-header [options] <rom-file>\n")
 			return
 		}
 	} else {
@@ -11,7 +11,7 @@ type JSAudio struct {
 
 func NewAudio(frequency int, sampleSize int) (audio *JSAudio, err error) {
 	audio = &JSAudio{
-		input:      make(chan int16),
+		input:      make(chan int16, sampleSize),
 		sampleSize: sampleSize,
 	}
 	return
@@ -0,0 +1,84 @@
+package nes
+
+import "time"
+
+// The CPU clock rates, which the APU's sample rate follows.
+const (
+	NTSCCPUFrequency float64 = 1789773
+	PALCPUFrequency  float64 = 1662607
+)
+
+// Returns the rate at which the APU makes samples, given the number of
+// CPU cycles it starts making one every.  After each sample the lowest
+// bit of the number of cycles is flipped, so the APU alternates between
+// period and period^1 cycles and averages the two.
+func APUSampleRate(cpuFrequency float64, period uint64) float64 {
+	periods := [2]uint64{period, period ^ 1}
+	return cpuFrequency / (float64(periods[0]+periods[1]) / 2)
+}
+
+// How far dynamic rate control may stretch or squeeze the audio, as a
+// fraction of the resample ratio.
+const AudioSyncMaxDelta = 0.005
+
+// Paces emulation by the audio device in place of the FPS limiter.  At
+// the end of each frame the emulator waits for the audio buffer, the
+// device's input channel, to drain to half full, and samples are
+// resampled to the device's rate with a ratio adjusted by up to
+// AudioSyncMaxDelta according to how full the buffer is, more samples
+// being made while it is less than half full and fewer while it is
+// more.  The buffer then stays around half full, so the device never
+// runs dry and frames are run at an even pace, without the clock and
+// the device's rate drifting apart.
+type AudioSync struct {
+	input   chan int16
+	inRate  float64 // samples per second from the APU
+	outRate float64 // samples per second played by the device
+	phase   float64 // of the next sample out between the last two in
+	last    int16
+	Ratio   float64 // output samples made per sample in, last time
+}
+
+func NewAudioSync(input chan int16, inRate, outRate float64) *AudioSync {
+	return &AudioSync{
+		input:   input,
+		inRate:  inRate,
+		outRate: outRate,
+		Ratio:   outRate / inRate,
+	}
+}
+
+// Returns how full the audio buffer is, from 0 to 1, or 0.5 if the
+// device takes samples without buffering them.
+func (as *AudioSync) fill() float64 {
+	if cap(as.input) == 0 {
+		return 0.5
+	}
+
+	return float64(len(as.input)) / float64(cap(as.input))
+}
+
+// Resamples a sample from the APU to the device's rate and sends the
+// samples made to the device.
+func (as *AudioSync) sample(sample int16) {
+	as.Ratio = as.outRate / as.inRate * (1 + AudioSyncMaxDelta*(1-2*as.fill()))
+
+	step := 1 / as.Ratio
+
+	for ; as.phase < 1; as.phase += step {
+		as.input <- int16(float64(as.last) + float64(int32(sample)-int32(as.last))*as.phase)
+	}
+
+	as.phase--
+	as.last = sample
+}
+
+// Called at the end of every frame, waits for the audio buffer to
+// drain to half full.
+func (as *AudioSync) Delay() {
+	target := cap(as.input) / 2
+
+	for i := 0; i < 100 && len(as.input) > target; i++ {
+		time.Sleep(time.Duration(float64(len(as.input)-target) / as.outRate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,60 @@
+package nes
+
+import "testing"
+
+func TestAPUSampleRate(t *testing.T) {
+	for _, period := range []uint64{40, 41} {
+		if rate := APUSampleRate(NTSCCPUFrequency, period); rate != NTSCCPUFrequency/40.5 {
+			t.Errorf("Sample rate starting at %v cycles is %v not %v", period, rate, NTSCCPUFrequency/40.5)
+		}
+	}
+}
+
+func TestAudioSync(t *testing.T) {
+	input := make(chan int16, 2000)
+
+	// with the buffer half full the rate is converted as it is
+	for i := 0; i < 1000; i++ {
+		input <- -1
+	}
+
+	as := NewAudioSync(input, 44100, 22050)
+
+	for i := 0; i < 100; i++ {
+		as.sample(int16(i * 100))
+	}
+
+	if n := len(input) - 1000; n != 50 {
+		t.Errorf("Resampled 100 samples to %v at half the rate", n)
+	}
+
+	for len(input) > 50 {
+		<-input
+	}
+
+	// samples in between are interpolated
+	for last := int16(-1); len(input) > 0; {
+		if s := <-input; s <= last || s > 9900 {
+			t.Errorf("Resampled %v after %v", s, last)
+		} else {
+			last = s
+		}
+	}
+
+	// an emptier buffer gets more samples than a fuller one
+	empty := NewAudioSync(make(chan int16, 200000), 44100, 44100)
+	full := NewAudioSync(make(chan int16, 200000), 44100, 44100)
+
+	for i := 0; i < 190000; i++ {
+		full.input <- 0
+	}
+
+	for i := 0; i < 1000; i++ {
+		empty.sample(0)
+		full.sample(0)
+	}
+
+	if len(empty.input) <= 1000 || len(full.input)-190000 >= 1000 {
+		t.Errorf("Made %v samples with an empty buffer and %v with a full one", len(empty.input), len(full.input)-190000)
+	}
+}
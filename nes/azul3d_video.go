@@ -7,12 +7,15 @@ import (
 	"image/color"
 	"math"
 	"sync"
+	"time"
 
 	"azul3d.org/engine/gfx"
 	"azul3d.org/engine/gfx/camera"
 	"azul3d.org/engine/gfx/window"
 	"azul3d.org/engine/keyboard"
 	"azul3d.org/engine/lmath"
+	"azul3d.org/engine/mouse"
+	"azul3d.org/engine/native/glfw"
 )
 
 var Azul3DPalette []color.RGBA = []color.RGBA{
@@ -91,12 +94,14 @@ type Azul3DVideo struct {
 	overscan      bool
 	caption       string
 	fps           float64
+	bindings      *KeyBindings
 }
 
-func NewVideo(caption string, events chan Event, framePool *sync.Pool, fps float64) (video *Azul3DVideo, err error) {
+func NewVideo(caption string, events chan Event, bindings *KeyBindings, framePool *sync.Pool, fps float64) (video *Azul3DVideo, err error) {
 	video = &Azul3DVideo{
 		input:     make(chan []uint8, 128),
 		events:    events,
+		bindings:  bindings,
 		framePool: framePool,
 		palette:   Azul3DPalette,
 		overscan:  true,
@@ -163,109 +168,29 @@ void main() {
 `)
 
 func (video *Azul3DVideo) handleInput(ev keyboard.ButtonEvent, w *window.Window, done chan bool) (running bool) {
-	var event Event
-
 	setSize := func(width, height int) {
 		props := (*w).Props()
 		props.SetSize(width, height)
 		(*w).Request(props)
 	}
 
-	if ev.State == keyboard.Down {
-		switch ev.Key {
-		case keyboard.Tilde:
-			video.overscan = !video.overscan
-		case keyboard.One:
-			setSize(256, 240)
-		case keyboard.Two:
-			setSize(512, 480)
-		case keyboard.Three:
-			setSize(768, 720)
-		case keyboard.Four:
-			setSize(1024, 960)
-		case keyboard.Five:
-			setSize(2560, 1440)
-		case keyboard.P:
-			event = &PauseEvent{}
-		case keyboard.Q:
-			close(done)
-			event = &QuitEvent{}
-		case keyboard.L:
-			event = &SavePatternTablesEvent{}
-		case keyboard.R:
-			event = &ResetEvent{}
-		case keyboard.S:
-			event = &RecordEvent{}
-		case keyboard.D:
-			event = &StopEvent{}
-		case keyboard.NumAdd:
-			event = &AudioRecordEvent{}
-		case keyboard.NumSubtract:
-			event = &AudioStopEvent{}
-		case keyboard.O:
-			event = &CPUDecodeEvent{}
-		case keyboard.I:
-			event = &PPUDecodeEvent{}
-		case keyboard.Nine:
-			event = &ShowBackgroundEvent{}
-		case keyboard.Zero:
-			event = &ShowSpritesEvent{}
-		case keyboard.F1:
-			event = &SaveStateEvent{}
-		case keyboard.F5:
-			event = &LoadStateEvent{}
-		case keyboard.F8:
-			event = &FPSEvent{2.}
-		case keyboard.F9:
-			event = &FPSEvent{1.}
-		case keyboard.F10:
-			event = &FPSEvent{.75}
-		case keyboard.F11:
-			event = &FPSEvent{.5}
-		case keyboard.F12:
-			event = &FPSEvent{.25}
-		case keyboard.NumZero:
-			event = &MuteEvent{}
-		case keyboard.NumOne:
-			event = &MutePulse1Event{}
-		case keyboard.NumTwo:
-			event = &MutePulse2Event{}
-		case keyboard.NumThree:
-			event = &MuteTriangleEvent{}
-		case keyboard.NumFour:
-			event = &MuteNoiseEvent{}
-		case keyboard.NumFive:
-			event = &MuteDMCEvent{}
-
-		}
-	}
-
-	if event == nil {
-		button := One
-
-		switch ev.Key {
-		case keyboard.Z:
-			button = A
-		case keyboard.X:
-			button = B
-		case keyboard.Enter:
-			button = Start
-		case keyboard.RightShift:
-			button = Select
-		case keyboard.ArrowUp:
-			button = Up
-		case keyboard.ArrowDown:
-			button = Down
-		case keyboard.ArrowLeft:
-			button = Left
-		case keyboard.ArrowRight:
-			button = Right
-		}
-
-		event = &ControllerEvent{
-			Button: button,
-			Down:   ev.State == keyboard.Down,
-		}
+	event, action := video.bindings.Handle(azul3dKeyNames[ev.Key], ev.State == keyboard.Down)
+
+	switch action {
+	case "overscan":
+		video.overscan = !video.overscan
+	case "size-1":
+		setSize(256, 240)
+	case "size-2":
+		setSize(512, 480)
+	case "size-3":
+		setSize(768, 720)
+	case "size-4":
+		setSize(1024, 960)
+	case "size-5":
+		setSize(2560, 1440)
+	case "quit":
+		close(done)
 	}
 
 	if event != nil {
@@ -275,6 +200,106 @@ func (video *Azul3DVideo) handleInput(ev keyboard.ButtonEvent, w *window.Window,
 	return
 }
 
+// The names keys are bound by in ~/.nintengorc.
+var azul3dKeyNames = map[keyboard.Key]string{
+	keyboard.A:            "a",
+	keyboard.B:            "b",
+	keyboard.C:            "c",
+	keyboard.D:            "d",
+	keyboard.E:            "e",
+	keyboard.F:            "f",
+	keyboard.G:            "g",
+	keyboard.H:            "h",
+	keyboard.I:            "i",
+	keyboard.J:            "j",
+	keyboard.K:            "k",
+	keyboard.L:            "l",
+	keyboard.M:            "m",
+	keyboard.N:            "n",
+	keyboard.O:            "o",
+	keyboard.P:            "p",
+	keyboard.Q:            "q",
+	keyboard.R:            "r",
+	keyboard.S:            "s",
+	keyboard.T:            "t",
+	keyboard.U:            "u",
+	keyboard.V:            "v",
+	keyboard.W:            "w",
+	keyboard.X:            "x",
+	keyboard.Y:            "y",
+	keyboard.Z:            "z",
+	keyboard.Zero:         "0",
+	keyboard.One:          "1",
+	keyboard.Two:          "2",
+	keyboard.Three:        "3",
+	keyboard.Four:         "4",
+	keyboard.Five:         "5",
+	keyboard.Six:          "6",
+	keyboard.Seven:        "7",
+	keyboard.Eight:        "8",
+	keyboard.Nine:         "9",
+	keyboard.NumZero:      "kp0",
+	keyboard.NumOne:       "kp1",
+	keyboard.NumTwo:       "kp2",
+	keyboard.NumThree:     "kp3",
+	keyboard.NumFour:      "kp4",
+	keyboard.NumFive:      "kp5",
+	keyboard.NumSix:       "kp6",
+	keyboard.NumSeven:     "kp7",
+	keyboard.NumEight:     "kp8",
+	keyboard.NumNine:      "kp9",
+	keyboard.F1:           "f1",
+	keyboard.F2:           "f2",
+	keyboard.F3:           "f3",
+	keyboard.F4:           "f4",
+	keyboard.F5:           "f5",
+	keyboard.F6:           "f6",
+	keyboard.F7:           "f7",
+	keyboard.F8:           "f8",
+	keyboard.F9:           "f9",
+	keyboard.F10:          "f10",
+	keyboard.F11:          "f11",
+	keyboard.F12:          "f12",
+	keyboard.ArrowUp:      "up",
+	keyboard.ArrowDown:    "down",
+	keyboard.ArrowLeft:    "left",
+	keyboard.ArrowRight:   "right",
+	keyboard.Enter:        "enter",
+	keyboard.Space:        "space",
+	keyboard.Tab:          "tab",
+	keyboard.Backspace:    "backspace",
+	keyboard.Escape:       "escape",
+	keyboard.Insert:       "insert",
+	keyboard.Delete:       "delete",
+	keyboard.Home:         "home",
+	keyboard.End:          "end",
+	keyboard.PageUp:       "pageup",
+	keyboard.PageDown:     "pagedown",
+	keyboard.LeftShift:    "lshift",
+	keyboard.RightShift:   "rshift",
+	keyboard.LeftCtrl:     "lctrl",
+	keyboard.RightCtrl:    "rctrl",
+	keyboard.LeftAlt:      "lalt",
+	keyboard.RightAlt:     "ralt",
+	keyboard.Tilde:        "backquote",
+	keyboard.Dash:         "minus",
+	keyboard.Equals:       "equals",
+	keyboard.LeftBracket:  "leftbracket",
+	keyboard.RightBracket: "rightbracket",
+	keyboard.BackSlash:    "backslash",
+	keyboard.Semicolon:    "semicolon",
+	keyboard.Apostrophe:   "quote",
+	keyboard.Comma:        "comma",
+	keyboard.Period:       "period",
+	keyboard.ForwardSlash: "slash",
+	keyboard.NumAdd:       "kpplus",
+	keyboard.NumSubtract:  "kpminus",
+	keyboard.NumMultiply:  "kpmultiply",
+	keyboard.NumDivide:    "kpdivide",
+	keyboard.NumDecimal:   "kpperiod",
+	keyboard.NumEnter:     "kpenter",
+}
+
 func (video *Azul3DVideo) gfxLoop(w window.Window, d gfx.Device) {
 	done := make(chan bool)
 
@@ -326,7 +351,7 @@ func (video *Azul3DVideo) gfxLoop(w window.Window, d gfx.Device) {
 	palette.MinFilter = gfx.Nearest
 	palette.MagFilter = gfx.Nearest
 	paletteSrc := image.NewRGBA(image.Rect(0, 0, 64, 2))
-	for x, c := range Azul3DPalette {
+	for x, c := range video.palette {
 		paletteSrc.SetRGBA(x, 0, c)
 	}
 	palette.Source = paletteSrc
@@ -395,7 +420,7 @@ func (video *Azul3DVideo) gfxLoop(w window.Window, d gfx.Device) {
 	}
 
 	// Create an event mask for the events we are interested in.
-	evMask := window.KeyboardButtonEvents
+	evMask := window.KeyboardButtonEvents | window.CursorMovedEvents | window.MouseEvents
 
 	// Create a channel of events.
 	events := make(chan window.Event, 256)
@@ -428,14 +453,35 @@ func (video *Azul3DVideo) gfxLoop(w window.Window, d gfx.Device) {
 	}()
 
 	go func() {
+		var x, y float64
+		var buttons [2]bool
+
 		for {
 			ev := <-events
-			if buttonEvent, ok := ev.(keyboard.ButtonEvent); ok {
-				video.handleInput(buttonEvent, &w, done)
+
+			switch e := ev.(type) {
+			case keyboard.ButtonEvent:
+				video.handleInput(e, &w, done)
+				continue
+			case window.CursorMoved:
+				x, y = e.X, e.Y
+			case mouse.Event:
+				switch e.Button {
+				case mouse.Left:
+					buttons[0] = e.State == mouse.Down
+				case mouse.Right:
+					buttons[1] = e.State == mouse.Down
+				}
+			default:
+				continue
 			}
+
+			video.events <- video.mouseEvent(x, y, buttons, &w)
 		}
 	}()
 
+	go video.pollGamepads(done)
+
 	defer w.Close()
 
 	for {
@@ -476,6 +522,71 @@ func (video *Azul3DVideo) gfxLoop(w window.Window, d gfx.Device) {
 	}
 }
 
+// Polls the joysticks GLFW knows about, which it notices being plugged
+// in and unplugged, and sends GamepadEvents for any changes.
+func (video *Azul3DVideo) pollGamepads(done chan bool) {
+	poller := newGamepadPoller()
+	ticker := time.NewTicker(time.Second / 120)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		for joy := glfw.Joystick1; joy <= glfw.JoystickLast; joy++ {
+			var state *gamepadState
+
+			if glfw.JoystickPresent(joy) {
+				state = &gamepadState{name: glfw.GetJoystickName(joy)}
+
+				for _, value := range glfw.GetJoystickAxes(joy) {
+					state.axes = append(state.axes, float64(value))
+				}
+
+				for _, value := range glfw.GetJoystickButtons(joy) {
+					state.buttons = append(state.buttons, value == byte(glfw.Press))
+				}
+			}
+
+			for _, event := range poller.update(int(joy), state) {
+				video.events <- event
+			}
+		}
+	}
+}
+
+// Returns a MouseEvent for the mouse at x, y in the window.  The
+// picture is drawn on a square card filling the shorter side of the
+// window.
+func (video *Azul3DVideo) mouseEvent(x, y float64, buttons [2]bool, w *window.Window) Event {
+	width, height := (*w).Props().Size()
+
+	side := width
+
+	if height < width {
+		side = height
+	}
+
+	var crop int
+
+	if video.overscan {
+		crop = 8
+	}
+
+	fx, fy := framePosition(int(x)-(width-side)/2, int(y)-(height-side)/2,
+		side, side, image.Rect(crop, crop, 256-crop, 240))
+
+	return &MouseEvent{
+		X:     fx,
+		Y:     fy,
+		Left:  buttons[0],
+		Right: buttons[1],
+	}
+}
+
 func (video *Azul3DVideo) Run() {
 	props := window.NewProps()
 
@@ -488,3 +599,11 @@ func (video *Azul3DVideo) Run() {
 func (video *Azul3DVideo) SetCaption(caption string) {
 	video.caption = caption
 }
+
+func (video *Azul3DVideo) SetPalette(palette []color.Color) {
+	video.palette = make([]color.RGBA, len(palette))
+
+	for i, c := range palette {
+		video.palette[i] = color.RGBAModel.Convert(c).(color.RGBA)
+	}
+}
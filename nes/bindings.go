@@ -0,0 +1,335 @@
+package nes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The key names used in bindings, the same in every frontend.
+// Letters and digits are named by themselves, the numeric keypad's
+// keys are prefixed with kp.
+var keyNames = func() map[string]bool {
+	names := map[string]bool{}
+
+	for c := 'a'; c <= 'z'; c++ {
+		names[string(c)] = true
+	}
+
+	for i := 0; i <= 9; i++ {
+		names[strconv.Itoa(i)] = true
+		names["kp"+strconv.Itoa(i)] = true
+	}
+
+	for i := 1; i <= 12; i++ {
+		names["f"+strconv.Itoa(i)] = true
+	}
+
+	for _, name := range []string{
+		"up", "down", "left", "right",
+		"enter", "space", "tab", "backspace", "escape",
+		"insert", "delete", "home", "end", "pageup", "pagedown",
+		"lshift", "rshift", "lctrl", "rctrl", "lalt", "ralt",
+		"backquote", "minus", "equals", "leftbracket", "rightbracket",
+		"backslash", "semicolon", "quote", "comma", "period", "slash",
+		"kpplus", "kpminus", "kpmultiply", "kpdivide", "kpperiod", "kpenter",
+	} {
+		names[name] = true
+	}
+
+	return names
+}()
+
+// Actions which only the frontend can carry out.
+var frontendActions = map[string]bool{
+	"overscan": true,
+	"size-1":   true,
+	"size-2":   true,
+	"size-3":   true,
+	"size-4":   true,
+	"size-5":   true,
+}
+
+// Events for actions carried out when their key is pressed.
+var hotkeyActions = map[string]func() Event{
+	"pause":               func() Event { return &PauseEvent{} },
+	"frame-advance":       func() Event { return &StepEvent{Mode: FrameStep} },
+	"scanline-step":       func() Event { return &StepEvent{Mode: ScanlineStep} },
+	"instruction-step":    func() Event { return &StepEvent{Mode: InstructionStep} },
+	"reset":               func() Event { return &ResetEvent{} },
+	"quit":                func() Event { return &QuitEvent{} },
+	"record":              func() Event { return &RecordEvent{} },
+	"stop-recording":      func() Event { return &StopEvent{} },
+	"audio-record":        func() Event { return &AudioRecordEvent{} },
+	"audio-stop":          func() Event { return &AudioStopEvent{} },
+	"cpu-decode":          func() Event { return &CPUDecodeEvent{} },
+	"ppu-decode":          func() Event { return &PPUDecodeEvent{} },
+	"show-background":     func() Event { return &ShowBackgroundEvent{} },
+	"show-sprites":        func() Event { return &ShowSpritesEvent{} },
+	"save-pattern-tables": func() Event { return &SavePatternTablesEvent{} },
+	"save-state":          func() Event { return &SaveStateEvent{} },
+	"load-state":          func() Event { return &LoadStateEvent{} },
+	"next-slot":           func() Event { return &SlotEvent{Delta: 1} },
+	"previous-slot":       func() Event { return &SlotEvent{Delta: -1} },
+	"movie-read-only":     func() Event { return &MovieReadOnlyEvent{} },
+	"lag-counter":         func() Event { return &LagCounterEvent{} },
+	"fps-200":             func() Event { return &FPSEvent{2.} },
+	"fps-100":             func() Event { return &FPSEvent{1.} },
+	"fps-75":              func() Event { return &FPSEvent{.75} },
+	"fps-50":              func() Event { return &FPSEvent{.5} },
+	"fps-25":              func() Event { return &FPSEvent{.25} },
+	"speed-up":            func() Event { return &SpeedEvent{Delta: 1} },
+	"slow-down":           func() Event { return &SpeedEvent{Delta: -1} },
+	"speed-unlimited":     func() Event { return &FPSEvent{UnlimitedSpeed} },
+	"mute":                func() Event { return &MuteEvent{} },
+	"mute-pulse1":         func() Event { return &MutePulse1Event{} },
+	"mute-pulse2":         func() Event { return &MutePulse2Event{} },
+	"mute-triangle":       func() Event { return &MuteTriangleEvent{} },
+	"mute-noise":          func() Event { return &MuteNoiseEvent{} },
+	"mute-dmc":            func() Event { return &MuteDMCEvent{} },
+}
+
+func init() {
+	for i := 0; i <= 9; i++ {
+		slot := i
+		hotkeyActions["slot-"+strconv.Itoa(i)] = func() Event { return &SlotEvent{Slot: slot} }
+	}
+}
+
+// Events for actions which last as long as their key is held down.
+var heldActions = map[string]func(down bool) Event{
+	"fast-forward": func(down bool) Event { return &FastForwardEvent{Down: down} },
+	"microphone":   func(down bool) Event { return &MicrophoneEvent{Blowing: down} },
+	"vs-coin1":     func(down bool) Event { return &VSCoinEvent{Slot: 0, Down: down} },
+	"vs-coin2":     func(down bool) Event { return &VSCoinEvent{Slot: 1, Down: down} },
+	"vs-service":   func(down bool) Event { return &VSServiceEvent{Down: down} },
+}
+
+var DefaultKeyBindings = map[string]string{
+	"z":      "player1.a",
+	"x":      "player1.b",
+	"enter":  "player1.start",
+	"rshift": "player1.select",
+	"up":     "player1.up",
+	"down":   "player1.down",
+	"left":   "player1.left",
+	"right":  "player1.right",
+
+	"k": "player3.a",
+	"j": "player3.b",
+	"y": "player3.start",
+	"u": "player3.select",
+	"t": "player3.up",
+	"g": "player3.down",
+	"f": "player3.left",
+	"h": "player3.right",
+
+	"pageup":    "player4.a",
+	"insert":    "player4.b",
+	"backspace": "player4.start",
+	"tab":       "player4.select",
+	"home":      "player4.up",
+	"end":       "player4.down",
+	"delete":    "player4.left",
+	"pagedown":  "player4.right",
+
+	"p":         "pause",
+	"r":         "reset",
+	"q":         "quit",
+	"f1":        "save-state",
+	"f5":        "load-state",
+	"f3":        "previous-slot",
+	"f4":        "next-slot",
+	"f8":        "fps-200",
+	"f9":        "fps-100",
+	"f10":       "fps-75",
+	"f11":       "fps-50",
+	"f12":       "fps-25",
+	"space":     "fast-forward",
+	"backquote": "overscan",
+	"1":         "size-1",
+	"2":         "size-2",
+	"3":         "size-3",
+	"4":         "size-4",
+	"5":         "size-5",
+	"9":         "show-background",
+	"0":         "show-sprites",
+	"kp0":       "mute",
+	"kp1":       "mute-pulse1",
+	"kp2":       "mute-pulse2",
+	"kp3":       "mute-triangle",
+	"kp4":       "mute-noise",
+	"kp5":       "mute-dmc",
+	"l":         "save-pattern-tables",
+	"s":         "record",
+	"d":         "stop-recording",
+	"kpplus":    "audio-record",
+	"kpminus":   "audio-stop",
+	"o":         "cpu-decode",
+	"i":         "ppu-decode",
+	"c":         "vs-coin1",
+	"v":         "vs-coin2",
+	"b":         "vs-service",
+	"f2":        "powerpad-keys",
+	"m":         "microphone",
+	"n":         "frame-advance",
+	"w":         "movie-read-only",
+	"e":         "lag-counter",
+	"equals":    "speed-up",
+	"minus":     "slow-down",
+}
+
+// The keys stepping on the Power Pad's positions 1-12 while Power Pad
+// keys are switched on.
+var DefaultPowerPadBindings = map[string]int{
+	"o": 1, "p": 2, "leftbracket": 3, "rightbracket": 4,
+	"k": 5, "l": 6, "semicolon": 7, "quote": 8,
+	"m": 9, "comma": 10, "period": 11, "slash": 12,
+}
+
+// The bindings section of ~/.nintengorc.  Keys binds key names to
+// actions, replacing the default binding of each key given, and 'none'
+// unbinds a key.  PowerPad binds key names to Power Pad positions in
+// the same way.
+type Bindings struct {
+	Keys     map[string]string `yaml:"keys"`
+	PowerPad map[string]int    `yaml:"powerpad"`
+}
+
+// Returns the controller (0-3) and button of a playerN.button action.
+func parsePlayerAction(action string) (controller int, btn Button, ok bool) {
+	i := strings.Index(action, ".")
+
+	if i < 0 || !strings.HasPrefix(action, "player") {
+		return
+	}
+
+	player, err := strconv.Atoi(action[len("player"):i])
+
+	if err != nil || player < 1 || player > 4 {
+		return
+	}
+
+	if btn, err = ParseButton(action[i+1:]); err != nil {
+		return
+	}
+
+	return player - 1, btn, true
+}
+
+func validAction(action string) bool {
+	_, _, ok := parsePlayerAction(action)
+	_, hotkey := hotkeyActions[action]
+	_, held := heldActions[action]
+
+	return ok || hotkey || held || frontendActions[action] || action == "powerpad-keys"
+}
+
+// Turns key presses, by key name, into events according to the
+// default bindings and those given in ~/.nintengorc.
+type KeyBindings struct {
+	keys         map[string]string
+	powerPad     map[string]int
+	powerPadKeys bool
+}
+
+func NewKeyBindings(config *Bindings) (kb *KeyBindings, err error) {
+	kb = &KeyBindings{
+		keys:     map[string]string{},
+		powerPad: map[string]int{},
+	}
+
+	for key, action := range DefaultKeyBindings {
+		kb.keys[key] = action
+	}
+
+	for key, position := range DefaultPowerPadBindings {
+		kb.powerPad[key] = position
+	}
+
+	if config == nil {
+		return
+	}
+
+	for key, action := range config.Keys {
+		key, action = strings.ToLower(key), strings.ToLower(action)
+
+		switch {
+		case !keyNames[key]:
+			err = fmt.Errorf("Invalid key %v in bindings", key)
+			return
+		case action == "none":
+			delete(kb.keys, key)
+		case !validAction(action):
+			err = fmt.Errorf("Invalid action %v for key %v in bindings", action, key)
+			return
+		default:
+			kb.keys[key] = action
+		}
+	}
+
+	for key, position := range config.PowerPad {
+		key = strings.ToLower(key)
+
+		switch {
+		case !keyNames[key]:
+			err = fmt.Errorf("Invalid key %v in Power Pad bindings", key)
+			return
+		case position == 0:
+			delete(kb.powerPad, key)
+		case position < 0 || position > 12:
+			err = fmt.Errorf("Invalid Power Pad position %v for key %v in bindings, must be 1-12", position, key)
+			return
+		default:
+			kb.powerPad[key] = position
+		}
+	}
+
+	return
+}
+
+// Returns the action bound to key, or an empty string if there is
+// none.
+func (kb *KeyBindings) Action(key string) string {
+	return kb.keys[key]
+}
+
+// Returns the event for key being pressed or released, or nil if there
+// is none.  action is the action bound to key, which the frontend
+// carries out itself if it is one of its own.
+func (kb *KeyBindings) Handle(key string, down bool) (event Event, action string) {
+	if position, ok := kb.powerPad[key]; ok && kb.powerPadKeys {
+		return &PowerPadEvent{Position: position, Down: down}, ""
+	}
+
+	action = kb.keys[key]
+
+	if controller, btn, ok := parsePlayerAction(action); ok {
+		event = &ControllerEvent{
+			Controller: controller,
+			Button:     btn,
+			Down:       down,
+		}
+
+		return
+	}
+
+	if newEvent, ok := heldActions[action]; ok {
+		return newEvent(down), action
+	}
+
+	if !down {
+		return
+	}
+
+	if newEvent, ok := hotkeyActions[action]; ok {
+		return newEvent(), action
+	}
+
+	if action == "powerpad-keys" {
+		kb.powerPadKeys = !kb.powerPadKeys
+		fmt.Println("*** Power Pad keys:", kb.powerPadKeys)
+	}
+
+	return
+}
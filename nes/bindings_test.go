@@ -0,0 +1,82 @@
+package nes
+
+import "testing"
+
+func TestKeyBindings(t *testing.T) {
+	kb, err := NewKeyBindings(&Bindings{
+		Keys: map[string]string{
+			"a":  "player2.B",
+			"z":  "none",
+			"f6": "slot-3",
+		},
+		PowerPad: map[string]int{
+			"q": 1,
+			"o": 0,
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("Error creating key bindings: %v", err)
+	}
+
+	if ev, _ := kb.Handle("a", true); ev == nil || *ev.(*ControllerEvent) != (ControllerEvent{Controller: 1, Button: B, Down: true}) {
+		t.Errorf("Event for a is %v not player 2 B down", ev)
+	}
+
+	if ev, _ := kb.Handle("z", true); ev != nil {
+		t.Errorf("Event for unbound z is %v not nil", ev)
+	}
+
+	// the defaults are kept for keys not given
+	if ev, _ := kb.Handle("x", false); ev == nil || *ev.(*ControllerEvent) != (ControllerEvent{Controller: 0, Button: B, Down: false}) {
+		t.Errorf("Event for x is %v not player 1 B up", ev)
+	}
+
+	if ev, _ := kb.Handle("f6", true); ev == nil || ev.(*SlotEvent).Slot != 3 {
+		t.Errorf("Event for f6 is %v not slot 3", ev)
+	}
+
+	// hotkeys only act when pressed
+	if ev, _ := kb.Handle("p", false); ev != nil {
+		t.Errorf("Event for releasing p is %v not nil", ev)
+	}
+
+	if ev, action := kb.Handle("backquote", true); ev != nil || action != "overscan" {
+		t.Errorf("Event and action for backquote are %v, %v not nil, overscan", ev, action)
+	}
+
+	if ev, _ := kb.Handle("space", false); ev == nil || ev.(*FastForwardEvent).Down {
+		t.Errorf("Event for releasing space is %v not fast-forward let up", ev)
+	}
+
+	kb.Handle("f2", true)
+
+	if ev, _ := kb.Handle("q", true); ev == nil || *ev.(*PowerPadEvent) != (PowerPadEvent{Position: 1, Down: true}) {
+		t.Errorf("Event for q is %v not Power Pad 1 down", ev)
+	}
+
+	// unbound from the Power Pad, o keeps its usual binding
+	if ev, _ := kb.Handle("o", true); ev == nil || ev.String() != "CPUDecodeEvent" {
+		t.Errorf("Event for o is %v not CPUDecodeEvent", ev)
+	}
+
+	kb.Handle("f2", true)
+
+	if ev, action := kb.Handle("q", true); ev == nil || ev.String() != "QuitEvent" || action != "quit" {
+		t.Errorf("Event and action for q are %v, %v not QuitEvent, quit", ev, action)
+	}
+}
+
+func TestKeyBindingErrors(t *testing.T) {
+	for _, b := range []Bindings{
+		{Keys: map[string]string{"hyper": "pause"}},
+		{Keys: map[string]string{"a": "player5.a"}},
+		{Keys: map[string]string{"a": "player1.turbo"}},
+		{Keys: map[string]string{"a": "explode"}},
+		{PowerPad: map[string]int{"a": 13}},
+	} {
+		if _, err := NewKeyBindings(&b); err == nil {
+			t.Errorf("No error creating key bindings %+v", b)
+		}
+	}
+}
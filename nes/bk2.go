@@ -0,0 +1,276 @@
+package nes
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// The input log key BizHawk writes for two NES controllers, used when
+// a movie's input log lacks one.
+const bk2DefaultLogKey = "#Reset|Power|" +
+	"#P1 Up|P1 Down|P1 Left|P1 Right|P1 Start|P1 Select|P1 B|P1 A|" +
+	"#P2 Up|P2 Down|P2 Left|P2 Right|P2 Start|P2 Select|P2 B|P2 A|"
+
+// The NES controller buttons as named in BizHawk's input logs.
+var bk2Buttons = map[string]Button{
+	"A":      A,
+	"B":      B,
+	"Select": Select,
+	"Start":  Start,
+	"Up":     Up,
+	"Down":   Down,
+	"Left":   Left,
+	"Right":  Right,
+}
+
+// A button in a BizHawk input log: a console command, or a controller
+// (0-3) and button.
+type bk2Button struct {
+	command    uint8
+	controller int
+	button     Button
+}
+
+// Parses the LogKey line of a BizHawk input log, which names the
+// buttons of each column, in groups beginning with '#'.
+func parseBK2LogKey(key string) (groups [][]bk2Button, err error) {
+	for _, group := range strings.Split(key, "#")[1:] {
+		var buttons []bk2Button
+
+		for _, name := range strings.Split(group, "|") {
+			var b bk2Button
+
+			switch {
+			case name == "":
+				continue
+			case name == "Reset":
+				b.command = MovieSoftReset
+			case name == "Power":
+				b.command = MovieHardReset
+			default:
+				var ok bool
+
+				i := strings.Index(name, " ")
+
+				if i > 0 && strings.HasPrefix(name, "P") {
+					b.controller, err = strconv.Atoi(name[1:i])
+					b.button, ok = bk2Buttons[name[i+1:]]
+				}
+
+				if err != nil || !ok || b.controller < 1 || b.controller > 4 {
+					err = fmt.Errorf("Unsupported input '%v'", name)
+					return
+				}
+
+				b.controller--
+			}
+
+			buttons = append(buttons, b)
+		}
+
+		groups = append(groups, buttons)
+	}
+
+	return
+}
+
+// Parses a line of a BizHawk input log, which gives each group of
+// columns between |s, a button being held down unless its column is
+// '.'.
+func parseBK2Frame(groups [][]bk2Button, line string) (frame MovieFrame, err error) {
+	fields := strings.Split(line, "|")
+
+	if len(fields) < len(groups)+2 {
+		err = fmt.Errorf("Invalid input log line '%v'", line)
+		return
+	}
+
+	for i, buttons := range groups {
+		for j, c := range fields[i+1] {
+			if j >= len(buttons) || c == '.' || c == ' ' {
+				continue
+			}
+
+			if b := buttons[j]; b.command != 0 {
+				frame.Commands |= b.command
+			} else {
+				frame.Buttons[b.controller] |= 1 << b.button
+			}
+		}
+	}
+
+	return
+}
+
+// Reads the Header.txt of a BizHawk movie, a key and value per line.
+func (m *Movie) readBK2Header(reader io.Reader) (err error) {
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value := line, ""
+
+		if i := strings.Index(line, " "); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+
+		switch key {
+		case "Platform":
+			if value != "NES" {
+				return fmt.Errorf("Movie is for %v, not the NES", value)
+			}
+		case "GameName":
+			m.ROMFilename = value
+		case "SHA1":
+			m.ROMSHA1, err = hex.DecodeString(value)
+		case "rerecordCount":
+			m.RerecordCount, err = strconv.Atoi(value)
+		case "PAL":
+			m.PAL = strings.EqualFold(value, "True")
+		case "Author":
+			m.Comments = append(m.Comments, "author "+value)
+		case "StartsFromSavestate", "StartsFromSaveRam":
+			if strings.EqualFold(value, "True") {
+				return errors.New("Movies starting from a save state cannot be imported")
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("Invalid %v '%v': %v", key, value, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Reads the Input Log.txt of a BizHawk movie.
+func (m *Movie) readBK2Input(reader io.Reader) (err error) {
+	var groups [][]bk2Button
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		switch {
+		case strings.HasPrefix(line, "LogKey:"):
+			if groups, err = parseBK2LogKey(strings.TrimPrefix(line, "LogKey:")); err != nil {
+				return
+			}
+		case strings.HasPrefix(line, "|"):
+			var frame MovieFrame
+
+			if groups == nil {
+				if groups, err = parseBK2LogKey(bk2DefaultLogKey); err != nil {
+					return
+				}
+			}
+
+			if frame, err = parseBK2Frame(groups, line); err != nil {
+				return
+			}
+
+			m.Frames = append(m.Frames, frame)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	for _, buttons := range groups {
+		for _, b := range buttons {
+			if b.command == 0 {
+				if b.controller >= 2 {
+					m.FourScore = true
+				} else {
+					m.Ports[b.controller] = FM2Gamepad
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// Reads a movie from a BizHawk .bk2 archive, of which the header,
+// input log and comments are imported.  Only standard controllers,
+// with or without a Four Score, are supported.
+func ReadBK2(reader io.ReaderAt, size int64) (m *Movie, err error) {
+	var z *zip.Reader
+
+	if z, err = zip.NewReader(reader, size); err != nil {
+		return
+	}
+
+	m = &Movie{
+		Version: 3,
+		Ports:   [3]int{FM2None, FM2None, FM2None},
+	}
+
+	if m.GUID, err = newGUID(); err != nil {
+		return
+	}
+
+	input := false
+
+	for _, f := range z.File {
+		var rc io.ReadCloser
+
+		switch f.Name {
+		case "Header.txt", "Input Log.txt", "Comments.txt":
+		default:
+			continue
+		}
+
+		if rc, err = f.Open(); err != nil {
+			return
+		}
+
+		switch f.Name {
+		case "Header.txt":
+			err = m.readBK2Header(rc)
+		case "Input Log.txt":
+			input = true
+			err = m.readBK2Input(rc)
+		case "Comments.txt":
+			var data []byte
+
+			if data, err = ioutil.ReadAll(rc); err == nil {
+				for _, comment := range strings.Split(string(data), "\n") {
+					if comment = strings.TrimSpace(comment); comment != "" {
+						m.Comments = append(m.Comments, comment)
+					}
+				}
+			}
+		}
+
+		rc.Close()
+
+		if err != nil {
+			err = fmt.Errorf("Error reading %v: %v", f.Name, err)
+			return
+		}
+	}
+
+	if !input {
+		err = errors.New("Movie has no Input Log.txt")
+	}
+
+	return
+}
+
+// Returns the SHA-1 sum of the PRG and CHR data of rom, by which
+// BizHawk movies identify it.
+func ROMSHA1(rom *ROMFile) []byte {
+	return romHash(sha1.New(), rom)
+}
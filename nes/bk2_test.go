@@ -0,0 +1,127 @@
+package nes
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Returns a .bk2 archive of files, given as names and contents.
+func testBK2(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+
+	z := zip.NewWriter(&buf)
+
+	for i := 0; i+1 < len(files); i += 2 {
+		name, data := files[i], files[i+1]
+		w, err := z.Create(name)
+
+		if err != nil {
+			t.Fatalf("Error creating %v: %v", name, err)
+		}
+
+		w.Write([]byte(data))
+	}
+
+	if err := z.Close(); err != nil {
+		t.Fatalf("Error writing archive: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReadBK2(t *testing.T) {
+	data := testBK2(t,
+		"Header.txt", `MovieVersion BizHawk v2.0.0
+Author someone
+emuVersion Version 2.3
+Platform NES
+GameName Super Mario Bros.
+SHA1 EA343F4E445A9050D4B4FBAC2C77D0693B1D0922
+rerecordCount 42
+`,
+		"Input Log.txt", `[Input]
+LogKey:#Reset|Power|#P1 Up|P1 Down|P1 Left|P1 Right|P1 Start|P1 Select|P1 B|P1 A|#P2 Up|P2 Down|P2 Left|P2 Right|P2 Start|P2 Select|P2 B|P2 A|
+|..|........|........|
+|.P|...R...A|........|
+|r.|U...S.B.|.D.....s|
+[/Input]
+`,
+		"Comments.txt", "first try\n",
+	)
+
+	m, err := ReadBK2(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		t.Fatalf("Error reading movie: %v", err)
+	}
+
+	if m.RerecordCount != 42 || m.ROMFilename != "Super Mario Bros." || len(m.ROMSHA1) != 20 {
+		t.Errorf("Header read as %+v", m)
+	}
+
+	if m.Ports != [3]int{FM2Gamepad, FM2Gamepad, FM2None} || m.FourScore {
+		t.Errorf("Ports read as %v", m.Ports)
+	}
+
+	if len(m.Comments) != 2 || m.Comments[0] != "author someone" || m.Comments[1] != "first try" {
+		t.Errorf("Comments read as %v", m.Comments)
+	}
+
+	expected := []MovieFrame{
+		{},
+		{Commands: MovieHardReset, Buttons: [4]uint8{1<<Right | 1<<A}},
+		{Commands: MovieSoftReset, Buttons: [4]uint8{1<<Up | 1<<Start | 1<<B, 1<<Down | 1<<A}},
+	}
+
+	if len(m.Frames) != len(expected) {
+		t.Fatalf("Read %v frames not %v", len(m.Frames), len(expected))
+	}
+
+	for i, frame := range expected {
+		if m.Frames[i] != frame {
+			t.Errorf("Frame %v read as %+v not %+v", i, m.Frames[i], frame)
+		}
+	}
+
+	data = testBK2(t,
+		"Header.txt", "Platform NES\n",
+		"Input Log.txt", "LogKey:#Reset|Power|#P1 Zapper X|P1 Zapper Y|P1 Fire|\n|..|   0,   0,.|\n",
+	)
+
+	if _, err = ReadBK2(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("No error reading movie with a zapper")
+	}
+
+	data = testBK2(t,
+		"Header.txt", "Platform SNES\n",
+		"Input Log.txt", "",
+	)
+
+	if _, err = ReadBK2(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("No error reading SNES movie")
+	}
+}
+
+func TestDesyncReport(t *testing.T) {
+	hashes, err := ReadHashLog(strings.NewReader("# frame hash\n0 0000000000000001\n1 0x2\n\n2 3\n"))
+
+	if err != nil {
+		t.Fatalf("Error reading hash log: %v", err)
+	}
+
+	report := &DesyncReport{expected: hashes, First: -1}
+
+	for frame, hash := range []uint64{1, 2, 4, 5} {
+		report.frame(frame, hash)
+	}
+
+	if report.Compared != 3 || report.Desynced != 1 || report.First != 2 {
+		t.Errorf("Report is %v", report)
+	}
+
+	if _, err = ReadHashLog(strings.NewReader("0 xyz\n")); err == nil {
+		t.Error("No error reading invalid hash log")
+	}
+}
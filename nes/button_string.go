@@ -4,9 +4,9 @@ package nes
 
 import "fmt"
 
-const _Button_name = "ABSelectStartUpDownLeftRightOne"
+const _Button_name = "ABSelectStartUpDownLeftRightOneTurboATurboB"
 
-var _Button_index = [...]uint8{0, 1, 2, 8, 13, 15, 19, 23, 28, 31}
+var _Button_index = [...]uint8{0, 1, 2, 8, 13, 15, 19, 23, 28, 31, 37, 43}
 
 func (i Button) String() string {
 	if i+1 >= Button(len(_Button_index)) {
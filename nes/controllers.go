@@ -1,6 +1,12 @@
 package nes
 
-import "github.com/nwidger/nintengo/rp2ago3"
+import (
+	"fmt"
+	"image/color"
+	"strings"
+
+	"github.com/nwidger/nintengo/rp2ago3"
+)
 
 //go:generate stringer -type=Button
 type Button uint8
@@ -15,8 +21,13 @@ const (
 	Left
 	Right
 	One
+	TurboA
+	TurboB
 )
 
+// The buttons which can be pressed on a controller.
+var Buttons = []Button{A, B, Select, Start, Up, Down, Left, Right, TurboA, TurboB}
+
 func (btn Button) Valid() bool {
 	switch btn {
 	case A, B, Select, Start, Up, Down, Left, Right:
@@ -26,27 +37,455 @@ func (btn Button) Valid() bool {
 	return false
 }
 
+type Port uint8
+
+const (
+	Port1 Port = iota
+	Port2
+	ExpansionPort
+)
+
+func (port Port) String() string {
+	switch port {
+	case Port1:
+		return "port 1"
+	case Port2:
+		return "port 2"
+	case ExpansionPort:
+		return "expansion port"
+	}
+
+	return fmt.Sprintf("Port(%d)", port)
+}
+
+// A peripheral plugged into one of the controller ports or the Famicom
+// expansion port.
+type InputDevice interface {
+	Reset()
+	// Called with the value written to $4016, bit 0 of which is
+	// the strobe line shared by all ports.
+	Strobe(value uint8)
+	// Returns bits 0-4 of a read of $4016 or $4017 as driven by
+	// the device.  Devices in the controller ports are only read
+	// through their own port's register, devices in the expansion
+	// port through both.
+	Read(address uint16) uint8
+	KeyDown(btn Button)
+	KeyUp(btn Button)
+	// Returns a function putting the device back in the state it
+	// is in now, for run-ahead and the TAS editor to go back to.
+	save() func()
+}
+
+// Constructors for the input devices that can be chosen by name, each
+// returns an error if the device cannot be plugged into the given
+// port.
+var inputDevices = map[string]func(ctrls *Controllers, port Port) (InputDevice, error){
+	"none": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		return nil, nil
+	},
+	"joypad": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		if port == ExpansionPort {
+			return nil, fmt.Errorf("joypad cannot be plugged into the %v", port)
+		}
+
+		return NewController(), nil
+	},
+	"fourscore": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		if port == ExpansionPort {
+			return nil, fmt.Errorf("fourscore cannot be plugged into the %v", port)
+		}
+
+		return NewFourScore(false), nil
+	},
+	"hori": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		if port != ExpansionPort {
+			return nil, fmt.Errorf("hori can only be plugged into the %v", ExpansionPort)
+		}
+
+		return NewFourScore(true), nil
+	},
+	"zapper": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		return NewZapper(ctrls.ppu, ctrls.palette(), port == ExpansionPort), nil
+	},
+	"vaus": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		return vausFromOptions(ctrls.options, port == ExpansionPort)
+	},
+	"famicom": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		if port != Port2 {
+			return nil, fmt.Errorf("famicom can only be plugged into %v", Port2)
+		}
+
+		return famicomControllerFromOptions(ctrls.options)
+	},
+	"powerpad": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		if port == ExpansionPort {
+			return nil, fmt.Errorf("powerpad cannot be plugged into the %v", port)
+		}
+
+		return NewPowerPad(false), nil
+	},
+	"powerpad-a": func(ctrls *Controllers, port Port) (InputDevice, error) {
+		if port == ExpansionPort {
+			return nil, fmt.Errorf("powerpad-a cannot be plugged into the %v", port)
+		}
+
+		return NewPowerPad(true), nil
+	},
+}
+
+// How a controller handles opposing directions on the Control Pad,
+// which cannot be pressed together on a real one but which some
+// glitches need.
+type Opposing uint8
+
+const (
+	// Up+Down and Left+Right are ignored, the direction held
+	// first wins.
+	FilterOpposing Opposing = iota
+	// Opposing directions are pressed together.
+	AllowOpposing
+	// The direction pressed last wins, the other one is pressed
+	// again when it is let up if it is still held down.
+	LastOpposingWins
+)
+
+func (o Opposing) String() string {
+	switch o {
+	case FilterOpposing:
+		return "filter"
+	case AllowOpposing:
+		return "allow"
+	case LastOpposingWins:
+		return "last"
+	}
+
+	return fmt.Sprintf("Opposing(%d)", o)
+}
+
+// Returns the Opposing with the given name, filter if name is empty.
+func ParseOpposing(name string) (o Opposing, err error) {
+	switch strings.ToLower(name) {
+	case "", "filter":
+		o = FilterOpposing
+	case "allow":
+		o = AllowOpposing
+	case "last":
+		o = LastOpposingWins
+	default:
+		err = fmt.Errorf("Invalid opposing directions %v, must be allow, filter or last", name)
+	}
+
+	return
+}
+
+// Returns the direction opposite btn, or One if btn is not a
+// direction.
+func (btn Button) opposite() Button {
+	switch btn {
+	case Up:
+		return Down
+	case Down:
+		return Up
+	case Left:
+		return Right
+	case Right:
+		return Left
+	}
+
+	return One
+}
+
+// The standard controller.
 type Controller struct {
-	strobe  Button
-	buttons uint8
+	latch    uint8
+	strobe   Button
+	buttons  uint8
+	held     uint8
+	opposing *Opposing
+}
+
+func NewController() *Controller {
+	return &Controller{}
+}
+
+func (ctrl *Controller) Reset() {
+	ctrl.latch = 0
+	ctrl.strobe = A
+	ctrl.buttons = 0
+	ctrl.held = 0
+}
+
+func (ctrl *Controller) save() func() {
+	saved := *ctrl
+	return func() { *ctrl = saved }
+}
+
+func (ctrl *Controller) Strobe(value uint8) {
+	if ctrl.latch == 1 && value&0x01 == 0 {
+		ctrl.strobe = A
+	}
+
+	ctrl.latch = value & 0x01
+}
+
+func (ctrl *Controller) Read(address uint16) (value uint8) {
+	if ctrl.strobe == One {
+		value = 1
+	} else {
+		value = (ctrl.buttons >> ctrl.strobe) & 0x01
+		ctrl.strobe++
+	}
+
+	return
+}
+
+func (ctrl *Controller) Opposing() Opposing {
+	if ctrl.opposing == nil {
+		return FilterOpposing
+	}
+
+	return *ctrl.opposing
+}
+
+func (ctrl *Controller) KeyIsDown(btn Button) bool {
+	return ctrl.buttons&(1<<btn) != 0
+}
+
+func (ctrl *Controller) ValidKeyDown(btn Button) (valid bool) {
+	valid = btn.Valid()
+
+	if ctrl.Opposing() != FilterOpposing {
+		return
+	}
+
+	if opposite := btn.opposite(); opposite != One && ctrl.KeyIsDown(opposite) {
+		valid = false
+	}
+
+	return
+}
+
+func (ctrl *Controller) KeyDown(btn Button) {
+	if !btn.Valid() {
+		return
+	}
+
+	ctrl.held |= 1 << btn
+
+	if opposite := btn.opposite(); opposite != One && ctrl.Opposing() == LastOpposingWins {
+		ctrl.buttons &^= 1 << opposite
+	}
+
+	if ctrl.ValidKeyDown(btn) {
+		ctrl.buttons |= (1 << uint8(btn))
+	}
+}
+
+func (ctrl *Controller) KeyUp(btn Button) {
+	if !btn.Valid() {
+		return
+	}
+
+	ctrl.held &^= 1 << btn
+	ctrl.buttons &^= (1 << uint8(btn))
+
+	if opposite := btn.opposite(); opposite != One && ctrl.Opposing() == LastOpposingWins && ctrl.held&(1<<opposite) != 0 {
+		ctrl.buttons |= 1 << opposite
+	}
 }
 
 type Controllers struct {
-	last        uint8
-	controllers [2]Controller
+	last     uint8
+	devices  [3]InputDevice
+	vs       *VSSystem
+	ppu      screen
+	options  *Options
+	turbo    *Turbo
+	opposing *Opposing
+	polled   bool // read since the end of the last frame
 }
 
 func NewControllers() *Controllers {
-	return &Controllers{}
+	turbo, _ := NewTurbo(DefaultTurboOn, DefaultTurboOff)
+
+	ctrls := &Controllers{
+		devices:  [3]InputDevice{NewController(), NewController(), nil},
+		turbo:    turbo,
+		opposing: new(Opposing),
+	}
+
+	ctrls.attach(ctrls.devices[Port1])
+	ctrls.attach(ctrls.devices[Port2])
+
+	return ctrls
+}
+
+// Makes the controllers of device handle opposing directions the way
+// set for all controllers.
+func (ctrls *Controllers) attach(device InputDevice) {
+	switch d := device.(type) {
+	case *Controller:
+		d.opposing = ctrls.opposing
+	case *FamicomController:
+		d.opposing = ctrls.opposing
+	case Multitap:
+		for player := 0; player < 4; player++ {
+			if ctrl := d.Controller(player); ctrl != nil {
+				ctrl.opposing = ctrls.opposing
+			}
+		}
+	}
+}
+
+// Plugs the named device into port, replacing whatever was plugged in
+// before.  Plugging in 'none' leaves the port empty.
+func (ctrls *Controllers) Plug(port Port, name string) (err error) {
+	newDevice, ok := inputDevices[strings.ToLower(name)]
+
+	if !ok {
+		err = fmt.Errorf("Invalid input device %v for %v", name, port)
+		return
+	}
+
+	device, err := newDevice(ctrls, port)
+
+	if err != nil {
+		return
+	}
+
+	ctrls.devices[port] = device
+	ctrls.attach(device)
+
+	// the Four Score takes up both controller ports
+	if fs, ok := device.(*FourScore); ok && !fs.famicom {
+		ctrls.devices[Port1] = fs
+		ctrls.devices[Port2] = fs
+	}
+
+	return
+}
+
+// Plugs in the devices given in options or, failing that, in the ROM
+// database entry for romf.  romf may be nil.
+func (ctrls *Controllers) Configure(romf *ROMFile, options *Options) (err error) {
+	var game *GameInfo
+
+	if romf != nil {
+		game = LookupGame(romf.CRC32)
+	}
+
+	ctrls.options = options
+
+	if ctrls.turbo, err = NewTurbo(options.TurboOn, options.TurboOff); err != nil {
+		return
+	}
+
+	if *ctrls.opposing, err = ParseOpposing(options.Opposing); err != nil {
+		return
+	}
+
+	for _, port := range []Port{Port1, Port2, ExpansionPort} {
+		name := ""
+
+		if game != nil {
+			switch port {
+			case Port1:
+				name = game.Port1
+			case Port2:
+				name = game.Port2
+			case ExpansionPort:
+				name = game.Expansion
+			}
+		}
+
+		switch {
+		case port == Port1 && options.Port1 != "":
+			name = options.Port1
+		case port == Port2 && options.Port2 != "":
+			name = options.Port2
+		case port == ExpansionPort && options.Expansion != "":
+			name = options.Expansion
+		}
+
+		if name == "" {
+			continue
+		}
+
+		if err = ctrls.Plug(port, name); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Returns the device plugged into port, or nil if there is none.
+func (ctrls *Controllers) Device(port Port) InputDevice {
+	return ctrls.devices[port]
+}
+
+// Returns the palette the PPU draws with, as seen by light guns.
+func (ctrls *Controllers) palette() []color.Color {
+	if ctrls.vs != nil {
+		if palette, err := VSPalette(ctrls.vs.PPU); err == nil {
+			return palette
+		}
+	}
+
+	return RGBAPalette
 }
 
 func (ctrls *Controllers) Reset() {
-	for i := range ctrls.controllers {
-		ctrls.controllers[i].strobe = A
-		ctrls.controllers[i].buttons = 0
+	for _, device := range ctrls.devices {
+		if device != nil {
+			device.Reset()
+		}
+	}
+
+	ctrls.turbo.Frame = 0
+}
+
+// Returns a function putting the controllers, the devices plugged in
+// and turbo back in the state they are in now.
+func (ctrls *Controllers) save() func() {
+	last, polled, turbo := ctrls.last, ctrls.polled, *ctrls.turbo
+	restore := []func(){}
+
+	for _, device := range ctrls.devices {
+		if device != nil {
+			restore = append(restore, device.save())
+		}
+	}
+
+	return func() {
+		ctrls.last, ctrls.polled, *ctrls.turbo = last, polled, turbo
+
+		for _, f := range restore {
+			f()
+		}
 	}
 }
 
+// Called at the end of every frame to fire turbo buttons and play the
+// microphone's WAV file.
+func (ctrls *Controllers) Frame() {
+	ctrls.turbo.step(ctrls.set)
+
+	if fc, ok := ctrls.devices[Port2].(*FamicomController); ok {
+		fc.Frame()
+	}
+}
+
+// Returns true if the game did not read the controllers during the
+// frame which just ended, making it a lag frame.
+func (ctrls *Controllers) lagged() (lag bool) {
+	lag = !ctrls.polled
+	ctrls.polled = false
+
+	return
+}
+
 func (ctrls *Controllers) Mappings(which rp2ago3.Mapping) (fetch, store []uint16) {
 	switch which {
 	case rp2ago3.CPU:
@@ -60,17 +499,34 @@ func (ctrls *Controllers) Mappings(which rp2ago3.Mapping) (fetch, store []uint16
 func (ctrls *Controllers) Fetch(address uint16) (value uint8) {
 	switch address {
 	case 0x4016, 0x4017:
-		index := address - 0x4016
-		ctrl := &ctrls.controllers[index]
+		port := Port(address - 0x4016)
 
-		if ctrl.strobe == One {
-			value = 1
-		} else {
-			value = (ctrl.buttons >> ctrl.strobe) & 0x01
-			ctrl.strobe++
+		ctrls.polled = true
+
+		// Vs. System cabinets wire player 1 to $4017 and
+		// player 2 to $4016
+		if ctrls.vs != nil {
+			port ^= 0x01
+		}
+
+		if device := ctrls.devices[port]; device != nil {
+			value = device.Read(address) & 0x1f
+		}
+
+		if device := ctrls.devices[ExpansionPort]; device != nil {
+			value |= device.Read(address) & 0x1f
 		}
 
-		value |= 0x40
+		// the microphone on Famicom controller II
+		if fc, ok := ctrls.devices[Port2].(*FamicomController); ok && address == 0x4016 {
+			value |= fc.Microphone()
+		}
+
+		if ctrls.vs != nil {
+			value |= ctrls.vs.Fetch(address)
+		} else {
+			value |= 0x40
+		}
 	}
 
 	return
@@ -80,11 +536,11 @@ func (ctrls *Controllers) Store(address uint16, value uint8) (oldValue uint8) {
 	switch address {
 	case 0x4016:
 		oldValue = ctrls.last
-		ctrls.last = value & 0x01
+		ctrls.last = value & 0x07
 
-		if oldValue == 1 && value == 0 {
-			for i := range ctrls.controllers {
-				ctrls.controllers[i].strobe = A
+		for _, device := range ctrls.devices {
+			if device != nil {
+				device.Strobe(value)
 			}
 		}
 	}
@@ -92,32 +548,109 @@ func (ctrls *Controllers) Store(address uint16, value uint8) (oldValue uint8) {
 	return
 }
 
-func (ctrls *Controllers) KeyIsDown(controller int, btn Button) bool {
-	return ctrls.controllers[controller].buttons&(1<<btn) != 0
+// Returns the device which takes input for the given controller (0-3),
+// either a multitap with a controller for that player or the device
+// plugged into the controller port of the same number.
+func (ctrls *Controllers) player(controller int) InputDevice {
+	for _, device := range ctrls.devices {
+		if mt, ok := device.(Multitap); ok {
+			if ctrl := mt.Controller(controller); ctrl != nil {
+				return ctrl
+			}
+		}
+	}
+
+	if controller == 0 || controller == 1 {
+		return ctrls.devices[controller]
+	}
+
+	return nil
 }
 
-func (ctrls *Controllers) ValidKeyDown(controller int, btn Button) (valid bool) {
-	valid = btn.Valid()
+func (ctrls *Controllers) set(controller int, btn Button, down bool) {
+	device := ctrls.player(controller)
 
 	switch {
-	case btn == Up && ctrls.KeyIsDown(controller, Down),
-		btn == Down && ctrls.KeyIsDown(controller, Up),
-		btn == Left && ctrls.KeyIsDown(controller, Right),
-		btn == Right && ctrls.KeyIsDown(controller, Left):
-		valid = false
+	case device == nil, btn == One:
+	case down:
+		device.KeyDown(btn)
+	default:
+		device.KeyUp(btn)
+	}
+}
+
+// Sends a button press to the device taking input for the given
+// controller.  TurboA and TurboB press A and B on and off every few
+// frames for as long as they are held down.
+func (ctrls *Controllers) KeyDown(controller int, btn Button) {
+	ctrls.set(controller, ctrls.turbo.press(controller, btn, true), true)
+}
+
+func (ctrls *Controllers) KeyUp(controller int, btn Button) {
+	ctrls.set(controller, ctrls.turbo.press(controller, btn, false), false)
+}
+
+// Sends the mouse position in NES pixels and the state of its buttons
+// to every device which takes input from the mouse.
+func (ctrls *Controllers) Mouse(x, y int, left, right bool) {
+	for _, device := range ctrls.devices {
+		if md, ok := device.(MouseDevice); ok {
+			md.Mouse(x, y, left, right)
+		}
+	}
+}
+
+// Returns the standard controller taking input for the given
+// controller (0-3), or nil if there is none.
+func (ctrls *Controllers) controller(controller int) *Controller {
+	switch device := ctrls.player(controller).(type) {
+	case *Controller:
+		return device
+	case *FamicomController:
+		return device.Controller
+	}
+
+	return nil
+}
+
+// Returns the buttons held down on the given controller (0-3), as bits
+// 1<<A through 1<<Right.
+func (ctrls *Controllers) Buttons(controller int) (buttons uint8) {
+	if ctrl := ctrls.controller(controller); ctrl != nil {
+		buttons = ctrl.buttons
 	}
 
 	return
 }
 
-func (ctrls *Controllers) KeyDown(controller int, btn Button) {
-	if ctrls.ValidKeyDown(controller, btn) {
-		ctrls.controllers[controller].buttons |= (1 << uint8(btn))
+// Holds down exactly the given buttons on the given controller (0-3),
+// bypassing turbo and the handling of opposing directions.  Famicom
+// controller II still has no Select or Start.
+func (ctrls *Controllers) SetButtons(controller int, buttons uint8) {
+	if _, ok := ctrls.player(controller).(*FamicomController); ok {
+		buttons &^= 1<<Select | 1<<Start
+	}
+
+	if ctrl := ctrls.controller(controller); ctrl != nil {
+		ctrl.buttons = buttons
+		ctrl.held = buttons
 	}
 }
 
-func (ctrls *Controllers) KeyUp(controller int, btn Button) {
-	if btn.Valid() {
-		ctrls.controllers[controller].buttons &^= (1 << uint8(btn))
+// Blows into, or stops blowing into, the microphone of Famicom
+// controller II.
+func (ctrls *Controllers) Microphone(blowing bool) {
+	if fc, ok := ctrls.devices[Port2].(*FamicomController); ok {
+		fc.Blowing = blowing
+	}
+}
+
+// Sends a step on or off the given position (1-12) of the mat to every
+// Power Pad.
+func (ctrls *Controllers) PowerPad(position int, down bool) {
+	for _, device := range ctrls.devices {
+		if pp, ok := device.(*PowerPad); ok {
+			pp.Press(position, down)
+		}
 	}
 }
@@ -7,13 +7,13 @@ import (
 func TestControllers(t *testing.T) {
 	ctrls := NewControllers()
 
-	ctrls.controllers[0].buttons = 0x01
+	ctrls.devices[0].(*Controller).buttons = 0x01
 
 	if ctrls.Fetch(0x4016) != 0x41 {
 		t.Error("Memory is not 0x41")
 	}
 
-	ctrls.controllers[0].buttons = 0x00
+	ctrls.devices[0].(*Controller).buttons = 0x00
 
 	if ctrls.Fetch(0x4016) != 0x40 {
 		t.Error("Memory is not 0x40")
@@ -22,8 +22,8 @@ func TestControllers(t *testing.T) {
 	ctrls.Store(0x4016, 1)
 	ctrls.Store(0x4016, 0)
 
-	ctrls.controllers[0].buttons = 0xff
-	ctrls.controllers[1].buttons = 0xff
+	ctrls.devices[0].(*Controller).buttons = 0xff
+	ctrls.devices[1].(*Controller).buttons = 0xff
 
 	for i := 0; i < 20; i++ {
 		if ctrls.Fetch(0x4016) != 0x41 {
@@ -40,8 +40,8 @@ func TestControllers(t *testing.T) {
 	ctrls.Store(0x4016, 1)
 	ctrls.Store(0x4016, 0)
 
-	ctrls.controllers[0].buttons = 0x00
-	ctrls.controllers[1].buttons = 0x00
+	ctrls.devices[0].(*Controller).buttons = 0x00
+	ctrls.devices[1].(*Controller).buttons = 0x00
 
 	for i := 0; i < 20; i++ {
 		if i < 8 {
@@ -67,3 +67,191 @@ func TestControllers(t *testing.T) {
 		}
 	}
 }
+
+func TestInputDevices(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Plug(Port2, "none"); err != nil {
+		t.Fatalf("Error unplugging port 2: %v", err)
+	}
+
+	if ctrls.Device(Port2) != nil {
+		t.Error("Device still plugged into port 2")
+	}
+
+	if ctrls.Fetch(0x4017) != 0x40 {
+		t.Error("Memory is not 0x40")
+	}
+
+	if err := ctrls.Plug(ExpansionPort, "joypad"); err == nil {
+		t.Error("No error plugging joypad into expansion port")
+	}
+
+	if err := ctrls.Plug(Port1, "keyboard"); err == nil {
+		t.Error("No error plugging invalid device into port 1")
+	}
+
+	if err := ctrls.Configure(nil, &Options{Port2: "joypad"}); err != nil {
+		t.Fatalf("Error configuring input devices: %v", err)
+	}
+
+	if _, ok := ctrls.Device(Port2).(*Controller); !ok {
+		t.Error("Joypad not plugged into port 2")
+	}
+}
+
+func TestFourScore(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Plug(Port1, "fourscore"); err != nil {
+		t.Fatalf("Error plugging in Four Score: %v", err)
+	}
+
+	ctrls.KeyDown(0, A)
+	ctrls.KeyDown(1, B)
+	ctrls.KeyDown(2, Start)
+	ctrls.KeyDown(3, Right)
+
+	ctrls.Store(0x4016, 1)
+	ctrls.Store(0x4016, 0)
+
+	for address, expected := range map[uint16][]uint8{
+		0x4016: {
+			1, 0, 0, 0, 0, 0, 0, 0, // player 1
+			0, 0, 0, 1, 0, 0, 0, 0, // player 3
+			0, 0, 0, 1, 0, 0, 0, 0, // signature
+			1, 1,
+		},
+		0x4017: {
+			0, 1, 0, 0, 0, 0, 0, 0, // player 2
+			0, 0, 0, 0, 0, 0, 0, 1, // player 4
+			0, 0, 1, 0, 0, 0, 0, 0, // signature
+			1, 1,
+		},
+	} {
+		for i, bit := range expected {
+			if value := ctrls.Fetch(address); value != 0x40|bit {
+				t.Errorf("Read %v of $%04X is $%02X not $%02X", i, address, value, 0x40|bit)
+			}
+		}
+	}
+}
+
+func TestHori(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Plug(ExpansionPort, "hori"); err != nil {
+		t.Fatalf("Error plugging in Hori adapter: %v", err)
+	}
+
+	ctrls.KeyDown(0, A)
+	ctrls.KeyDown(2, A)
+	ctrls.KeyDown(3, B)
+
+	ctrls.Store(0x4016, 1)
+	ctrls.Store(0x4016, 0)
+
+	if value := ctrls.Fetch(0x4016); value != 0x43 {
+		t.Errorf("$4016 is $%02X not $43", value)
+	}
+
+	if value := ctrls.Fetch(0x4017); value != 0x40 {
+		t.Errorf("$4017 is $%02X not $40", value)
+	}
+
+	if value := ctrls.Fetch(0x4017); value != 0x42 {
+		t.Errorf("$4017 is $%02X not $42", value)
+	}
+}
+
+func TestOpposing(t *testing.T) {
+	for _, test := range []struct {
+		opposing Opposing
+		up, down bool // after pressing Up and then Down
+	}{
+		{FilterOpposing, true, false},
+		{AllowOpposing, true, true},
+		{LastOpposingWins, false, true},
+	} {
+		ctrls := NewControllers()
+		*ctrls.opposing = test.opposing
+
+		ctrl := ctrls.Device(Port1).(*Controller)
+
+		ctrls.KeyDown(0, Up)
+		ctrls.KeyDown(0, Down)
+
+		if up, down := ctrl.KeyIsDown(Up), ctrl.KeyIsDown(Down); up != test.up || down != test.down {
+			t.Errorf("%v: Up and Down are %v, %v not %v, %v", test.opposing, up, down, test.up, test.down)
+		}
+
+		// Up is still held down once Down is let up
+		if ctrls.KeyUp(0, Down); !ctrl.KeyIsDown(Up) {
+			t.Errorf("%v: Up is not down after Down is let up", test.opposing)
+		}
+	}
+
+	// controllers plugged in later share the setting
+	ctrls := NewControllers()
+	*ctrls.opposing = AllowOpposing
+
+	if err := ctrls.Plug(Port1, "fourscore"); err != nil {
+		t.Fatalf("Error plugging in Four Score: %v", err)
+	}
+
+	if o := ctrls.player(3).(*Controller).Opposing(); o != AllowOpposing {
+		t.Errorf("Player 4 opposing directions are %v not allow", o)
+	}
+
+	if _, err := ParseOpposing("both"); err == nil {
+		t.Error("No error parsing opposing directions 'both'")
+	}
+}
+
+func TestFamicomController(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Plug(Port2, "famicom"); err != nil {
+		t.Fatalf("Error plugging in Famicom controller: %v", err)
+	}
+
+	fc := ctrls.Device(Port2).(*FamicomController)
+
+	// no Select or Start
+	ctrls.KeyDown(1, Start)
+	ctrls.KeyDown(1, A)
+
+	if fc.KeyIsDown(Start) || !fc.KeyIsDown(A) {
+		t.Error("Start is down or A is not")
+	}
+
+	ctrls.SetButtons(1, 1<<Select|1<<B)
+
+	if ctrls.Buttons(1) != 1<<B {
+		t.Errorf("Buttons set are %08b not %08b", ctrls.Buttons(1), 1<<B)
+	}
+
+	ctrls.Microphone(true)
+
+	if ctrls.Fetch(0x4016)&0x04 == 0 || ctrls.Fetch(0x4017)&0x04 != 0 {
+		t.Error("Microphone is not heard through $4016 only")
+	}
+
+	ctrls.Microphone(false)
+
+	fc.setLevels([]float64{0.5, 0.01, 0.2})
+
+	// the WAV file plays on through resets
+	for i, expected := range []uint8{0x04, 0x00, 0x04, 0x00} {
+		if value := ctrls.Fetch(0x4016) & 0x04; value != expected {
+			t.Errorf("Microphone on frame %v is %02x not %02x", i, value, expected)
+		}
+
+		ctrls.Frame()
+		ctrls.Reset()
+	}
+
+	if err := ctrls.Plug(Port1, "famicom"); err == nil {
+		t.Error("No error plugging Famicom controller II into port 1")
+	}
+}
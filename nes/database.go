@@ -0,0 +1,149 @@
+package nes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/nwidger/nintengo/rp2cgo2"
+	"gopkg.in/yaml.v2"
+)
+
+// An entry in the ROM database describing the correct header values
+// for a game, identified by the CRC32 of its PRG and CHR data.
+type GameInfo struct {
+	Name      string `yaml:"name" json:"name"`
+	CRC32     string `yaml:"crc32" json:"crc32"`
+	Mapper    *uint8 `yaml:"mapper" json:"mapper,omitempty"`
+	Submapper *uint8 `yaml:"submapper" json:"submapper,omitempty"`
+	Mirroring string `yaml:"mirroring" json:"mirroring"`
+	Battery   *bool  `yaml:"battery" json:"battery,omitempty"`
+	Region    string `yaml:"region" json:"region"`
+	VSPPU     string `yaml:"vsppu" json:"vsPpu,omitempty"`
+	VSDIP     string `yaml:"vsdip" json:"vsDip,omitempty"`
+	Port1     string `yaml:"port1" json:"port1,omitempty"`
+	Port2     string `yaml:"port2" json:"port2,omitempty"`
+	Expansion string `yaml:"expansion" json:"expansion,omitempty"`
+}
+
+var gameDatabase = map[uint32]*GameInfo{}
+
+// Loads a YAML ROM database, adding its entries to any previously
+// loaded ones.  The database is a list of entries like:
+//
+//   - name: Some Game (U)
+//     crc32: 1234ABCD
+//     mapper: 1
+//     mirroring: Vertical
+//     battery: true
+//     region: NTSC
+//
+// Vs. System games also give the PPU the game runs on and, optionally,
+// the default DIP switch settings:
+//
+//   - name: Vs. Some Game
+//     crc32: 5678EF01
+//     mapper: 2
+//     vsppu: RP2C04-0001
+//     vsdip: "00000000"
+//
+// Games needing something other than a joypad in either controller
+// port or a device in the Famicom expansion port name the devices to
+// plug in with port1, port2 and expansion.  Only the values an entry
+// gives are used, the others are taken from the ROM's header, so an
+// entry may, for instance, only name the devices to plug in.
+func LoadDatabase(filename string) (err error) {
+	var buf []byte
+	var games []*GameInfo
+
+	if buf, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+
+	if err = yaml.Unmarshal(buf, &games); err != nil {
+		return
+	}
+
+	for _, game := range games {
+		if err = AddGame(game); err != nil {
+			err = fmt.Errorf("%v: %v", filename, err)
+			return
+		}
+	}
+
+	return
+}
+
+func AddGame(game *GameInfo) (err error) {
+	crc, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(game.CRC32), "0x"), 16, 32)
+
+	if err != nil {
+		err = fmt.Errorf("Invalid CRC32 '%v' for %v", game.CRC32, game.Name)
+		return
+	}
+
+	gameDatabase[uint32(crc)] = game
+
+	return
+}
+
+// Returns the database entry for the ROM with the given PRG and CHR
+// checksum, or nil if there is none.
+func LookupGame(crc uint32) *GameInfo {
+	return gameDatabase[crc]
+}
+
+func MirroringFromString(s string) (mirroring rp2cgo2.Mirroring, err error) {
+	switch strings.ToLower(s) {
+	case "horizontal", "h":
+		mirroring = rp2cgo2.Horizontal
+	case "vertical", "v":
+		mirroring = rp2cgo2.Vertical
+	case "fourscreen", "four-screen", "4":
+		mirroring = rp2cgo2.FourScreen
+	default:
+		err = fmt.Errorf("Invalid mirroring %v, must be Horizontal, Vertical or FourScreen", s)
+	}
+
+	return
+}
+
+// Overwrites romf's header values with those game gives, leaving the
+// others as they are.
+func (romf *ROMFile) ApplyGameInfo(game *GameInfo) (err error) {
+	if game.Mirroring != "" {
+		if romf.Mirroring, err = MirroringFromString(game.Mirroring); err != nil {
+			return
+		}
+
+		romf.FourScreen = romf.Mirroring == rp2cgo2.FourScreen
+	}
+
+	if game.Region != "" {
+		switch romf.RegionFlag = RegionFromString(game.Region); romf.RegionFlag {
+		case NTSC, PAL:
+		default:
+			err = fmt.Errorf("Invalid region %v, must be NTSC or PAL", game.Region)
+			return
+		}
+	}
+
+	if game.Mapper != nil {
+		romf.Mapper = *game.Mapper
+	}
+
+	if game.Submapper != nil {
+		romf.Submapper = *game.Submapper
+	}
+
+	if game.Battery != nil {
+		romf.Battery = *game.Battery
+	}
+
+	if game.VSPPU != "" {
+		romf.VSCart = true
+	}
+
+	return
+}
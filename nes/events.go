@@ -22,7 +22,14 @@ func init() {
 	gob.Register(&FrameEvent{})
 	gob.Register(&SampleEvent{})
 	gob.Register(&ControllerEvent{})
+	gob.Register(&GamepadEvent{})
+	gob.Register(&MouseEvent{})
+	gob.Register(&PowerPadEvent{})
+	gob.Register(&MicrophoneEvent{})
+	gob.Register(&VSCoinEvent{})
+	gob.Register(&VSServiceEvent{})
 	gob.Register(&PauseEvent{})
+	gob.Register(&StepEvent{})
 	gob.Register(&ResetEvent{})
 	gob.Register(&RecordEvent{})
 	gob.Register(&StopEvent{})
@@ -35,7 +42,12 @@ func init() {
 	gob.Register(&PPUDecodeEvent{})
 	gob.Register(&SaveStateEvent{})
 	gob.Register(&LoadStateEvent{})
+	gob.Register(&SlotEvent{})
+	gob.Register(&MovieReadOnlyEvent{})
+	gob.Register(&LagCounterEvent{})
 	gob.Register(&FPSEvent{})
+	gob.Register(&SpeedEvent{})
+	gob.Register(&FastForwardEvent{})
 	gob.Register(&SavePatternTablesEvent{})
 	gob.Register(&MuteEvent{})
 	gob.Register(&MuteNoiseEvent{})
@@ -45,10 +57,13 @@ func init() {
 	gob.Register(&HeartbeatEvent{})
 }
 
+// EvInput marks events which change the state of the emulated
+// hardware, which deterministic mode holds until the end of the frame.
 const (
 	EvGlobal uint = 1 << iota
 	EvMaster
 	EvSlave
+	EvInput
 )
 
 type FrameEvent struct {
@@ -92,7 +107,14 @@ func (e *SampleEvent) Process(nes *NES) {
 		nes.audioRecorder.Input() <- e.Sample
 	}
 
-	nes.audio.Input() <- e.Sample
+	// muted at any other speed
+	switch {
+	case nes.speed != 1:
+	case nes.audioSync != nil:
+		nes.audioSync.sample(e.Sample)
+	default:
+		nes.audio.Input() <- e.Sample
+	}
 }
 
 func (e *SampleEvent) Flag() uint {
@@ -114,6 +136,10 @@ func (e *ControllerEvent) Process(nes *NES) {
 		return
 	}
 
+	if nes.movie != nil && nes.movie.input(e) {
+		return
+	}
+
 	if e.Down {
 		nes.controllers.KeyDown(e.Controller, e.Button)
 	} else {
@@ -122,7 +148,159 @@ func (e *ControllerEvent) Process(nes *NES) {
 }
 
 func (e *ControllerEvent) Flag() uint {
-	return EvGlobal | EvMaster | EvSlave
+	return EvGlobal | EvMaster | EvSlave | EvInput
+}
+
+type GamepadEventKind uint8
+
+const (
+	GamepadConnected GamepadEventKind = iota
+	GamepadDisconnected
+	GamepadButton
+	GamepadAxis
+	GamepadHat
+)
+
+// Raw input from a gamepad, numbered by the frontend.  Value is 0 or
+// 1 for buttons, from -1 to 1 for axes and the Hat direction bits for
+// hats.  Gamepad input is mapped to ControllerEvents where it happens
+// so that netplay and the slave's controller numbering work as they
+// do for the keyboard.
+type GamepadEvent struct {
+	Device int
+	Name   string
+	Kind   GamepadEventKind
+	Index  int
+	Value  float64
+}
+
+func (e *GamepadEvent) String() string {
+	return "GamepadEvent"
+}
+
+func (e *GamepadEvent) Process(nes *NES) {
+	if nes.gamepads == nil {
+		return
+	}
+
+	// in order, so a release never beats its press
+	for _, event := range nes.gamepads.Handle(e) {
+		nes.route(event)
+	}
+}
+
+func (e *GamepadEvent) Flag() uint {
+	return EvMaster | EvSlave
+}
+
+// The mouse position in NES pixels, -1, -1 if it is outside the
+// picture, and the state of its left and right buttons.
+type MouseEvent struct {
+	X, Y  int
+	Left  bool
+	Right bool
+}
+
+func (e *MouseEvent) String() string {
+	return "MouseEvent"
+}
+
+func (e *MouseEvent) Process(nes *NES) {
+	if nes.state != Running {
+		return
+	}
+
+	nes.controllers.Mouse(e.X, e.Y, e.Left, e.Right)
+}
+
+func (e *MouseEvent) Flag() uint {
+	return EvGlobal | EvMaster | EvSlave | EvInput
+}
+
+// A step on or off the given position (1-12) of the Power Pad,
+// numbered left to right and top to bottom.
+type PowerPadEvent struct {
+	Position int
+	Down     bool
+}
+
+func (e *PowerPadEvent) String() string {
+	return "PowerPadEvent"
+}
+
+func (e *PowerPadEvent) Process(nes *NES) {
+	if nes.state != Running {
+		return
+	}
+
+	nes.controllers.PowerPad(e.Position, e.Down)
+}
+
+func (e *PowerPadEvent) Flag() uint {
+	return EvGlobal | EvMaster | EvSlave | EvInput
+}
+
+// Blowing into, or no longer blowing into, the microphone of Famicom
+// controller II.
+type MicrophoneEvent struct {
+	Blowing bool
+}
+
+func (e *MicrophoneEvent) String() string {
+	return "MicrophoneEvent"
+}
+
+func (e *MicrophoneEvent) Process(nes *NES) {
+	if nes.state != Running {
+		return
+	}
+
+	nes.controllers.Microphone(e.Blowing)
+}
+
+func (e *MicrophoneEvent) Flag() uint {
+	return EvGlobal | EvMaster | EvSlave | EvInput
+}
+
+type VSCoinEvent struct {
+	Slot int
+	Down bool
+}
+
+func (e *VSCoinEvent) String() string {
+	return "VSCoinEvent"
+}
+
+func (e *VSCoinEvent) Process(nes *NES) {
+	if nes.state != Running || nes.vs == nil {
+		return
+	}
+
+	nes.vs.Coins[e.Slot] = e.Down
+}
+
+func (e *VSCoinEvent) Flag() uint {
+	return EvGlobal | EvMaster | EvSlave | EvInput
+}
+
+type VSServiceEvent struct {
+	Down bool
+}
+
+func (e *VSServiceEvent) String() string {
+	return "VSServiceEvent"
+}
+
+func (e *VSServiceEvent) Process(nes *NES) {
+	if nes.state != Running || nes.vs == nil {
+		return
+	}
+
+	nes.vs.Service = e.Down
+}
+
+func (e *VSServiceEvent) Flag() uint {
+	return EvGlobal | EvMaster | EvSlave | EvInput
 }
 
 type PauseEvent struct {
@@ -137,12 +315,51 @@ func (e *PauseEvent) String() string {
 func (e *PauseEvent) Process(nes *NES) {
 	nes.audio.TogglePaused()
 	nes.Paused = !nes.Paused
+	nes.stepping = NoStep
 }
 
 func (e *PauseEvent) Flag() uint {
 	return EvGlobal | EvMaster | EvSlave
 }
 
+// Runs the paused NES for a frame, a scanline or an instruction and
+// pauses it again.  Input given while paused at the end of a frame is
+// taken by the next frame.  Sent by a running NES it pauses at the end
+// of the current frame, scanline or instruction.
+type StepEvent struct {
+	Mode StepMode
+}
+
+func (e *StepEvent) String() string {
+	return "StepEvent"
+}
+
+func (e *StepEvent) Process(nes *NES) {
+	if nes.state != Running || !nes.master || e.Mode == NoStep || nes.stepping != NoStep {
+		return
+	}
+
+	nes.stepScanline = nes.PPU.Scanline
+	nes.stepping = e.Mode
+
+	if nes.Paused {
+		if e.Mode == FrameStep && nes.frameEnded {
+			nes.processQueued()
+
+			if nes.movie != nil {
+				nes.movie.latch(nes)
+			}
+		}
+
+		nes.audio.TogglePaused()
+		nes.Paused = false
+	}
+}
+
+func (e *StepEvent) Flag() uint {
+	return EvGlobal | EvMaster
+}
+
 type ResetEvent struct{}
 
 func (e *ResetEvent) String() string {
@@ -154,11 +371,15 @@ func (e *ResetEvent) Process(nes *NES) {
 		return
 	}
 
+	if nes.movie != nil && nes.movie.reset() {
+		return
+	}
+
 	nes.Reset()
 }
 
 func (e *ResetEvent) Flag() uint {
-	return EvGlobal | EvMaster
+	return EvGlobal | EvMaster | EvInput
 }
 
 type RecordEvent struct{}
@@ -325,8 +546,7 @@ func (e *LoadStateEvent) Process(nes *NES) {
 			// Should not go here, NES.processEvents already filter the events.
 			return
 		}
-		name := nes.GameName + ".nst"
-		data, err := ioutil.ReadFile(name)
+		data, err := ioutil.ReadFile(nes.stateFile())
 		if err != nil {
 			return
 		}
@@ -340,6 +560,77 @@ func (e *LoadStateEvent) Flag() uint {
 	return EvGlobal | EvMaster
 }
 
+// Selects the save state slot (0-9) used by SaveStateEvent and
+// LoadStateEvent, or moves Delta slots from the current one if Delta is
+// not 0.
+type SlotEvent struct {
+	Slot  int
+	Delta int
+}
+
+func (e *SlotEvent) String() string {
+	return "SlotEvent"
+}
+
+func (e *SlotEvent) Process(nes *NES) {
+	if e.Delta != 0 {
+		nes.slot = (nes.slot + e.Delta + StateSlots) % StateSlots
+	} else if e.Slot >= 0 && e.Slot < StateSlots {
+		nes.slot = e.Slot
+	}
+
+	fmt.Println("*** Selecting save state slot", nes.slot)
+}
+
+func (e *SlotEvent) Flag() uint {
+	return EvMaster | EvSlave
+}
+
+// Switches the movie being played or recorded between read-only and
+// read-write mode.
+type MovieReadOnlyEvent struct{}
+
+func (e *MovieReadOnlyEvent) String() string {
+	return "MovieReadOnlyEvent"
+}
+
+func (e *MovieReadOnlyEvent) Process(nes *NES) {
+	if nes.movie == nil {
+		return
+	}
+
+	if nes.tas != nil {
+		fmt.Println("*** The TAS editor's movie is always read-only")
+		return
+	}
+
+	if nes.movie.ToggleReadOnly() {
+		fmt.Println("*** Movie is read-only")
+	} else {
+		fmt.Println("*** Movie is read-write")
+	}
+}
+
+func (e *MovieReadOnlyEvent) Flag() uint {
+	return EvMaster | EvSlave
+}
+
+// Shows or hides the number of lag frames over the picture.
+type LagCounterEvent struct{}
+
+func (e *LagCounterEvent) String() string {
+	return "LagCounterEvent"
+}
+
+func (e *LagCounterEvent) Process(nes *NES) {
+	nes.lagCounter = !nes.lagCounter
+	fmt.Println("*** Toggling lag counter =", nes.lagCounter)
+}
+
+func (e *LagCounterEvent) Flag() uint {
+	return EvMaster | EvSlave
+}
+
 type FPSEvent struct {
 	Rate float64
 }
@@ -349,16 +640,61 @@ func (e *FPSEvent) String() string {
 }
 
 func (e *FPSEvent) Process(nes *NES) {
-	nes.fps.SetRate(nes.DefaultFPS * e.Rate)
-
-	nes.audio.SetSpeed(float32(e.Rate))
-	fmt.Printf("*** Setting fps to %0.2f\n", e.Rate)
+	nes.SetSpeed(e.Rate)
+	fmt.Println("*** Setting speed to", FormatSpeed(nes.speed))
 }
 
 func (e *FPSEvent) Flag() uint {
 	return EvGlobal | EvMaster
 }
 
+// Steps the speed up or down the list of Speeds by Delta.
+type SpeedEvent struct {
+	Delta int
+}
+
+func (e *SpeedEvent) String() string {
+	return "SpeedEvent"
+}
+
+func (e *SpeedEvent) Process(nes *NES) {
+	nes.SetSpeed(nextSpeed(nes.speed, e.Delta))
+	fmt.Println("*** Setting speed to", FormatSpeed(nes.speed))
+}
+
+func (e *SpeedEvent) Flag() uint {
+	return EvGlobal | EvMaster
+}
+
+// Runs at 200% speed while Down, going back to the speed before when
+// let up.
+type FastForwardEvent struct {
+	Down bool
+}
+
+func (e *FastForwardEvent) String() string {
+	return "FastForwardEvent"
+}
+
+func (e *FastForwardEvent) Process(nes *NES) {
+	switch {
+	case e.Down && nes.fastForward == 0:
+		nes.fastForward = nes.speed
+		nes.SetSpeed(2)
+	case !e.Down && nes.fastForward != 0:
+		nes.SetSpeed(nes.fastForward)
+		nes.fastForward = 0
+	default:
+		return
+	}
+
+	fmt.Println("*** Setting speed to", FormatSpeed(nes.speed))
+}
+
+func (e *FastForwardEvent) Flag() uint {
+	return EvGlobal | EvMaster
+}
+
 type SavePatternTablesEvent struct{}
 
 func (e *SavePatternTablesEvent) String() string {
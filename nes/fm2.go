@@ -0,0 +1,390 @@
+package nes
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The input devices a movie records for each port, as numbered in
+// FCEUX's .fm2 files.
+const (
+	FM2None    = 0
+	FM2Gamepad = 1
+	FM2Zapper  = 2
+)
+
+// The commands a movie frame can start with.
+const (
+	MovieSoftReset uint8 = 1 << iota
+	MovieHardReset
+)
+
+// The buttons of a gamepad in the order written to .fm2 files, from
+// bit 7 down to bit 0 of its state.
+const fm2Buttons = "RLDUTSBA"
+
+// A frame of input: the commands run at the start of the frame and the
+// buttons held down on each controller during it, as bits 1<<A through
+// 1<<Right.
+type MovieFrame struct {
+	Commands uint8
+	Buttons  [4]uint8
+}
+
+// An input movie in the format of FCEUX's .fm2 files.  ROMChecksum is
+// the MD5 sum of the ROM's PRG and CHR data.  Savestate is the save
+// state the movie starts from, nil if it starts at power on.  Opposing
+// is written as an 'opposing' line, and Lag, which is true for each
+// frame during which the game did not read the controllers as far as
+// it is known, as a 'lagFrames' line listing the lag frames, both of
+// which FCEUX ignores.  ROMSHA1 is the SHA-1 sum of the ROM's PRG and
+// CHR data given by movies imported from BizHawk, which is not
+// written.
+type Movie struct {
+	Version       int
+	EmuVersion    int
+	RerecordCount int
+	PAL           bool
+	ROMFilename   string
+	ROMChecksum   []byte
+	ROMSHA1       []byte
+	GUID          string
+	FourScore     bool
+	Ports         [3]int
+	Opposing      Opposing
+	Comments      []string
+	Subtitles     []string
+	Savestate     []byte
+	Frames        []MovieFrame
+	Lag           []bool
+}
+
+// Returns a random GUID identifying a new movie.
+func newGUID() (guid string, err error) {
+	b := make([]byte, 16)
+
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+
+	h := strings.ToUpper(hex.EncodeToString(b))
+	guid = fmt.Sprintf("%v-%v-%v-%v-%v", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+
+	return
+}
+
+// Returns a new movie for rom, starting at power on.
+func NewMovie(rom *ROMFile) (m *Movie, err error) {
+	guid, err := newGUID()
+
+	if err != nil {
+		return
+	}
+
+	m = &Movie{
+		Version:     3,
+		ROMFilename: rom.Gamename,
+		ROMChecksum: ROMChecksum(rom),
+		GUID:        guid,
+		PAL:         rom.RegionFlag == PAL,
+		Ports:       [3]int{FM2Gamepad, FM2Gamepad, FM2None},
+	}
+
+	return
+}
+
+// Returns the MD5 sum of the PRG and CHR data of rom, as written to
+// movies.
+func ROMChecksum(rom *ROMFile) []byte {
+	return romHash(md5.New(), rom)
+}
+
+func romHash(h hash.Hash, rom *ROMFile) []byte {
+	for _, bank := range rom.ROMBanks {
+		h.Write(bank)
+	}
+
+	for _, bank := range rom.VROMBanks {
+		h.Write(bank)
+	}
+
+	return h.Sum(nil)
+}
+
+// Records whether frame was a lag frame.
+func (m *Movie) SetLag(frame int, lag bool) {
+	for len(m.Lag) <= frame {
+		m.Lag = append(m.Lag, false)
+	}
+
+	m.Lag[frame] = lag
+}
+
+// Returns true if frame is known to be a lag frame.
+func (m *Movie) IsLag(frame int) bool {
+	return frame >= 0 && frame < len(m.Lag) && m.Lag[frame]
+}
+
+// Returns the number of lag frames known.
+func (m *Movie) LagFrames() (count int) {
+	for _, lag := range m.Lag {
+		if lag {
+			count++
+		}
+	}
+
+	return
+}
+
+// Forgets whether frame and the frames after it were lag frames.
+func (m *Movie) truncateLag(frame int) {
+	if frame < 0 {
+		frame = 0
+	}
+
+	if frame < len(m.Lag) {
+		m.Lag = m.Lag[:frame]
+	}
+}
+
+func parseFM2Bool(value string) bool {
+	return value != "" && value != "0"
+}
+
+// Parses a line of the input log, which gives the commands and then
+// the state of each port, each between |s.
+func (m *Movie) parseFrame(line string) (frame MovieFrame, err error) {
+	fields := strings.Split(line, "|")
+
+	if len(fields) < 3 || fields[0] != "" {
+		err = fmt.Errorf("Invalid input log line '%v'", line)
+		return
+	}
+
+	commands, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+
+	if err != nil {
+		err = fmt.Errorf("Invalid commands in input log line '%v'", line)
+		return
+	}
+
+	frame.Commands = uint8(commands)
+
+	gamepads := 2
+
+	if m.FourScore {
+		gamepads = 4
+	}
+
+	for i := 0; i < gamepads && i+2 < len(fields); i++ {
+		if !m.FourScore && m.Ports[i] != FM2Gamepad {
+			continue
+		}
+
+		frame.Buttons[i] = ParseFM2Gamepad(fields[i+2])
+	}
+
+	return
+}
+
+// Reads a movie from an .fm2 file.
+func ReadFM2(reader io.Reader) (m *Movie, err error) {
+	m = &Movie{}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, "|") {
+			var frame MovieFrame
+
+			if frame, err = m.parseFrame(line); err != nil {
+				return
+			}
+
+			m.Frames = append(m.Frames, frame)
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		key, value := line, ""
+
+		if i := strings.Index(line, " "); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+
+		switch key {
+		case "version":
+			m.Version, err = strconv.Atoi(value)
+		case "emuVersion":
+			m.EmuVersion, err = strconv.Atoi(value)
+		case "rerecordCount":
+			m.RerecordCount, err = strconv.Atoi(value)
+		case "palFlag":
+			m.PAL = parseFM2Bool(value)
+		case "romFilename":
+			m.ROMFilename = value
+		case "romChecksum":
+			m.ROMChecksum, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "base64:"))
+		case "guid":
+			m.GUID = value
+		case "fourscore":
+			m.FourScore = parseFM2Bool(value)
+		case "port0", "port1", "port2":
+			m.Ports[key[4]-'0'], err = strconv.Atoi(value)
+		case "opposing":
+			m.Opposing, err = ParseOpposing(value)
+		case "comment":
+			m.Comments = append(m.Comments, value)
+		case "subtitle":
+			m.Subtitles = append(m.Subtitles, value)
+		case "lagFrames":
+			for _, field := range strings.Fields(value) {
+				var frame uint64
+
+				if frame, err = strconv.ParseUint(field, 10, 31); err != nil {
+					break
+				}
+
+				m.SetLag(int(frame), true)
+			}
+		case "savestate":
+			m.Savestate, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "base64:"))
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Invalid %v '%v': %v", key, value, err)
+			return
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	for port, device := range m.Ports {
+		if device != FM2None && (device != FM2Gamepad || port == 2) {
+			err = fmt.Errorf("Unsupported input device %v in port%v", device, port)
+			return
+		}
+	}
+
+	return
+}
+
+func formatFM2Bool(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// Writes the movie as an .fm2 file.
+func (m *Movie) WriteFM2(writer io.Writer) (err error) {
+	w := bufio.NewWriter(writer)
+
+	fmt.Fprintf(w, "version %v\n", m.Version)
+	fmt.Fprintf(w, "emuVersion %v\n", m.EmuVersion)
+	fmt.Fprintf(w, "rerecordCount %v\n", m.RerecordCount)
+	fmt.Fprintf(w, "palFlag %v\n", formatFM2Bool(m.PAL))
+	fmt.Fprintf(w, "romFilename %v\n", m.ROMFilename)
+	fmt.Fprintf(w, "romChecksum base64:%v\n", base64.StdEncoding.EncodeToString(m.ROMChecksum))
+	fmt.Fprintf(w, "guid %v\n", m.GUID)
+	fmt.Fprintf(w, "fourscore %v\n", formatFM2Bool(m.FourScore))
+	fmt.Fprintf(w, "microphone 0\n")
+
+	for port, device := range m.Ports {
+		fmt.Fprintf(w, "port%v %v\n", port, device)
+	}
+
+	fmt.Fprintf(w, "FDS 0\n")
+	fmt.Fprintf(w, "NewPPU 0\n")
+	fmt.Fprintf(w, "opposing %v\n", m.Opposing)
+
+	for _, comment := range m.Comments {
+		fmt.Fprintf(w, "comment %v\n", comment)
+	}
+
+	for _, subtitle := range m.Subtitles {
+		fmt.Fprintf(w, "subtitle %v\n", subtitle)
+	}
+
+	if m.LagFrames() > 0 {
+		w.WriteString("lagFrames")
+
+		for frame, lag := range m.Lag {
+			if lag {
+				fmt.Fprintf(w, " %v", frame)
+			}
+		}
+
+		w.WriteString("\n")
+	}
+
+	if m.Savestate != nil {
+		fmt.Fprintf(w, "savestate base64:%v\n", base64.StdEncoding.EncodeToString(m.Savestate))
+	}
+
+	gamepads := 2
+
+	if m.FourScore {
+		gamepads = 4
+	}
+
+	for _, frame := range m.Frames {
+		fmt.Fprintf(w, "|%v|", frame.Commands)
+
+		for i := 0; i < gamepads; i++ {
+			if m.FourScore || m.Ports[i] == FM2Gamepad {
+				w.WriteString(FormatFM2Gamepad(frame.Buttons[i]))
+			}
+
+			w.WriteString("|")
+		}
+
+		// the expansion port
+		w.WriteString("|\n")
+	}
+
+	return w.Flush()
+}
+
+// Returns buttons as written to .fm2 files, e.g. 'R..U...A' for Right,
+// Up and A.
+func FormatFM2Gamepad(buttons uint8) string {
+	b := []byte(fm2Buttons)
+
+	for i := range b {
+		if buttons&(0x80>>uint(i)) == 0 {
+			b[i] = '.'
+		}
+	}
+
+	return string(b)
+}
+
+// Returns the buttons of a gamepad written as in .fm2 files, in which
+// anything but '.' or ' ' in a button's place holds it down.
+func ParseFM2Gamepad(s string) (buttons uint8) {
+	for i, c := range s {
+		if i < len(fm2Buttons) && c != '.' && c != ' ' {
+			buttons |= 0x80 >> uint(i)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,201 @@
+package nes
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadFM2(t *testing.T) {
+	fm2 := `version 3
+emuVersion 20604
+rerecordCount 12
+palFlag 0
+romFilename Super Mario Bros.
+romChecksum base64:jjYwGG411HcjG/j9UOVM3Q==
+guid 452DE2C3-EF43-2FA9-77AC-0677FC51543B
+fourscore 0
+port0 1
+port1 1
+port2 0
+comment author someone
+|0|........|........||
+|1|R..U...A|........||
+|0|.L..T.B.|..D.....||
+`
+
+	m, err := ReadFM2(strings.NewReader(fm2))
+
+	if err != nil {
+		t.Fatalf("Error reading movie: %v", err)
+	}
+
+	if m.RerecordCount != 12 || m.ROMFilename != "Super Mario Bros." || len(m.ROMChecksum) != 16 {
+		t.Errorf("Header read as %+v", m)
+	}
+
+	if len(m.Comments) != 1 || m.Comments[0] != "author someone" {
+		t.Errorf("Comments read as %v", m.Comments)
+	}
+
+	expected := []MovieFrame{
+		{},
+		{Commands: MovieSoftReset, Buttons: [4]uint8{1<<Right | 1<<Up | 1<<A}},
+		{Buttons: [4]uint8{1<<Left | 1<<Start | 1<<B, 1 << Down}},
+	}
+
+	if len(m.Frames) != len(expected) {
+		t.Fatalf("Read %v frames not %v", len(m.Frames), len(expected))
+	}
+
+	for i, frame := range expected {
+		if m.Frames[i] != frame {
+			t.Errorf("Frame %v read as %+v not %+v", i, m.Frames[i], frame)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err = m.WriteFM2(&buf); err != nil {
+		t.Fatalf("Error writing movie: %v", err)
+	}
+
+	for _, line := range strings.Split(fm2, "\n") {
+		if strings.HasPrefix(line, "|") && !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Written movie is missing '%v'", line)
+		}
+	}
+
+	m2, err := ReadFM2(&buf)
+
+	if err != nil {
+		t.Fatalf("Error reading written movie: %v", err)
+	}
+
+	if m2.GUID != m.GUID || !bytes.Equal(m2.ROMChecksum, m.ROMChecksum) || len(m2.Frames) != len(m.Frames) {
+		t.Errorf("Written movie read back as %+v", m2)
+	}
+
+	if _, err = ReadFM2(strings.NewReader("port1 2\n")); err == nil {
+		t.Error("No error reading movie with a zapper")
+	}
+
+	if _, err = ReadFM2(strings.NewReader("|x|........|........||\n")); err == nil {
+		t.Error("No error reading invalid input log")
+	}
+}
+
+func TestMovieSession(t *testing.T) {
+	ctrls := NewControllers()
+	ctrls.turbo, _ = NewTurbo(0, 0)
+
+	nes := &NES{
+		controllers: ctrls,
+		Opposing:    ctrls.opposing,
+	}
+
+	session := &MovieSession{
+		Movie: &Movie{Ports: [3]int{FM2Gamepad, FM2Gamepad, FM2None}},
+		mode:  movieRecording,
+	}
+
+	nes.movie = session
+	session.start(nes)
+
+	// input arriving during a frame takes effect at its end
+	session.input(&ControllerEvent{Controller: 0, Button: A, Down: true})
+
+	if ctrls.Buttons(0) != 0 {
+		t.Error("A is down before the end of the frame")
+	}
+
+	session.endFrame(nes)
+	session.input(&ControllerEvent{Controller: 1, Button: Up, Down: true})
+	session.endFrame(nes)
+	session.input(&ControllerEvent{Controller: 0, Button: A, Down: false})
+	session.endFrame(nes)
+
+	expected := [][2]uint8{{0, 0}, {1 << A, 0}, {1 << A, 1 << Up}, {0, 1 << Up}}
+
+	if len(session.Movie.Frames) != len(expected) || nes.MovieFrame != 3 {
+		t.Fatalf("Recorded %v frames, at frame %v", len(session.Movie.Frames), nes.MovieFrame)
+	}
+
+	for i, buttons := range expected {
+		frame := session.Movie.Frames[i]
+
+		if frame.Buttons[0] != buttons[0] || frame.Buttons[1] != buttons[1] {
+			t.Errorf("Frame %v recorded as %+v", i, frame)
+		}
+	}
+
+	// playing back sets each frame's buttons and ignores input
+	ctrls.Reset()
+	session.mode = moviePlaying
+	session.ReadOnly = true
+	session.start(nes)
+
+	for i, buttons := range expected {
+		if i > 0 {
+			session.input(&ControllerEvent{Controller: 0, Button: B, Down: true})
+			session.endFrame(nes)
+		}
+
+		if ctrls.Buttons(0) != buttons[0] || ctrls.Buttons(1) != buttons[1] {
+			t.Errorf("Frame %v played back as %v, %v", i, ctrls.Buttons(0), ctrls.Buttons(1))
+		}
+	}
+
+	session.endFrame(nes)
+
+	if session.mode != movieFinished {
+		t.Error("Movie has not finished")
+	}
+
+	// loading a state in read-write mode records from its frame on
+	session.ReadOnly = false
+	nes.MovieFrame = 1
+	session.loadedState(nes)
+
+	if len(session.Movie.Frames) != 2 || session.Movie.RerecordCount != 1 || session.mode != movieRecording {
+		t.Errorf("Rerecording left %v frames and %v rerecords", len(session.Movie.Frames), session.Movie.RerecordCount)
+	}
+
+	if ctrls.Buttons(0) != 1<<A {
+		t.Error("A is not down at frame 1")
+	}
+}
+
+func TestMovieLatch(t *testing.T) {
+	ctrls := NewControllers()
+	ctrls.turbo, _ = NewTurbo(0, 0)
+
+	nes := &NES{
+		controllers: ctrls,
+		Opposing:    ctrls.opposing,
+	}
+
+	session := &MovieSession{
+		Movie: &Movie{Ports: [3]int{FM2Gamepad, FM2Gamepad, FM2None}},
+		mode:  movieRecording,
+	}
+
+	nes.movie = session
+	session.start(nes)
+	session.endFrame(nes)
+
+	// input given while paused at the end of frame 0 is latched for
+	// frame 1 when stepping
+	session.input(&ControllerEvent{Controller: 0, Button: Start, Down: true})
+	session.latch(nes)
+
+	if ctrls.Buttons(0) != 1<<Start || session.Movie.Frames[1].Buttons[0] != 1<<Start {
+		t.Errorf("Start is not down on frame 1: %+v", session.Movie.Frames[1])
+	}
+
+	session.endFrame(nes)
+
+	if len(session.Movie.Frames) != 3 || session.Movie.Frames[2].Buttons[0] != 1<<Start {
+		t.Errorf("Frame 2 recorded as %+v", session.Movie.Frames[len(session.Movie.Frames)-1])
+	}
+}
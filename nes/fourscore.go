@@ -0,0 +1,132 @@
+package nes
+
+// Implemented by input devices with controllers for more than one
+// player.
+type Multitap interface {
+	InputDevice
+	// Returns the controller for the given player (0-3), or nil if
+	// the player's controller is not connected through the device.
+	Controller(player int) *Controller
+}
+
+// A four player adapter.  The NES Four Score plugs into both
+// controller ports and reads players 1 and 3 through $4016 and players
+// 2 and 4 through $4017, followed by a signature identifying the
+// adapter.  The Famicom adapter plugs into the expansion port and reads
+// players 3 and 4 through bit 1 of $4016 and $4017, players 1 and 2
+// being the joypads built into the console.
+type FourScore struct {
+	famicom     bool
+	latch       uint8
+	reads       [2]uint8
+	controllers [4]*Controller
+}
+
+// Reads 16-23 of $4016 return 0,0,0,1,0,0,0,0 and of $4017 return
+// 0,0,1,0,0,0,0,0
+var fourScoreSignatures = [2]uint8{0x08, 0x04}
+
+func NewFourScore(famicom bool) *FourScore {
+	fs := &FourScore{
+		famicom: famicom,
+	}
+
+	for i := range fs.controllers {
+		fs.controllers[i] = NewController()
+	}
+
+	return fs
+}
+
+func (fs *FourScore) Controller(player int) *Controller {
+	if player < 0 || player > 3 || (fs.famicom && player < 2) {
+		return nil
+	}
+
+	return fs.controllers[player]
+}
+
+func (fs *FourScore) Reset() {
+	fs.latch = 0
+	fs.reads[0] = 0
+	fs.reads[1] = 0
+
+	for _, ctrl := range fs.controllers {
+		ctrl.Reset()
+	}
+}
+
+func (fs *FourScore) save() func() {
+	saved := *fs
+	restore := [4]func(){}
+
+	for i, ctrl := range fs.controllers {
+		restore[i] = ctrl.save()
+	}
+
+	return func() {
+		*fs = saved
+
+		for _, f := range restore {
+			f()
+		}
+	}
+}
+
+func (fs *FourScore) Strobe(value uint8) {
+	if fs.latch == 1 && value&0x01 == 0 {
+		fs.reads[0] = 0
+		fs.reads[1] = 0
+	}
+
+	fs.latch = value & 0x01
+
+	for _, ctrl := range fs.controllers {
+		ctrl.Strobe(value)
+	}
+}
+
+func (fs *FourScore) Read(address uint16) (value uint8) {
+	index := int(address - 0x4016)
+
+	if fs.famicom {
+		return fs.controllers[index+2].Read(address) << 1
+	}
+
+	n := fs.reads[index]
+
+	switch {
+	case n < 8:
+		value = fs.controllers[index].Read(address)
+	case n < 16:
+		value = fs.controllers[index+2].Read(address)
+	case n < 24:
+		value = (fourScoreSignatures[index] >> (n - 16)) & 0x01
+	default:
+		value = 1
+	}
+
+	if n < 24 {
+		fs.reads[index]++
+	}
+
+	return
+}
+
+// Key presses sent to the adapter itself go to the first controller
+// plugged into it.
+func (fs *FourScore) KeyDown(btn Button) {
+	if fs.famicom {
+		fs.controllers[2].KeyDown(btn)
+	} else {
+		fs.controllers[0].KeyDown(btn)
+	}
+}
+
+func (fs *FourScore) KeyUp(btn Button) {
+	if fs.famicom {
+		fs.controllers[2].KeyUp(btn)
+	} else {
+		fs.controllers[0].KeyUp(btn)
+	}
+}
@@ -9,11 +9,13 @@ const (
 	DefaultFPSPAL  float64 = 50.0070
 )
 
+// Limits the rate frames are run at by sleeping after each until it is
+// due, timing frames from when the rate was set to the nanosecond.
 type FPS struct {
 	enabled bool
-	frames  float64
-	rate    float64
-	ticks   uint64
+	frames  int64
+	rate    time.Duration // between frames
+	start   time.Time
 }
 
 func NewFPS(rate float64) *FPS {
@@ -34,27 +36,25 @@ func (fps *FPS) Disable() {
 
 func (fps *FPS) Resumed() {
 	fps.frames = 0
-	fps.ticks = uint64(time.Now().UnixNano()) / 1e6
+	fps.start = time.Now()
 }
 
 func (fps *FPS) SetRate(rate float64) {
 	fps.Enable()
 	fps.Resumed()
-	fps.rate = 1000.0 / rate
+	fps.rate = time.Duration(float64(time.Second) / rate)
 }
 
 func (fps *FPS) Delay() {
 	// next frame
 	fps.frames++
 
-	// get/calc ticks
-	current := uint64(time.Now().UnixNano()) / 1e6
-	target := fps.ticks + uint64(fps.frames*fps.rate)
+	current := time.Now()
+	target := fps.start.Add(time.Duration(fps.frames) * fps.rate)
 
-	if fps.enabled && current <= target {
-		time.Sleep(time.Duration((target - current) * 1e6))
+	if fps.enabled && !current.After(target) {
+		time.Sleep(target.Sub(current))
 	} else {
-		fps.frames = 0.0
-		fps.ticks = uint64(time.Now().UnixNano()) / 1e6
+		fps.Resumed()
 	}
 }
@@ -0,0 +1,349 @@
+package nes
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Analog stick positions further than this from the center press the
+// direction they point in.
+const DefaultGamepadDeadzone = 0.5
+
+// The directions of a hat switch as reported by SDL and GLFW.
+const (
+	HatUp uint8 = 1 << iota
+	HatRight
+	HatDown
+	HatLeft
+)
+
+// How the buttons and axes of a gamepad map to a controller, as given
+// in the gamepads section of ~/.nintengorc.  Name selects the gamepads
+// the mapping applies to by part of their name, an empty name matches
+// any gamepad.  Player is the controller (1-4) the gamepad drives, 0
+// for the first controller not taken by another gamepad.  Buttons maps
+// gamepad button numbers to A, B, Select, Start, Up, Down, Left, Right,
+// TurboA or TurboB, and Axes maps axis numbers to x or y.
+type GamepadMapping struct {
+	Name     string         `yaml:"name"`
+	Player   int            `yaml:"player"`
+	Deadzone float64        `yaml:"deadzone"`
+	Buttons  map[int]string `yaml:"buttons"`
+	Axes     map[int]string `yaml:"axes"`
+}
+
+// Used for gamepads no mapping matches, laid out for the usual face
+// buttons where button 0 is the bottom one.
+var DefaultGamepadMapping = GamepadMapping{
+	Buttons: map[int]string{0: "B", 1: "A", 6: "Select", 7: "Start"},
+	Axes:    map[int]string{0: "x", 1: "y"},
+}
+
+// Returns the button with the given name.
+func ParseButton(name string) (btn Button, err error) {
+	for _, btn = range Buttons {
+		if strings.EqualFold(btn.String(), name) {
+			return
+		}
+	}
+
+	err = fmt.Errorf("Invalid button %v", name)
+
+	return
+}
+
+type gamepadMapping struct {
+	name     string
+	player   int
+	deadzone float64
+	buttons  map[int]Button
+	axes     map[int]string
+}
+
+func parseGamepadMapping(m *GamepadMapping) (gm *gamepadMapping, err error) {
+	gm = &gamepadMapping{
+		name:     strings.ToLower(m.Name),
+		player:   m.Player,
+		deadzone: m.Deadzone,
+		buttons:  map[int]Button{},
+		axes:     map[int]string{},
+	}
+
+	if gm.player < 0 || gm.player > 4 {
+		err = fmt.Errorf("Invalid player %v for gamepad %v, must be 1-4", m.Player, m.Name)
+		return
+	}
+
+	if gm.deadzone < 0 || gm.deadzone >= 1 {
+		err = fmt.Errorf("Invalid deadzone %v for gamepad %v, must be at least 0 and less than 1", m.Deadzone, m.Name)
+		return
+	}
+
+	if gm.deadzone == 0 {
+		gm.deadzone = DefaultGamepadDeadzone
+	}
+
+	for index, name := range m.Buttons {
+		if gm.buttons[index], err = ParseButton(name); err != nil {
+			err = fmt.Errorf("%v for gamepad %v button %v", err, m.Name, index)
+			return
+		}
+	}
+
+	for index, axis := range m.Axes {
+		switch axis = strings.ToLower(axis); axis {
+		case "x", "y":
+			gm.axes[index] = axis
+		default:
+			err = fmt.Errorf("Invalid axis %v for gamepad %v axis %v, must be x or y", axis, m.Name, index)
+			return
+		}
+	}
+
+	return
+}
+
+// A connected gamepad, the raw state of its buttons, axes and hats
+// and the controller buttons they hold down.
+type gamepad struct {
+	name       string
+	controller int
+	mapping    *gamepadMapping
+	buttons    map[int]bool
+	axes       map[int]float64
+	hats       map[int]uint8
+	state      uint16
+}
+
+// Returns the controller buttons held down by the gamepad.
+func (g *gamepad) held() (state uint16) {
+	press := func(btn Button) {
+		state |= 1 << btn
+	}
+
+	for index, down := range g.buttons {
+		if btn, ok := g.mapping.buttons[index]; ok && down {
+			press(btn)
+		}
+	}
+
+	for index, value := range g.axes {
+		if math.Abs(value) <= g.mapping.deadzone {
+			continue
+		}
+
+		switch {
+		case g.mapping.axes[index] == "x" && value < 0:
+			press(Left)
+		case g.mapping.axes[index] == "x":
+			press(Right)
+		case g.mapping.axes[index] == "y" && value < 0:
+			press(Up)
+		case g.mapping.axes[index] == "y":
+			press(Down)
+		}
+	}
+
+	for _, hat := range g.hats {
+		for direction, btn := range map[uint8]Button{HatUp: Up, HatRight: Right, HatDown: Down, HatLeft: Left} {
+			if hat&direction != 0 {
+				press(btn)
+			}
+		}
+	}
+
+	return
+}
+
+// Maps the raw input of any number of gamepads, which may come and go
+// while running, to controller button presses.
+type Gamepads struct {
+	mappings []*gamepadMapping
+	devices  map[int]*gamepad
+}
+
+func NewGamepads(mappings []GamepadMapping) (gamepads *Gamepads, err error) {
+	gamepads = &Gamepads{
+		devices: map[int]*gamepad{},
+	}
+
+	for _, m := range append(append([]GamepadMapping{}, mappings...), DefaultGamepadMapping) {
+		var gm *gamepadMapping
+
+		if gm, err = parseGamepadMapping(&m); err != nil {
+			return
+		}
+
+		gamepads.mappings = append(gamepads.mappings, gm)
+	}
+
+	return
+}
+
+func (gamepads *Gamepads) connect(device int, name string) (g *gamepad) {
+	g = &gamepad{
+		name:    name,
+		buttons: map[int]bool{},
+		axes:    map[int]float64{},
+		hats:    map[int]uint8{},
+	}
+
+	for _, gm := range gamepads.mappings {
+		if strings.Contains(strings.ToLower(name), gm.name) {
+			g.mapping = gm
+			break
+		}
+	}
+
+	if g.mapping.player != 0 {
+		g.controller = g.mapping.player - 1
+	} else {
+		taken := map[int]bool{}
+
+		for _, other := range gamepads.devices {
+			taken[other.controller] = true
+		}
+
+		for taken[g.controller] && g.controller < 3 {
+			g.controller++
+		}
+	}
+
+	gamepads.devices[device] = g
+
+	fmt.Printf("*** Gamepad %v connected as player %v\n", name, g.controller+1)
+
+	return
+}
+
+// Returns the ControllerEvents for the controller buttons released and
+// pressed by the given GamepadEvent.
+func (gamepads *Gamepads) Handle(e *GamepadEvent) (events []Event) {
+	g, ok := gamepads.devices[e.Device]
+
+	switch {
+	case e.Kind == GamepadConnected:
+		if !ok {
+			gamepads.connect(e.Device, e.Name)
+		}
+
+		return
+	case e.Kind == GamepadDisconnected:
+		if !ok {
+			return
+		}
+
+		delete(gamepads.devices, e.Device)
+		g.buttons, g.axes, g.hats = nil, nil, nil
+
+		fmt.Printf("*** Gamepad %v disconnected\n", g.name)
+	case !ok:
+		g = gamepads.connect(e.Device, e.Name)
+	}
+
+	switch e.Kind {
+	case GamepadButton:
+		g.buttons[e.Index] = e.Value != 0
+	case GamepadAxis:
+		g.axes[e.Index] = e.Value
+	case GamepadHat:
+		g.hats[e.Index] = uint8(e.Value)
+	}
+
+	state := g.held()
+
+	// releases come first so that pressing the opposite direction
+	// is not ignored
+	for _, down := range []bool{false, true} {
+		for _, btn := range Buttons {
+			mask := uint16(1) << btn
+
+			if (state^g.state)&mask != 0 && (state&mask != 0) == down {
+				events = append(events, &ControllerEvent{
+					Controller: g.controller,
+					Button:     btn,
+					Down:       down,
+				})
+			}
+		}
+	}
+
+	g.state = state
+
+	return
+}
+
+// The state of a gamepad as polled by frontends which are not notified
+// of changes, nil if no gamepad is connected.
+type gamepadState struct {
+	name    string
+	axes    []float64
+	buttons []bool
+}
+
+// Axis movements smaller than this are not reported when polling.
+const gamepadPollThreshold = 0.05
+
+// Turns polled gamepad states into GamepadEvents.
+type gamepadPoller struct {
+	states map[int]*gamepadState
+}
+
+func newGamepadPoller() *gamepadPoller {
+	return &gamepadPoller{
+		states: map[int]*gamepadState{},
+	}
+}
+
+// Returns the GamepadEvents for the changes to device since it was
+// last polled.
+func (p *gamepadPoller) update(device int, state *gamepadState) (events []Event) {
+	last, ok := p.states[device]
+
+	switch {
+	case state == nil && !ok:
+		return
+	case state == nil:
+		delete(p.states, device)
+		return []Event{&GamepadEvent{Device: device, Kind: GamepadDisconnected}}
+	case !ok:
+		last = &gamepadState{name: state.name}
+		events = append(events, &GamepadEvent{Device: device, Name: state.name, Kind: GamepadConnected})
+	}
+
+	next := &gamepadState{
+		name:    state.name,
+		axes:    make([]float64, len(state.axes)),
+		buttons: state.buttons,
+	}
+
+	for i, value := range state.axes {
+		next.axes[i] = value
+
+		if i < len(last.axes) && math.Abs(value-last.axes[i]) < gamepadPollThreshold {
+			next.axes[i] = last.axes[i]
+			continue
+		}
+
+		events = append(events, &GamepadEvent{Device: device, Name: state.name, Kind: GamepadAxis, Index: i, Value: value})
+	}
+
+	for i, down := range state.buttons {
+		if i < len(last.buttons) && down == last.buttons[i] {
+			continue
+		}
+
+		value := 0.0
+
+		if down {
+			value = 1.0
+		}
+
+		events = append(events, &GamepadEvent{Device: device, Name: state.name, Kind: GamepadButton, Index: i, Value: value})
+	}
+
+	p.states[device] = next
+
+	return
+}
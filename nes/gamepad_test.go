@@ -0,0 +1,136 @@
+package nes
+
+import "testing"
+
+func checkControllerEvents(t *testing.T, events []Event, expected []ControllerEvent) {
+	if len(events) != len(expected) {
+		t.Errorf("%v events not %v", len(events), len(expected))
+		return
+	}
+
+	for i, ev := range events {
+		if ce, ok := ev.(*ControllerEvent); !ok || *ce != expected[i] {
+			t.Errorf("Event %v is %v not %v", i, ev, expected[i])
+		}
+	}
+}
+
+func TestGamepads(t *testing.T) {
+	gamepads, err := NewGamepads([]GamepadMapping{
+		{
+			Name:     "Pad",
+			Player:   2,
+			Deadzone: 0.25,
+			Buttons:  map[int]string{3: "start"},
+			Axes:     map[int]string{2: "x"},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("Error creating gamepads: %v", err)
+	}
+
+	gamepads.Handle(&GamepadEvent{Device: 0, Name: "Generic Joystick", Kind: GamepadConnected})
+	gamepads.Handle(&GamepadEvent{Device: 1, Name: "Super Pad", Kind: GamepadConnected})
+
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 0, Kind: GamepadButton, Index: 1, Value: 1}),
+		[]ControllerEvent{{Controller: 0, Button: A, Down: true}})
+
+	// within the deadzone
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 0, Kind: GamepadAxis, Index: 0, Value: 0.4}), nil)
+
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 0, Kind: GamepadAxis, Index: 0, Value: -0.9}),
+		[]ControllerEvent{{Controller: 0, Button: Left, Down: true}})
+
+	// the release of left comes before the press of right
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 0, Kind: GamepadAxis, Index: 0, Value: 0.9}),
+		[]ControllerEvent{
+			{Controller: 0, Button: Left, Down: false},
+			{Controller: 0, Button: Right, Down: true},
+		})
+
+	// a hat and a stick pressing the same direction
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 0, Kind: GamepadHat, Value: float64(HatRight | HatUp)}),
+		[]ControllerEvent{{Controller: 0, Button: Up, Down: true}})
+
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 0, Kind: GamepadHat, Value: 0}),
+		[]ControllerEvent{{Controller: 0, Button: Up, Down: false}})
+
+	// the mapped gamepad is player 2
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 1, Kind: GamepadButton, Index: 3, Value: 1}),
+		[]ControllerEvent{{Controller: 1, Button: Start, Down: true}})
+
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 1, Kind: GamepadAxis, Index: 2, Value: -0.3}),
+		[]ControllerEvent{{Controller: 1, Button: Left, Down: true}})
+
+	// unplugging releases everything held
+	checkControllerEvents(t, gamepads.Handle(&GamepadEvent{Device: 0, Kind: GamepadDisconnected}),
+		[]ControllerEvent{
+			{Controller: 0, Button: A, Down: false},
+			{Controller: 0, Button: Right, Down: false},
+		})
+
+	// the next gamepad plugged in takes the free controller
+	gamepads.Handle(&GamepadEvent{Device: 2, Name: "Generic Joystick", Kind: GamepadConnected})
+
+	if g := gamepads.devices[2]; g.controller != 0 {
+		t.Errorf("Gamepad plugged in is player %v not 1", g.controller+1)
+	}
+}
+
+func TestGamepadEventOrder(t *testing.T) {
+	nes := newTestNES(t, &Options{Region: "NTSC", Deterministic: true})
+
+	(&GamepadEvent{Device: 0, Name: "Generic Joystick", Kind: GamepadConnected}).Process(nes)
+
+	// a quick press and release reach the controllers in order
+	for i := 0; i < 100; i++ {
+		(&GamepadEvent{Device: 0, Kind: GamepadButton, Index: 1, Value: 1}).Process(nes)
+		(&GamepadEvent{Device: 0, Kind: GamepadButton, Index: 1, Value: 0}).Process(nes)
+	}
+
+	if len(nes.queued) != 200 {
+		t.Fatalf("Queued %v events not 200", len(nes.queued))
+	}
+
+	for i, e := range nes.queued {
+		if ce, ok := e.(*ControllerEvent); !ok || ce.Down != (i%2 == 0) {
+			t.Fatalf("Event %v is %v", i, e)
+		}
+	}
+}
+
+func TestGamepadMappingErrors(t *testing.T) {
+	for _, m := range []GamepadMapping{
+		{Player: 5},
+		{Deadzone: 1.5},
+		{Buttons: map[int]string{0: "Turbo"}},
+		{Axes: map[int]string{0: "z"}},
+	} {
+		if _, err := NewGamepads([]GamepadMapping{m}); err == nil {
+			t.Errorf("No error creating gamepads with mapping %+v", m)
+		}
+	}
+}
+
+func TestGamepadPoller(t *testing.T) {
+	poller := newGamepadPoller()
+
+	state := &gamepadState{name: "Pad", axes: []float64{0, 0}, buttons: []bool{false}}
+
+	if events := poller.update(0, state); len(events) != 4 {
+		t.Errorf("%v events when connecting not 4", len(events))
+	}
+
+	state = &gamepadState{name: "Pad", axes: []float64{0.01, 0.5}, buttons: []bool{true}}
+
+	if events := poller.update(0, state); len(events) != 2 {
+		t.Errorf("%v events when changing not 2", len(events))
+	}
+
+	events := poller.update(0, nil)
+
+	if len(events) != 1 || events[0].(*GamepadEvent).Kind != GamepadDisconnected {
+		t.Errorf("Events when disconnecting are %v", events)
+	}
+}
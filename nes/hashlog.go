@@ -0,0 +1,151 @@
+package nes
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Reads a frame hash log, which gives a frame of a movie and the hash
+// of the CPU's RAM at the end of that frame, in hex, on each line.
+// Blank lines and lines starting with '#' are skipped.
+func ReadHashLog(reader io.Reader) (hashes map[int]uint64, err error) {
+	hashes = map[int]uint64{}
+
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		var frame int
+		var hash uint64
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) != 2 {
+			err = fmt.Errorf("Invalid hash log line '%v'", line)
+			return
+		}
+
+		if frame, err = strconv.Atoi(fields[0]); err == nil {
+			hash, err = strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Invalid hash log line '%v'", line)
+			return
+		}
+
+		hashes[frame] = hash
+	}
+
+	err = scanner.Err()
+
+	return
+}
+
+// Checks a movie as it plays against a frame hash log given by the
+// -hash-log option, and writes the hashes of the frames played to the
+// log given by -write-hash-log, so that a movie which plays correctly
+// can give the log later runs are checked against.
+type DesyncReport struct {
+	expected map[int]uint64
+	file     *os.File
+	writer   *bufio.Writer
+	Compared int
+	Desynced int
+	First    int // the first frame which desynced, -1 if none has
+}
+
+// Returns a report checking against and writing the logs given in
+// options, nil if neither is given.
+func NewDesyncReport(options *Options) (report *DesyncReport, err error) {
+	if options.HashLog == "" && options.WriteHashLog == "" {
+		return
+	}
+
+	report = &DesyncReport{First: -1}
+
+	if options.HashLog != "" {
+		var f *os.File
+
+		if f, err = os.Open(options.HashLog); err != nil {
+			return
+		}
+
+		defer f.Close()
+
+		if report.expected, err = ReadHashLog(f); err != nil {
+			return
+		}
+	}
+
+	if options.WriteHashLog != "" {
+		if report.file, err = os.Create(options.WriteHashLog); err != nil {
+			return
+		}
+
+		report.writer = bufio.NewWriter(report.file)
+	}
+
+	return
+}
+
+// Called with the hash at the end of each frame of the movie.
+func (report *DesyncReport) frame(frame int, hash uint64) {
+	if report.writer != nil {
+		fmt.Fprintf(report.writer, "%v %016x\n", frame, hash)
+	}
+
+	expected, ok := report.expected[frame]
+
+	if !ok {
+		return
+	}
+
+	report.Compared++
+
+	if hash == expected {
+		return
+	}
+
+	if report.Desynced++; report.First < 0 {
+		report.First = frame
+		fmt.Printf("*** Desync at frame %v: RAM hash %016x, expected %016x\n", frame, hash, expected)
+	}
+}
+
+// Writes out the hash log being written.
+func (report *DesyncReport) Close() (err error) {
+	if report.file == nil {
+		return
+	}
+
+	if err = report.writer.Flush(); err == nil {
+		err = report.file.Close()
+	} else {
+		report.file.Close()
+	}
+
+	report.file = nil
+
+	return
+}
+
+func (report *DesyncReport) String() string {
+	switch {
+	case report.expected == nil:
+		return "Wrote hashes of the movie's frames"
+	case report.Desynced == 0:
+		return fmt.Sprintf("%v frames checked, no desyncs", report.Compared)
+	}
+
+	return fmt.Sprintf("%v frames checked, %v desynced from frame %v on", report.Compared, report.Desynced, report.First)
+}
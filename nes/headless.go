@@ -0,0 +1,83 @@
+package nes
+
+import (
+	"image/color"
+	"sync"
+)
+
+// Video for running without a window, which throws frames away.
+type HeadlessVideo struct {
+	input     chan []uint8
+	events    chan Event
+	framePool *sync.Pool
+}
+
+func NewHeadlessVideo(events chan Event, framePool *sync.Pool) (video *HeadlessVideo) {
+	video = &HeadlessVideo{
+		input:     make(chan []uint8),
+		events:    events,
+		framePool: framePool,
+	}
+
+	go func() {
+		for colors := range video.input {
+			video.framePool.Put(colors)
+		}
+	}()
+
+	return
+}
+
+func (video *HeadlessVideo) Input() chan []uint8 {
+	return video.input
+}
+
+func (video *HeadlessVideo) Events() chan Event {
+	return video.events
+}
+
+func (video *HeadlessVideo) Run() {
+
+}
+
+func (video *HeadlessVideo) SetCaption(caption string) {
+
+}
+
+func (video *HeadlessVideo) SetPalette(palette []color.Color) {
+
+}
+
+// Audio for running without sound, which throws samples away.
+type HeadlessAudio struct {
+	input chan int16
+}
+
+func NewHeadlessAudio() (audio *HeadlessAudio) {
+	audio = &HeadlessAudio{
+		input: make(chan int16, 1024),
+	}
+
+	go func() {
+		for range audio.input {
+		}
+	}()
+
+	return
+}
+
+func (audio *HeadlessAudio) Input() chan int16 {
+	return audio.input
+}
+
+func (audio *HeadlessAudio) Run() {
+
+}
+
+func (audio *HeadlessAudio) TogglePaused() {
+
+}
+
+func (audio *HeadlessAudio) SetSpeed(speed float32) {
+
+}
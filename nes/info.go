@@ -0,0 +1,263 @@
+package nes
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/nwidger/nintengo/rp2cgo2"
+)
+
+var mapperNames = map[uint8]string{
+	0:   "NROM",
+	1:   "MMC1",
+	2:   "UNROM",
+	3:   "CNROM",
+	4:   "MMC3",
+	5:   "MMC5",
+	7:   "ANROM",
+	9:   "MMC2",
+	10:  "MMC4",
+	11:  "Color Dreams",
+	13:  "CPROM",
+	19:  "Namco 163",
+	21:  "VRC4a/VRC4c",
+	22:  "VRC2a",
+	23:  "VRC2b/VRC4e",
+	24:  "VRC6a",
+	25:  "VRC4b/VRC4d",
+	26:  "VRC6b",
+	34:  "BNROM/NINA-001",
+	66:  "GxROM",
+	69:  "Sunsoft FME-7",
+	71:  "Camerica",
+	79:  "NINA-03/NINA-06",
+	85:  "VRC7",
+	99:  "Vs. System",
+	105: "NES-EVENT",
+	118: "TxSROM",
+	119: "TQROM",
+	206: "DxROM",
+}
+
+func MapperName(mapper uint8) string {
+	if name, ok := mapperNames[mapper]; ok {
+		return name
+	}
+
+	return "Unknown"
+}
+
+// Returns true iff NewROMFromBuf can create a ROM using the given
+// mapper.
+func MapperSupported(mapper uint8) bool {
+	switch mapper {
+	case 0x00, 0x40, 0x41, 0x01, 0x02, 0x42, 0x03, 0x43, 0x04, 0x07, 0x09:
+		return true
+	}
+
+	return false
+}
+
+type ROMChecksums struct {
+	File  string `json:"file"`
+	CRC32 string `json:"crc32"`
+	SHA1  string `json:"sha1"`
+	MD5   string `json:"md5"`
+}
+
+// A description of a ROM file's header and contents suitable for
+// printing or encoding as JSON.
+type ROMInfo struct {
+	Name        string       `json:"name"`
+	Format      string       `json:"format"`
+	Mapper      uint8        `json:"mapper"`
+	MapperName  string       `json:"mapperName"`
+	Supported   bool         `json:"supported"`
+	Submapper   uint8        `json:"submapper"`
+	PRGSize     int          `json:"prgSize"`
+	CHRSize     int          `json:"chrSize"`
+	PRGRAMSize  int          `json:"prgRamSize"`
+	Mirroring   string       `json:"mirroring"`
+	Battery     bool         `json:"battery"`
+	Trainer     bool         `json:"trainer"`
+	VSCart      bool         `json:"vsCart"`
+	Region      string       `json:"region"`
+	Checksums   ROMChecksums `json:"checksums"`
+	Database    *GameInfo    `json:"database"`
+	HeaderValid bool         `json:"headerValid"`
+}
+
+func NewROMInfo(buf []byte, name string) (info *ROMInfo, err error) {
+	romf, err := NewROMFile(buf)
+
+	if err != nil {
+		return
+	}
+
+	info = &ROMInfo{
+		Name:        name,
+		Format:      "iNES",
+		Mapper:      romf.Mapper,
+		MapperName:  MapperName(romf.Mapper),
+		Supported:   MapperSupported(romf.Mapper),
+		Submapper:   romf.Submapper,
+		PRGSize:     int(romf.PRGBanks) * 16 * 1024,
+		CHRSize:     int(romf.CHRBanks) * 8 * 1024,
+		PRGRAMSize:  int(romf.RAMBanks) * 8 * 1024,
+		Mirroring:   romf.Mirroring.String(),
+		Battery:     romf.Battery,
+		Trainer:     romf.Trainer,
+		VSCart:      romf.VSCart,
+		Region:      romf.RegionFlag.String(),
+		Database:    LookupGame(romf.CRC32),
+		HeaderValid: true,
+	}
+
+	if romf.NES20 {
+		info.Format = "NES 2.0"
+	}
+
+	data := buf[16:]
+
+	if romf.Trainer {
+		data = data[512:]
+	}
+
+	data = data[:info.PRGSize+info.CHRSize]
+
+	info.Checksums = ROMChecksums{
+		File:  fmt.Sprintf("%08X", crc32.ChecksumIEEE(buf)),
+		CRC32: fmt.Sprintf("%08X", romf.CRC32),
+		SHA1:  fmt.Sprintf("%X", sha1.Sum(data)),
+		MD5:   fmt.Sprintf("%x", md5.Sum(buf)),
+	}
+
+	if info.Database != nil {
+		fixed := &ROMFile{}
+		*fixed = *romf
+
+		if err = fixed.ApplyGameInfo(info.Database); err != nil {
+			return
+		}
+
+		info.HeaderValid = string(fixed.Header()) == string(romf.Header())
+	}
+
+	// bytes 11-15 must be zero in iNES 1.0 headers, they are
+	// frequently filled with garbage like 'DiskDude!'
+	if !romf.NES20 {
+		for _, b := range buf[11:16] {
+			if b != 0x00 {
+				info.HeaderValid = false
+				break
+			}
+		}
+	}
+
+	return
+}
+
+func (info *ROMInfo) String() string {
+	s := fmt.Sprintf("Name: %v\n", info.Name) +
+		fmt.Sprintf("Format: %v\n", info.Format) +
+		fmt.Sprintf("Mapper: %v (%v)", info.Mapper, info.MapperName)
+
+	if !info.Supported {
+		s += " (unsupported)"
+	}
+
+	s += "\n" +
+		fmt.Sprintf("Submapper: %v\n", info.Submapper) +
+		fmt.Sprintf("PRG ROM: %vKB\n", info.PRGSize/1024) +
+		fmt.Sprintf("CHR ROM: %vKB\n", info.CHRSize/1024) +
+		fmt.Sprintf("PRG RAM: %vKB\n", info.PRGRAMSize/1024) +
+		fmt.Sprintf("Mirroring: %v\n", info.Mirroring) +
+		fmt.Sprintf("Battery: %v\n", info.Battery) +
+		fmt.Sprintf("Trainer: %v\n", info.Trainer) +
+		fmt.Sprintf("VS Cart: %v\n", info.VSCart) +
+		fmt.Sprintf("Region: %v\n", info.Region) +
+		fmt.Sprintf("File CRC32: %v\n", info.Checksums.File) +
+		fmt.Sprintf("ROM CRC32: %v\n", info.Checksums.CRC32) +
+		fmt.Sprintf("ROM SHA-1: %v\n", info.Checksums.SHA1) +
+		fmt.Sprintf("File MD5: %v\n", info.Checksums.MD5)
+
+	if info.Database != nil {
+		s += fmt.Sprintf("Database: %v\n", info.Database.Name)
+	} else {
+		s += "Database: no match\n"
+	}
+
+	s += fmt.Sprintf("Header valid: %v\n", info.HeaderValid)
+
+	return s
+}
+
+// Header values to force when rewriting a ROM's header, nil fields
+// are left unchanged.
+type HeaderFix struct {
+	Mapper    *uint8
+	Submapper *uint8
+	Mirroring *string
+	Battery   *bool
+	VSCart    *bool
+	Region    *string
+}
+
+// Returns a copy of the ROM in buf with its header rewritten from the
+// ROM database entry for the ROM, if any, and then the values in fix.
+// The trainer, PRG and CHR data are copied as-is.  The original buf is
+// not modified.
+func FixHeader(buf []byte, useDatabase bool, fix *HeaderFix) (fixed []byte, game *GameInfo, err error) {
+	romf, err := NewROMFile(buf)
+
+	if err != nil {
+		return
+	}
+
+	if useDatabase {
+		if game = LookupGame(romf.CRC32); game != nil {
+			if err = romf.ApplyGameInfo(game); err != nil {
+				return
+			}
+		}
+	}
+
+	if fix.Mapper != nil {
+		romf.Mapper = *fix.Mapper
+	}
+
+	if fix.Submapper != nil {
+		romf.Submapper = *fix.Submapper
+	}
+
+	if fix.Mirroring != nil {
+		if romf.Mirroring, err = MirroringFromString(*fix.Mirroring); err != nil {
+			return
+		}
+
+		romf.FourScreen = romf.Mirroring == rp2cgo2.FourScreen
+	}
+
+	if fix.Battery != nil {
+		romf.Battery = *fix.Battery
+	}
+
+	if fix.VSCart != nil {
+		romf.VSCart = *fix.VSCart
+	}
+
+	if fix.Region != nil {
+		switch romf.RegionFlag = RegionFromString(*fix.Region); romf.RegionFlag {
+		case NTSC, PAL:
+		default:
+			err = fmt.Errorf("Invalid region %v, must be NTSC or PAL", *fix.Region)
+			return
+		}
+	}
+
+	fixed = append(romf.Header(), buf[16:]...)
+
+	return
+}
@@ -0,0 +1,228 @@
+package nes
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/nwidger/nintengo/rp2cgo2"
+	"gopkg.in/yaml.v2"
+)
+
+func testROM(header []byte) []byte {
+	buf := make([]byte, 16+(16*1024)+(8*1024))
+	copy(buf, header)
+
+	for i := 16; i < len(buf); i++ {
+		buf[i] = uint8(i)
+	}
+
+	return buf
+}
+
+func TestNES20Header(t *testing.T) {
+	buf := testROM([]byte{
+		0x4e, 0x45, 0x53, 0x1a,
+		0x01, 0x01, 0x43, 0x08,
+		0x30, 0x00, 0x70, 0x07,
+		0x01, 0x00, 0x00, 0x01,
+	})
+
+	romf, err := NewROMFile(buf)
+
+	if err != nil {
+		t.Fatalf("Error loading NES 2.0 Rom: %v", err)
+	}
+
+	if !romf.NES20 {
+		t.Error("NES20 is not true")
+	}
+
+	if romf.Mapper != 4 {
+		t.Errorf("Mapper is %v not 4", romf.Mapper)
+	}
+
+	if romf.Submapper != 3 {
+		t.Errorf("Submapper is %v not 3", romf.Submapper)
+	}
+
+	if romf.RegionFlag != PAL {
+		t.Errorf("Region is %v not PAL", romf.RegionFlag)
+	}
+
+	if header := romf.Header(); !bytes.Equal(header, buf[:16]) {
+		t.Errorf("Header is % X not % X", header, buf[:16])
+	}
+}
+
+func TestFixNES20Header(t *testing.T) {
+	buf := testROM([]byte{
+		0x4e, 0x45, 0x53, 0x1a,
+		0x01, 0x01, 0x40, 0x08,
+		0x00, 0x00, 0x07, 0x07,
+		0x00, 0x00, 0x00, 0x01,
+	})
+
+	battery, region := true, "PAL"
+
+	fixed, _, err := FixHeader(buf, false, &HeaderFix{Battery: &battery, Region: &region})
+
+	if err != nil {
+		t.Fatalf("Error fixing header: %v", err)
+	}
+
+	// only the battery, the PRG-RAM made battery-backed and the region
+	// change
+	expected := []byte{
+		0x4e, 0x45, 0x53, 0x1a,
+		0x01, 0x01, 0x42, 0x08,
+		0x00, 0x00, 0x70, 0x07,
+		0x01, 0x00, 0x00, 0x01,
+	}
+
+	if !bytes.Equal(fixed[:16], expected) {
+		t.Errorf("Fixed header is % X not % X", fixed[:16], expected)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	buf := testROM([]byte{
+		0x4e, 0x45, 0x53, 0x1a,
+		0x01, 0x01, 0x13, 0x01,
+		0x01, 0x01, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	})
+
+	romf, err := NewROMFile(buf)
+
+	if err != nil {
+		t.Fatalf("Error loading Rom: %v", err)
+	}
+
+	if romf.Mapper != 1 || !romf.VSCart || !romf.Battery || romf.Mirroring != rp2cgo2.Vertical || romf.RegionFlag != PAL {
+		t.Errorf("Rom header parsed incorrectly:\n%v", romf)
+	}
+
+	if header := romf.Header(); !bytes.Equal(header, buf[:16]) {
+		t.Errorf("Header is % X not % X", header, buf[:16])
+	}
+}
+
+func TestFixHeader(t *testing.T) {
+	buf := testROM([]byte("NES\x1a\x01\x01\x00DiskDude!"))
+
+	romf, err := NewROMFile(buf)
+
+	if err != nil {
+		t.Fatalf("Error loading Rom: %v", err)
+	}
+
+	info, err := NewROMInfo(buf, "Test")
+
+	if err != nil {
+		t.Fatalf("Error getting Rom info: %v", err)
+	}
+
+	if info.HeaderValid {
+		t.Error("Header with garbage is valid")
+	}
+
+	mapper, battery := uint8(1), true
+
+	game := &GameInfo{
+		Name:      "Test (U)",
+		CRC32:     info.Checksums.CRC32,
+		Mapper:    &mapper,
+		Mirroring: "Vertical",
+		Battery:   &battery,
+		Region:    "NTSC",
+	}
+
+	if err = AddGame(game); err != nil {
+		t.Fatalf("Error adding game: %v", err)
+	}
+
+	defer delete(gameDatabase, romf.CRC32)
+
+	region := "PAL"
+
+	fixed, match, err := FixHeader(buf, true, &HeaderFix{Region: &region})
+
+	if err != nil {
+		t.Fatalf("Error fixing header: %v", err)
+	}
+
+	if match != game {
+		t.Error("Database entry not used to fix header")
+	}
+
+	expected := []byte{
+		0x4e, 0x45, 0x53, 0x1a,
+		0x01, 0x01, 0x13, 0x00,
+		0x01, 0x01, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	if !bytes.Equal(fixed[:16], expected) {
+		t.Errorf("Fixed header is % X not % X", fixed[:16], expected)
+	}
+
+	if !bytes.Equal(fixed[16:], buf[16:]) {
+		t.Error("ROM data changed when fixing header")
+	}
+
+	if string(buf[7:16]) != "DiskDude!" {
+		t.Error("Original ROM modified when fixing header")
+	}
+
+	if info, err = NewROMInfo(fixed, "Test"); err != nil {
+		t.Fatalf("Error getting Rom info: %v", err)
+	}
+
+	if info.Database != game {
+		t.Error("Fixed Rom does not match database")
+	}
+}
+
+func TestFixHeaderPortsOnly(t *testing.T) {
+	// mapper 1 with a battery
+	buf := testROM([]byte{0x4e, 0x45, 0x53, 0x1a, 0x01, 0x01, 0x12, 0x00})
+
+	romf, err := NewROMFile(buf)
+
+	if err != nil {
+		t.Fatalf("Error loading Rom: %v", err)
+	}
+
+	var games []*GameInfo
+
+	if err = yaml.Unmarshal([]byte(fmt.Sprintf("- name: Test (U)\n  crc32: %08X\n  port2: zapper\n", romf.CRC32)), &games); err != nil {
+		t.Fatalf("Error reading database: %v", err)
+	}
+
+	if err = AddGame(games[0]); err != nil {
+		t.Fatalf("Error adding game: %v", err)
+	}
+
+	defer delete(gameDatabase, romf.CRC32)
+
+	info, err := NewROMInfo(buf, "Test")
+
+	if err != nil {
+		t.Fatalf("Error getting Rom info: %v", err)
+	}
+
+	if info.Database != games[0] || !info.HeaderValid {
+		t.Error("Header is invalid for a database entry only giving the input devices")
+	}
+
+	fixed, _, err := FixHeader(buf, true, &HeaderFix{})
+
+	if err != nil {
+		t.Fatalf("Error fixing header: %v", err)
+	}
+
+	if !bytes.Equal(fixed[6:8], buf[6:8]) {
+		t.Errorf("Fixed header bytes 6 and 7 are % X not % X", fixed[6:8], buf[6:8])
+	}
+}
@@ -0,0 +1,305 @@
+package nes
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The LiveSplit splits file format, much of which is left empty.
+type lssRun struct {
+	XMLName              xml.Name     `xml:"Run"`
+	Version              string       `xml:"version,attr"`
+	GameIcon             string       `xml:"GameIcon"`
+	GameName             string       `xml:"GameName"`
+	CategoryName         string       `xml:"CategoryName"`
+	Metadata             lssMetadata  `xml:"Metadata"`
+	Offset               string       `xml:"Offset"`
+	AttemptCount         int          `xml:"AttemptCount"`
+	AttemptHistory       []lssAttempt `xml:"AttemptHistory>Attempt"`
+	Segments             []lssSegment `xml:"Segments>Segment"`
+	AutoSplitterSettings lssInner     `xml:"AutoSplitterSettings"`
+}
+
+// The content of an element kept as it is.
+type lssInner struct {
+	XML string `xml:",innerxml"`
+}
+
+type lssMetadata struct {
+	Run struct {
+		ID string `xml:"id,attr"`
+	} `xml:"Run"`
+	Platform struct {
+		UsesEmulator string `xml:"usesEmulator,attr"`
+		Name         string `xml:",chardata"`
+	} `xml:"Platform"`
+	Region    string   `xml:"Region"`
+	Variables lssInner `xml:"Variables"`
+}
+
+type lssAttempt struct {
+	ID            int    `xml:"id,attr"`
+	Started       string `xml:"started,attr"`
+	StartedSynced string `xml:"isStartedSynced,attr,omitempty"`
+	Ended         string `xml:"ended,attr"`
+	EndedSynced   string `xml:"isEndedSynced,attr,omitempty"`
+	RealTime      string `xml:"RealTime,omitempty"`
+	GameTime      string `xml:"GameTime,omitempty"`
+	PauseTime     string `xml:"PauseTime,omitempty"`
+}
+
+// A time, which is a split time when named, a time in a segment's
+// history when given an ID and the best segment time otherwise.
+type lssTime struct {
+	Name     string `xml:"name,attr,omitempty"`
+	ID       int    `xml:"id,attr,omitempty"`
+	RealTime string `xml:"RealTime,omitempty"`
+	GameTime string `xml:"GameTime,omitempty"`
+}
+
+type lssSegment struct {
+	Name            string    `xml:"Name"`
+	Icon            string    `xml:"Icon"`
+	SplitTimes      []lssTime `xml:"SplitTimes>SplitTime"`
+	BestSegmentTime lssTime   `xml:"BestSegmentTime"`
+	SegmentHistory  []lssTime `xml:"SegmentHistory>Time"`
+}
+
+// Formats a time as LiveSplit does, e.g. '00:01:23.4500000'.
+func formatLSSTime(d time.Duration) string {
+	ticks := int64(d / 100)
+	s := ticks / 10000000
+
+	return fmt.Sprintf("%02d:%02d:%02d.%07d", s/3600, s/60%60, s%60, ticks%10000000)
+}
+
+// Parses a time formatted as LiveSplit does, with the days if there
+// are any, e.g. '1.02:03:04.5000000'.
+func parseLSSTime(s string) (d time.Duration, err error) {
+	var days, h, m int
+	var sec float64
+
+	hms := strings.Split(s, ":")
+
+	if len(hms) != 3 {
+		return 0, fmt.Errorf("Invalid time '%v'", s)
+	}
+
+	if i := strings.Index(hms[0], "."); i >= 0 {
+		if days, err = strconv.Atoi(hms[0][:i]); err != nil {
+			return
+		}
+
+		hms[0] = hms[0][i+1:]
+	}
+
+	if h, err = strconv.Atoi(hms[0]); err != nil {
+		return
+	}
+
+	if m, err = strconv.Atoi(hms[1]); err != nil {
+		return
+	}
+
+	if sec, err = strconv.ParseFloat(hms[2], 64); err != nil {
+		return
+	}
+
+	d = time.Duration(days*24+h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second))
+
+	return
+}
+
+// Returns the shorter of two LiveSplit times, either of which may be
+// empty.
+func minLSSTime(a, b string) string {
+	da, errA := parseLSSTime(a)
+	db, errB := parseLSSTime(b)
+
+	if errA != nil || (errB == nil && db < da) {
+		return b
+	}
+
+	return a
+}
+
+func formatLSSDate(t time.Time) string {
+	return t.UTC().Format("01/02/2006 15:04:05")
+}
+
+// Reads a LiveSplit splits file, returning nil if there is none.
+func readLSS(filename string) (run *lssRun, err error) {
+	buf, err := ioutil.ReadFile(filename)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+
+	run = &lssRun{}
+
+	if err = xml.Unmarshal(buf, run); err != nil {
+		run = nil
+	}
+
+	return
+}
+
+// Returns a copy of run which shares nothing it can change with it.
+func (run lssRun) copy() lssRun {
+	run.AttemptHistory = append([]lssAttempt{}, run.AttemptHistory...)
+	run.Segments = append([]lssSegment{}, run.Segments...)
+
+	for i := range run.Segments {
+		segment := &run.Segments[i]
+		segment.SplitTimes = append([]lssTime{}, segment.SplitTimes...)
+		segment.SegmentHistory = append([]lssTime{}, segment.SegmentHistory...)
+	}
+
+	return run
+}
+
+// Writes the runs timed as a LiveSplit splits file, added to the file
+// there was before the timer started if there was one.  Each run is in
+// the attempt history, with the time of each segment it reached in the
+// segment's history, the fastest finished run is the personal best
+// unless the one from before is faster and each segment's best time is
+// the fastest it was run in.
+func (timer *SplitTimer) WriteLSS(w io.Writer) (err error) {
+	var run lssRun
+
+	if timer.previous != nil {
+		run = timer.previous.copy()
+	} else {
+		run = lssRun{
+			Version:      "1.7.0",
+			GameName:     timer.Game,
+			CategoryName: timer.Category,
+			Offset:       "00:00:00",
+		}
+
+		run.Metadata.Platform.Name = "NES"
+		run.Metadata.Platform.UsesEmulator = "True"
+
+		for _, name := range timer.Names {
+			run.Segments = append(run.Segments, lssSegment{Name: name})
+		}
+	}
+
+	// attempts are numbered on from the ones before
+	id := 1
+
+	for _, a := range run.AttemptHistory {
+		if a.ID >= id {
+			id = a.ID + 1
+		}
+	}
+
+	run.AttemptCount += len(timer.Attempts)
+
+	pb := -1
+	n := len(timer.Names)
+
+	for i, attempt := range timer.Attempts {
+		a := lssAttempt{
+			ID:      id + i,
+			Started: formatLSSDate(attempt.Started),
+			Ended:   formatLSSDate(attempt.Ended),
+		}
+
+		if len(attempt.Frames) == n {
+			a.RealTime = formatLSSTime(attempt.RealTime[n-1])
+			a.GameTime = formatLSSTime(framesToDuration(attempt.Frames[n-1], timer.fps))
+
+			if pb < 0 || attempt.Frames[n-1] < timer.Attempts[pb].Frames[n-1] {
+				pb = i
+			}
+		}
+
+		run.AttemptHistory = append(run.AttemptHistory, a)
+	}
+
+	// a run finished before stays the personal best unless it is
+	// slower, by in-game time if it has one
+	if pb >= 0 {
+		old := run.Segments[n-1].personalBest()
+		faster := true
+
+		if d, e := parseLSSTime(old.GameTime); e == nil {
+			faster = framesToDuration(timer.Attempts[pb].Frames[n-1], timer.fps) < d
+		} else if d, e := parseLSSTime(old.RealTime); e == nil {
+			faster = timer.Attempts[pb].RealTime[n-1] < d
+		}
+
+		if !faster {
+			pb = -1
+		}
+	}
+
+	for i := range timer.Names {
+		segment := &run.Segments[i]
+
+		if t := segment.personalBest(); pb >= 0 {
+			t.RealTime = formatLSSTime(timer.Attempts[pb].RealTime[i])
+			t.GameTime = formatLSSTime(framesToDuration(timer.Attempts[pb].Frames[i], timer.fps))
+		}
+
+		for j, attempt := range timer.Attempts {
+			if len(attempt.Frames) <= i {
+				continue
+			}
+
+			frames, realTime := attempt.Frames[i], attempt.RealTime[i]
+
+			if i > 0 {
+				frames -= attempt.Frames[i-1]
+				realTime -= attempt.RealTime[i-1]
+			}
+
+			t := lssTime{
+				ID:       id + j,
+				RealTime: formatLSSTime(realTime),
+				GameTime: formatLSSTime(framesToDuration(frames, timer.fps)),
+			}
+
+			segment.SegmentHistory = append(segment.SegmentHistory, t)
+			segment.BestSegmentTime.RealTime = minLSSTime(segment.BestSegmentTime.RealTime, t.RealTime)
+			segment.BestSegmentTime.GameTime = minLSSTime(segment.BestSegmentTime.GameTime, t.GameTime)
+		}
+	}
+
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err = enc.Encode(&run); err == nil {
+		_, err = io.WriteString(w, "\n")
+	}
+
+	return
+}
+
+// Returns the segment's personal best split time, adding an empty one
+// if it has none.
+func (segment *lssSegment) personalBest() *lssTime {
+	for i := range segment.SplitTimes {
+		if segment.SplitTimes[i].Name == "Personal Best" {
+			return &segment.SplitTimes[i]
+		}
+	}
+
+	segment.SplitTimes = append(segment.SplitTimes, lssTime{Name: "Personal Best"})
+
+	return &segment.SplitTimes[len(segment.SplitTimes)-1]
+}
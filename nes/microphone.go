@@ -0,0 +1,156 @@
+package nes
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/cryptix/wav"
+)
+
+// The level, as a fraction of full scale, a WAV file must reach during
+// a frame to be heard by the microphone.
+const MicrophoneThreshold = 0.1
+
+// Famicom controller II, which has a microphone in place of Select and
+// Start.  The microphone is not read through controller II's register
+// but through bit 2 of $4016.  It hears something while Blowing is set
+// or while the WAV file loaded, played from power on and on through
+// resets, is loud enough.
+type FamicomController struct {
+	*Controller
+	Blowing bool
+	levels  []float64
+	frame   int
+}
+
+func NewFamicomController() *FamicomController {
+	return &FamicomController{
+		Controller: NewController(),
+	}
+}
+
+func famicomControllerFromOptions(options *Options) (fc *FamicomController, err error) {
+	var f *os.File
+	var info os.FileInfo
+
+	fc = NewFamicomController()
+
+	if options == nil || options.MicrophoneWAV == "" {
+		return
+	}
+
+	if f, err = os.Open(options.MicrophoneWAV); err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	if info, err = f.Stat(); err != nil {
+		return
+	}
+
+	fps := DefaultFPSNTSC
+
+	if RegionFromString(options.Region) == PAL {
+		fps = DefaultFPSPAL
+	}
+
+	if err = fc.LoadWAV(f, info.Size(), fps); err != nil {
+		err = fmt.Errorf("Error loading microphone WAV file %v: %v", options.MicrophoneWAV, err)
+	}
+
+	return
+}
+
+// Loads the WAV file the microphone hears, played at fps frames per
+// second.
+func (fc *FamicomController) LoadWAV(reader io.ReadSeeker, size int64, fps float64) (err error) {
+	var r *wav.Reader
+	var sample int32
+
+	if r, err = wav.NewReader(reader, size); err != nil {
+		return
+	}
+
+	meta := r.GetFile()
+
+	if meta.SignificantBits == 0 || meta.SampleRate == 0 {
+		return fmt.Errorf("Invalid WAV file format")
+	}
+
+	full := math.Pow(2, float64(meta.SignificantBits-1))
+	perFrame := int(float64(meta.SampleRate)*float64(meta.Channels)/fps + 0.5)
+	peak, count := 0.0, 0
+
+	levels := []float64{}
+
+	for {
+		if sample, err = r.ReadSample(); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+
+		value := float64(sample)
+
+		// 8 bit samples are unsigned
+		if meta.SignificantBits == 8 {
+			value -= 128
+		}
+
+		peak = math.Max(peak, math.Abs(value)/full)
+
+		if count++; count == perFrame {
+			levels = append(levels, peak)
+			peak, count = 0, 0
+		}
+	}
+
+	if count > 0 {
+		levels = append(levels, peak)
+	}
+
+	fc.setLevels(levels)
+
+	return
+}
+
+// Sets the peak level of the sound heard during each frame.
+func (fc *FamicomController) setLevels(levels []float64) {
+	fc.levels = levels
+	fc.frame = 0
+}
+
+func (fc *FamicomController) save() func() {
+	saved, ctrl := *fc, fc.Controller.save()
+
+	return func() {
+		*fc = saved
+		ctrl()
+	}
+}
+
+func (fc *FamicomController) KeyDown(btn Button) {
+	if btn != Select && btn != Start {
+		fc.Controller.KeyDown(btn)
+	}
+}
+
+// Returns bit 2 of $4016, set while the microphone hears something.
+func (fc *FamicomController) Microphone() (value uint8) {
+	if fc.Blowing || (fc.frame < len(fc.levels) && fc.levels[fc.frame] >= MicrophoneThreshold) {
+		value = 0x04
+	}
+
+	return
+}
+
+// Moves on to the next frame of the WAV file.
+func (fc *FamicomController) Frame() {
+	if fc.frame < len(fc.levels) {
+		fc.frame++
+	}
+}
@@ -0,0 +1,438 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type movieMode uint8
+
+const (
+	movieRecording movieMode = iota
+	moviePlaying
+	movieFinished
+)
+
+// Records an input movie, or plays one back, a frame at a time.  While
+// recording, controller input and resets take effect at the end of the
+// frame they arrive in, so that playing the movie back, which sets
+// each frame's input at the same point, runs the game exactly as it
+// ran while recording.
+//
+// In read-only mode input is ignored during playback and loading a
+// state made while playing the movie seeks the movie to the state's
+// frame.  In read-write mode input during playback, or loading a
+// state, drops the rest of the movie and records from that frame on,
+// counting a rerecord.
+type MovieSession struct {
+	Movie    *Movie
+	ReadOnly bool
+	filename string
+	mode     movieMode
+	started  bool
+	modified bool
+	frame    int
+	commands uint8
+	pending  []*ControllerEvent
+	extend   bool // add blank frames when playing past the end
+	report   *DesyncReport
+}
+
+// Returns a session recording to the .fm2 file given by the
+// -record-movie option, or playing the one given by -play-movie, nil
+// if neither is given.  Movies played may also be BizHawk .bk2 files,
+// which are saved as .fm2 files if recorded to.
+func NewMovieSession(options *Options, rom *ROMFile) (session *MovieSession, err error) {
+	switch {
+	case options.RecordMovie == "" && options.PlayMovie == "":
+		if options.HashLog != "" || options.WriteHashLog != "" {
+			err = errors.New("Hash logs need a movie, given by -play-movie or -record-movie")
+		}
+
+		return
+	case options.RecordMovie != "" && options.PlayMovie != "":
+		err = errors.New("Cannot record and play a movie at the same time")
+		return
+	case rom == nil || options.Listen != "" || options.Connect != "":
+		err = errors.New("Movies cannot be used with netplay")
+		return
+	}
+
+	session = &MovieSession{}
+
+	if session.report, err = NewDesyncReport(options); err != nil {
+		return
+	}
+
+	if options.PlayMovie != "" {
+		var f *os.File
+		var fi os.FileInfo
+
+		if f, err = os.Open(options.PlayMovie); err != nil {
+			return
+		}
+
+		defer f.Close()
+
+		session.filename = options.PlayMovie
+		session.mode = moviePlaying
+		session.ReadOnly = true
+
+		ext := filepath.Ext(options.PlayMovie)
+
+		if strings.ToLower(ext) != ".bk2" {
+			if session.Movie, err = ReadFM2(f); err != nil {
+				return
+			}
+
+			if !bytes.Equal(session.Movie.ROMChecksum, ROMChecksum(rom)) {
+				fmt.Printf("*** Movie %v was recorded with a different ROM\n", options.PlayMovie)
+			}
+
+			return
+		}
+
+		if fi, err = f.Stat(); err != nil {
+			return
+		}
+
+		if session.Movie, err = ReadBK2(f, fi.Size()); err != nil {
+			return
+		}
+
+		if session.Movie.ROMSHA1 != nil && !bytes.Equal(session.Movie.ROMSHA1, ROMSHA1(rom)) {
+			fmt.Printf("*** Movie %v was recorded with a different ROM\n", options.PlayMovie)
+		}
+
+		session.Movie.ROMChecksum = ROMChecksum(rom)
+		session.filename = strings.TrimSuffix(options.PlayMovie, ext) + ".fm2"
+
+		fmt.Printf("*** Imported %v frames from BizHawk movie %v\n", len(session.Movie.Frames), options.PlayMovie)
+
+		return
+	}
+
+	if session.Movie, err = NewMovie(rom); err != nil {
+		return
+	}
+
+	if options.MovieSavestate != "" {
+		if session.Movie.Savestate, err = ioutil.ReadFile(options.MovieSavestate); err != nil {
+			return
+		}
+	}
+
+	session.filename = options.RecordMovie
+	session.mode = movieRecording
+	session.modified = true
+
+	return
+}
+
+// Starts the movie, after nes has been powered on.
+func (session *MovieSession) start(nes *NES) {
+	m := session.Movie
+
+	if m.Savestate != nil {
+		nes.LoadStateFromReader(bytes.NewReader(m.Savestate), int64(len(m.Savestate)))
+	}
+
+	session.started = true
+	session.seek(nes, 0)
+
+	switch session.mode {
+	case movieRecording:
+		m.Opposing = *nes.Opposing
+
+		if fs, ok := nes.controllers.Device(Port1).(*FourScore); ok && !fs.famicom {
+			m.FourScore = true
+		}
+
+		for controller := 0; controller < 2; controller++ {
+			if nes.controllers.controller(controller) == nil {
+				m.Ports[controller] = FM2None
+			}
+		}
+
+		m.Frames = []MovieFrame{session.snapshot(nes, 0)}
+		m.Lag = nil
+
+		fmt.Println("*** Recording movie to", session.filename)
+	case moviePlaying:
+		*nes.Opposing = m.Opposing
+
+		if len(m.Frames) == 0 {
+			session.finish(nes)
+			return
+		}
+
+		session.apply(nes, m.Frames[0])
+
+		fmt.Println("*** Playing movie", session.filename)
+	}
+}
+
+func (session *MovieSession) seek(nes *NES, frame int) {
+	session.frame = frame
+	session.commands = 0
+	session.pending = nil
+	nes.MovieFrame = frame
+}
+
+func (session *MovieSession) finish(nes *NES) {
+	session.mode = movieFinished
+	fmt.Println("*** Movie finished")
+
+	if session.report != nil {
+		fmt.Println("***", session.report)
+	}
+
+	// there is nothing more to do without a window
+	if nes.options != nil && nes.options.Headless {
+		nes.state = Quitting
+	}
+}
+
+// Drops the frames after the current one and records from there on.
+func (session *MovieSession) rerecord() {
+	m := session.Movie
+
+	m.Frames = m.Frames[:session.frame+1]
+	m.truncateLag(session.frame)
+	m.RerecordCount++
+
+	session.mode = movieRecording
+	session.modified = true
+
+	fmt.Println("*** Recording movie from frame", session.frame)
+}
+
+// Returns the input in effect on nes as a frame of the movie, starting
+// with commands.
+func (session *MovieSession) snapshot(nes *NES, commands uint8) (frame MovieFrame) {
+	frame.Commands = commands
+
+	for controller := range frame.Buttons {
+		frame.Buttons[controller] = nes.controllers.Buttons(controller)
+	}
+
+	return
+}
+
+// Runs the commands of a frame of the movie and sets its input.
+func (session *MovieSession) apply(nes *NES, frame MovieFrame) {
+	if frame.Commands&(MovieSoftReset|MovieHardReset) != 0 {
+		nes.Reset()
+	}
+
+	for controller, buttons := range frame.Buttons {
+		nes.controllers.SetButtons(controller, buttons)
+	}
+}
+
+// Called with every ControllerEvent, returns true if the movie takes
+// the event in place of the controllers.
+func (session *MovieSession) input(e *ControllerEvent) bool {
+	switch session.mode {
+	case moviePlaying:
+		if session.ReadOnly {
+			return true
+		}
+
+		session.rerecord()
+		fallthrough
+	case movieRecording:
+		session.pending = append(session.pending, e)
+		return true
+	}
+
+	return false
+}
+
+// Called with every ResetEvent, returns true if the movie takes the
+// reset in place of the NES.
+func (session *MovieSession) reset() bool {
+	switch session.mode {
+	case moviePlaying:
+		if session.ReadOnly {
+			return true
+		}
+
+		session.rerecord()
+		fallthrough
+	case movieRecording:
+		session.commands |= MovieSoftReset
+		return true
+	}
+
+	return false
+}
+
+// Runs the reset and input recorded since the end of the last frame
+// and returns the commands to record.
+func (session *MovieSession) flush(nes *NES) (commands uint8) {
+	commands = session.commands
+
+	if commands&MovieSoftReset != 0 {
+		nes.Reset()
+	}
+
+	for _, e := range session.pending {
+		if e.Down {
+			nes.controllers.KeyDown(e.Controller, e.Button)
+		} else {
+			nes.controllers.KeyUp(e.Controller, e.Button)
+		}
+	}
+
+	session.commands = 0
+	session.pending = nil
+
+	return
+}
+
+// Called when stepping a frame while paused at the end of the last
+// one, records the input given while paused as the input of the frame
+// about to be run rather than of the one after it.
+func (session *MovieSession) latch(nes *NES) {
+	m := session.Movie
+
+	if session.mode != movieRecording || (session.commands == 0 && len(session.pending) == 0) {
+		return
+	}
+
+	commands := session.flush(nes)
+	m.Frames[session.frame] = session.snapshot(nes, m.Frames[session.frame].Commands|commands)
+}
+
+// Called at the end of every frame in place of Controllers.Frame.
+// Whether the frame was a lag frame is recorded both while recording
+// and while playing, so that playing a movie fills in lag frames
+// which it lacks.
+func (session *MovieSession) endFrame(nes *NES) {
+	m := session.Movie
+
+	switch session.mode {
+	case movieRecording:
+		m.SetLag(session.frame, nes.Lagged)
+		session.check(nes)
+
+		commands := session.flush(nes)
+
+		nes.controllers.Frame()
+
+		session.frame++
+		m.Frames = append(m.Frames[:session.frame], session.snapshot(nes, commands))
+	case moviePlaying:
+		m.SetLag(session.frame, nes.Lagged)
+		session.check(nes)
+
+		nes.controllers.Frame()
+
+		if session.frame++; session.frame == len(m.Frames) && session.extend {
+			m.Frames = append(m.Frames, MovieFrame{})
+			session.modified = true
+		}
+
+		if session.frame < len(m.Frames) {
+			session.apply(nes, m.Frames[session.frame])
+		} else {
+			session.finish(nes)
+		}
+	default:
+		nes.controllers.Frame()
+		return
+	}
+
+	nes.MovieFrame = session.frame
+}
+
+// Checks the frame which just ended against the hash log, and writes
+// its hash to the log being written.
+func (session *MovieSession) check(nes *NES) {
+	if session.report != nil {
+		session.report.frame(session.frame, nes.RAMHash())
+	}
+}
+
+// Called after a state has been loaded, moves the movie to the frame
+// the state was saved at.
+func (session *MovieSession) loadedState(nes *NES) {
+	m := session.Movie
+
+	if !session.started {
+		return
+	}
+
+	frame := nes.MovieFrame
+
+	if frame < 0 || frame >= len(m.Frames) {
+		fmt.Printf("*** State is from frame %v, after the end of the movie\n", frame)
+		session.mode = movieFinished
+		return
+	}
+
+	session.seek(nes, frame)
+
+	if session.ReadOnly {
+		session.mode = moviePlaying
+
+		if !nes.quiet {
+			fmt.Println("*** Playing movie from frame", frame)
+		}
+	} else {
+		session.rerecord()
+	}
+
+	for controller, buttons := range m.Frames[frame].Buttons {
+		nes.controllers.SetButtons(controller, buttons)
+	}
+}
+
+func (session *MovieSession) ToggleReadOnly() bool {
+	session.ReadOnly = !session.ReadOnly
+	return session.ReadOnly
+}
+
+// Writes the movie to its file if it has been recorded to, and the
+// hash log if one is being written.
+func (session *MovieSession) Save() (err error) {
+	var f *os.File
+
+	if session.report != nil {
+		if err = session.report.Close(); err != nil {
+			return
+		}
+	}
+
+	if !session.modified {
+		return
+	}
+
+	if f, err = os.Create(session.filename); err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	fmt.Println("*** Saving movie to", session.filename)
+
+	return session.Movie.WriteFM2(f)
+}
+
+// Returns the frame counter shown while the movie runs.
+func (session *MovieSession) String() string {
+	switch session.mode {
+	case movieRecording:
+		return fmt.Sprintf("%v REC", session.frame)
+	case moviePlaying:
+		return fmt.Sprintf("%v/%v", session.frame, len(session.Movie.Frames)-1)
+	}
+
+	return ""
+}
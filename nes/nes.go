@@ -6,6 +6,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"log"
@@ -14,6 +15,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"time"
 
 	"encoding/json"
 
@@ -41,21 +43,56 @@ const (
 	Unpause
 )
 
+//go:generate stringer -type=StepMode
+type StepMode uint8
+
+// How far a StepEvent runs the NES while paused before pausing again.
+const (
+	NoStep StepMode = iota
+	FrameStep
+	ScanlineStep
+	InstructionStep
+)
+
 type NES struct {
 	GameName      string
 	state         RunState
 	Paused        bool
+	stepping      StepMode
+	stepScanline  uint16
+	frameEnded    bool
+	queued        []Event
+	queueLock     sync.Mutex
 	events        chan Event
 	CPU           *rp2ago3.RP2A03
 	CPUDivisor    float32
 	PPU           *rp2cgo2.RP2C02
 	PPUQuota      float32
 	controllers   *Controllers
+	Turbo         *Turbo
+	Opposing      *Opposing
+	vs            *VSSystem
+	gamepads      *Gamepads
+	slot          int
+	movie         *MovieSession
+	tas           *TASEditor
+	quiet         bool // emulating frames which are neither shown nor heard
+	runAhead      int
+	ahead         int // frames left to run ahead, the last of which is shown
+	MovieFrame    int
+	LagFrames     int  // frames during which the game did not read the controllers
+	Lagged        bool // the last frame was a lag frame
+	lagCounter    bool
 	ROM           ROM
 	audio         Audio
 	video         Video
 	DefaultFPS    float64
 	fps           *FPS
+	speed         float64
+	fastForward   float64 // the speed before fast-forward was held, 0 if it is not
+	audioSync     *AudioSync
+	timer         *SplitTimer
+	frames        uint64 // frames run, for reporting the speed
 	recorder      Recorder
 	audioRecorder AudioRecorder
 	options       *Options
@@ -67,22 +104,60 @@ type NES struct {
 }
 
 type Options struct {
-	Region        string
-	Recorder      string
-	AudioRecorder string
-	CPUDecode     bool
-	CPUProfile    string
-	MemProfile    string
-	HTTPAddress   string
-	Listen        string
-	Connect       string
+	Region          string
+	Recorder        string
+	AudioRecorder   string
+	CPUDecode       bool
+	CPUProfile      string
+	MemProfile      string
+	HTTPAddress     string
+	Listen          string
+	Connect         string
+	Patch           string
+	Database        string
+	VSPPU           string
+	VSDIP           string
+	Port1           string
+	Port2           string
+	Expansion       string
+	VausSensitivity float64
+	VausRange       string
+	TurboOn         int
+	TurboOff        int
+	Opposing        string
+	MicrophoneWAV   string
+	RecordMovie     string
+	PlayMovie       string
+	MovieSavestate  string
+	Deterministic   bool
+	Headless        bool
+	Frames          int
+	TAS             bool
+	RunAhead        int
+	Speed           string
+	AudioSync       bool
+	Splits          string
+	LSS             string
+	HashLog         string
+	WriteHashLog    string
+	LagCounter      bool
+	Gamepads        []GamepadMapping
+	Bindings        Bindings
 }
 
 func NewNES(filename string, options *Options) (nes *NES, err error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		err = errors.New(fmt.Sprintf("Error loading ROM: %v", err))
-		return
+	var f *os.File
+
+	// slaves receive the ROM from the master
+	if len(options.Connect) == 0 {
+		archive, _ := splitROMSource(filename)
+
+		if f, err = os.Open(archive); err != nil {
+			err = errors.New(fmt.Sprintf("Error loading ROM: %v", err))
+			return
+		}
+
+		defer f.Close()
 	}
 
 	return NewNESFromReader(filename, f, options)
@@ -97,6 +172,7 @@ func NewNESFromReader(gamename string, reader io.Reader, options *Options) (nes
 	var master bool
 	var bridge *Bridge
 	var rom ROM
+	var vs *VSSystem
 
 	region := RegionFromString(options.Region)
 
@@ -131,12 +207,31 @@ func NewNESFromReader(gamename string, reader io.Reader, options *Options) (nes
 			return nil, err
 		}
 
-		rom, err = NewROMFromBuf(buf, gamename, ".nes", cpu.InterruptLine(m65go2.Irq), ppu.Nametable.SetTables)
+		buf, gamename, err = extractROM(buf, gamename)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Error loading ROM: %v", err))
+			return nil, err
+		}
+
+		buf, err = patchROM(buf, gamename, options.Patch)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Error applying patch: %v", err))
+			return nil, err
+		}
+
+		rom, err = NewROMFromBuf(buf, gamename, "", cpu.InterruptLine(m65go2.Irq), ppu.Nametable.SetTables)
 		if err != nil {
 			err = errors.New(fmt.Sprintf("Error loading ROM: %v", err))
 			return nil, err
 		}
 		gamename = rom.GameName()
+
+		vs, err = vsSystemForROM(rom.GetROMFile(), options)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Error loading ROM: %v", err))
+			return nil, err
+		}
+
 		switch region {
 		case NTSC:
 			cpuDivisor = rp2ago3.NTSCCPUClockDivisor
@@ -147,6 +242,59 @@ func NewNESFromReader(gamename string, reader io.Reader, options *Options) (nes
 	}
 
 	ctrls := NewControllers()
+	ctrls.ppu = ppu
+	ctrls.vs = vs
+
+	var romf *ROMFile
+
+	if rom != nil {
+		romf = rom.GetROMFile()
+	}
+
+	if err = ctrls.Configure(romf, options); err != nil {
+		err = errors.New(fmt.Sprintf("Error configuring input devices: %v", err))
+		return
+	}
+
+	movie, err := NewMovieSession(options, romf)
+
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Error loading movie: %v", err))
+		return
+	}
+
+	if err = checkRunAhead(options); err != nil {
+		return
+	}
+
+	// without a window there is no quitting but at the end of a movie
+	// or after a number of frames
+	if options.Headless && options.PlayMovie == "" && options.Frames <= 0 {
+		err = errors.New("Headless mode needs a movie to play, given by -play-movie, or a number of frames to run, given by -frames")
+		return
+	}
+
+	speed := 1.0
+
+	if options.Speed != "" {
+		if speed, err = ParseSpeed(options.Speed); err != nil {
+			return
+		}
+	}
+
+	gamepads, err := NewGamepads(options.Gamepads)
+
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Error configuring gamepads: %v", err))
+		return
+	}
+
+	bindings, err := NewKeyBindings(&options.Bindings)
+
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Error configuring key bindings: %v", err))
+		return
+	}
 
 	DefaultFPS := DefaultFPSNTSC
 	if region == PAL {
@@ -155,21 +303,34 @@ func NewNESFromReader(gamename string, reader io.Reader, options *Options) (nes
 
 	fps := NewFPS(DefaultFPS)
 
-	events := make(chan Event)
-	framePool := &sync.Pool{New: func() interface{} { return make([]uint8, rp2cgo2.FrameSize) }}
-
-	video, err = NewVideo(gamename, events, framePool, DefaultFPS)
+	timer, err := NewSplitTimer(options, gamename, DefaultFPS)
 
 	if err != nil {
-		err = errors.New(fmt.Sprintf("Error creating video: %v", err))
+		err = errors.New(fmt.Sprintf("Error loading splits: %v", err))
 		return
 	}
 
-	audio, err = NewAudio(audioFrequency, audioSampleSize)
+	events := make(chan Event)
+	framePool := &sync.Pool{New: func() interface{} { return make([]uint8, rp2cgo2.FrameSize) }}
 
-	if err != nil {
-		err = errors.New(fmt.Sprintf("Error creating audio: %v", err))
-		return
+	if options.Headless {
+		video = NewHeadlessVideo(events, framePool)
+		audio = NewHeadlessAudio()
+		fps.Disable()
+	} else {
+		video, err = NewVideo(gamename, events, bindings, framePool, DefaultFPS)
+
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Error creating video: %v", err))
+			return
+		}
+
+		audio, err = NewAudio(audioFrequency, audioSampleSize)
+
+		if err != nil {
+			err = errors.New(fmt.Sprintf("Error creating audio: %v", err))
+			return
+		}
 	}
 
 	switch options.Recorder {
@@ -201,6 +362,19 @@ func NewNESFromReader(gamename string, reader io.Reader, options *Options) (nes
 	cpu.Memory.AddMappings(ppu, rp2ago3.CPU)
 	cpu.Memory.AddMappings(ctrls, rp2ago3.CPU)
 
+	if vs != nil {
+		palette, _ := VSPalette(vs.PPU)
+		video.SetPalette(palette)
+
+		if recorder != nil {
+			recorder.SetPalette(palette)
+		}
+
+		cpu.Memory.AddMappings(vs, rp2ago3.CPU)
+
+		fmt.Println("***", vs)
+	}
+
 	if master {
 		cpu.Memory.AddMappings(rom, rp2ago3.CPU)
 		ppu.Memory.AddMappings(rom, rp2ago3.PPU)
@@ -220,9 +394,18 @@ func NewNESFromReader(gamename string, reader io.Reader, options *Options) (nes
 		video:         video,
 		DefaultFPS:    DefaultFPS,
 		fps:           fps,
+		speed:         1,
 		recorder:      recorder,
 		audioRecorder: audioRecorder,
 		controllers:   ctrls,
+		Turbo:         ctrls.turbo,
+		Opposing:      ctrls.opposing,
+		movie:         movie,
+		lagCounter:    options.LagCounter,
+		timer:         timer,
+		runAhead:      options.RunAhead,
+		vs:            vs,
+		gamepads:      gamepads,
 		options:       options,
 		lock:          lock,
 		Tick:          0,
@@ -233,6 +416,33 @@ func NewNESFromReader(gamename string, reader io.Reader, options *Options) (nes
 
 	bridge.nes = nes
 
+	if options.AudioSync && !options.Headless {
+		cpuFrequency := NTSCCPUFrequency
+
+		if region == PAL {
+			cpuFrequency = PALCPUFrequency
+		}
+
+		apuFrequency := APUSampleRate(cpuFrequency, cpu.APU.TargetCycles)
+
+		nes.audioSync = NewAudioSync(audio.Input(), apuFrequency, float64(audioFrequency))
+		fps.Disable()
+	}
+
+	// headless runs as fast as it can unless told otherwise
+	if options.Speed != "" {
+		nes.SetSpeed(speed)
+	} else if options.Headless {
+		nes.speed = UnlimitedSpeed
+	}
+
+	if options.TAS {
+		if nes.tas, err = NewTASEditor(nes); err != nil {
+			err = errors.New(fmt.Sprintf("Error starting TAS editor: %v", err))
+			return
+		}
+	}
+
 	return
 }
 
@@ -241,6 +451,14 @@ func (nes *NES) Reset() {
 	nes.PPU.Reset()
 	nes.PPUQuota = float32(0)
 	nes.controllers.Reset()
+
+	if nes.vs != nil {
+		nes.vs.Reset()
+	}
+
+	if nes.timer != nil {
+		nes.timer.skip()
+	}
 }
 
 func (nes *NES) RunState() RunState {
@@ -254,8 +472,47 @@ func (nes *NES) Pause() RunState {
 	return nes.state
 }
 
+// Runs the NES while paused until the next frame, scanline or
+// instruction and pauses it again, or pauses a running NES there.
+// Called from outside the emulation, e.g. by the HTTP server, so it
+// holds the lock the TAS editor takes around the movie.
+func (nes *NES) Step(mode StepMode) {
+	lock := <-nes.lock
+	defer func() { nes.lock <- lock }()
+
+	e := &StepEvent{Mode: mode}
+	e.Process(nes)
+}
+
+// Returns true once the NES has run as far as the current StepEvent
+// asked, which for a frame is the end of the instruction during which
+// the PPU finished it.
+func (nes *NES) stepped() bool {
+	switch nes.stepping {
+	case FrameStep:
+		return nes.frameEnded
+	case ScanlineStep:
+		return nes.PPU.Scanline != nes.stepScanline
+	}
+
+	return true
+}
+
+// The number of save state slots.
+const StateSlots = 10
+
+// Returns the file the state is saved to in the current slot, slot 0
+// being the game name with .nst appended.
+func (nes *NES) stateFile() string {
+	if nes.slot == 0 {
+		return nes.GameName + ".nst"
+	}
+
+	return fmt.Sprintf("%v.%v.nst", nes.GameName, nes.slot)
+}
+
 func (nes *NES) SaveState() {
-	name := nes.GameName + ".nst"
+	name := nes.stateFile()
 
 	fo, err := os.Create(name)
 	defer fo.Close()
@@ -320,7 +577,7 @@ func (nes *NES) SaveStateToWriter(writer io.Writer) (err error) {
 }
 
 func (nes *NES) LoadState() {
-	name := nes.GameName + ".nst"
+	name := nes.stateFile()
 	reader, err := os.Open(name)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening file %s: %s\n", name, err)
@@ -415,6 +672,15 @@ func (nes *NES) LoadStateFromReader(reader io.ReaderAt, size int64) (err error)
 
 	if !loaded {
 		fmt.Printf("*** Error loading state: invalid save state file\n")
+		return
+	}
+
+	if nes.movie != nil {
+		nes.movie.loadedState(nes)
+	}
+
+	if nes.timer != nil {
+		nes.timer.skip()
 	}
 
 	return
@@ -435,36 +701,128 @@ func (nes *NES) getLoadStateEvent() (ev *LoadStateEvent, err error) {
 
 func (nes *NES) processEvents() {
 	for nes.state != Quitting {
-		e := <-nes.events
-		flag := e.Flag()
+		nes.route(<-nes.events)
+	}
+}
 
-		if !nes.master && flag&EvSlave == 0 {
-			continue
-		}
+// Processes e here, or sends it over the bridge if it must be processed
+// by both master and slave.
+func (nes *NES) route(e Event) {
+	flag := e.Flag()
 
-		if !nes.bridge.active || flag&EvGlobal == 0 {
-			e.Process(nes)
-			continue
-		}
+	if !nes.master && flag&EvSlave == 0 {
+		return
+	}
+
+	if !nes.bridge.active || flag&EvGlobal == 0 {
+		nes.process(e)
+		return
+	}
+
+	// Tick is not important here. Just a Reference
+	if e.String() == "ControllerEvent" && !nes.master {
+		// hardcode to fix controller id, the slave's
+		// player 1 is the master's player 2
+		ce, _ := e.(*ControllerEvent)
 
-		// Tick is not important here. Just a Reference
-		if e.String() == "ControllerEvent" && !nes.master {
-			// hardcode to fix controller id.
-			ce, _ := e.(*ControllerEvent)
+		if ce.Controller == 0 {
 			ce.Controller = 1
 		}
+	}
 
-		pkt := Packet{
-			Tick: nes.Tick,
-			Ev:   e,
-		}
+	pkt := Packet{
+		Tick: nes.Tick,
+		Ev:   e,
+	}
+
+	if nes.master {
+		nes.bridge.incoming <- pkt
+	} else {
+		nes.bridge.outgoing <- pkt
+	}
+}
+
+// Processes e, or in deterministic mode queues it until the end of the
+// frame if it is an input event, so that input takes effect at the
+// same point in emulation whenever it arrives.
+func (nes *NES) process(e Event) {
+	if nes.options != nil && nes.options.Deterministic && e.Flag()&EvInput != 0 {
+		nes.queueLock.Lock()
+		nes.queued = append(nes.queued, e)
+		nes.queueLock.Unlock()
+		return
+	}
+
+	e.Process(nes)
+}
+
+// Processes the input events queued in deterministic mode.
+func (nes *NES) processQueued() {
+	nes.queueLock.Lock()
+	queued := nes.queued
+	nes.queued = nil
+	nes.queueLock.Unlock()
+
+	for _, e := range queued {
+		e.Process(nes)
+	}
+}
 
-		if nes.master {
-			nes.bridge.incoming <- pkt
-		} else {
-			nes.bridge.outgoing <- pkt
+// Counts the frame which just ended as a lag frame if the game did not
+// read the controllers during it.
+func (nes *NES) countLag() {
+	if nes.Lagged = nes.controllers.lagged(); nes.Lagged {
+		nes.LagFrames++
+	}
+}
+
+// Returns a hash of the CPU's 2KB of RAM, which unlike StateHash
+// depends only on the game and not on how the NES is emulated.
+func (nes *NES) RAMHash() uint64 {
+	h := fnv.New64a()
+
+	for address := uint16(0); address < 0x0800; address++ {
+		h.Write([]byte{nes.CPU.Memory.Fetch(address)})
+	}
+
+	return h.Sum64()
+}
+
+// Returns a hash of the state of the emulated hardware: the CPU and
+// APU, the PPU and the mapper.  Two runs given the same input from
+// power on have the same hash after every frame.
+func (nes *NES) StateHash() uint64 {
+	h := fnv.New64a()
+
+	json.NewEncoder(h).Encode(struct {
+		CPU      *rp2ago3.RP2A03
+		PPU      *rp2cgo2.RP2C02
+		PPUQuota float32
+		ROM      ROM
+		Turbo    *Turbo
+		Tick     uint64
+	}{nes.CPU, nes.PPU, nes.PPUQuota, nes.ROM, nes.Turbo, nes.Tick})
+
+	return h.Sum64()
+}
+
+// Runs the NES until the PPU finishes the current frame, whether or
+// not rendering is switched on.
+func (nes *NES) runFrame() (err error) {
+	frame := nes.PPU.Frame
+
+	for nes.PPU.Frame == frame {
+		if _, err = nes.step(); err != nil {
+			return
 		}
 	}
+
+	return
+}
+
+// Returns the TAS editor, nil unless the -tas option is given.
+func (nes *NES) TAS() *TASEditor {
+	return nes.tas
 }
 
 func (nes *NES) runProcessors() (err error) {
@@ -477,6 +835,9 @@ func (nes *NES) runProcessors() (err error) {
 
 func (nes *NES) step() (cycles uint16, err error) {
 	mmc3, _ := nes.ROM.(*MMC3)
+	runAhead := false
+
+	nes.frameEnded = false
 
 	if cycles, err = nes.CPU.Execute(); err != nil {
 		return 0, err
@@ -485,9 +846,43 @@ func (nes *NES) step() (cycles uint16, err error) {
 	nes.PPUQuota += float32(cycles) * nes.CPUDivisor
 
 	for nes.PPUQuota >= 1.0 {
-		if colors := nes.PPU.Execute(); colors != nil {
-			nes.frame(colors)
-			nes.fps.Delay()
+		if colors := nes.PPU.Execute(); colors != nil && nes.ahead > 0 {
+			nes.endFrameAhead(colors)
+		} else if colors != nil {
+			runAhead = nes.runningAhead()
+			nes.frames++
+
+			nes.processQueued()
+			nes.countLag()
+
+			if nes.timer != nil && !nes.quiet {
+				nes.timer.frame(nes.CPU.Memory.Fetch)
+			}
+
+			if nes.movie != nil {
+				nes.movie.endFrame(nes)
+			} else {
+				nes.controllers.Frame()
+			}
+
+			if !nes.quiet {
+				// the frame run ahead is shown in its place
+				if !runAhead {
+					nes.frame(colors)
+				}
+
+				if nes.audioSync != nil && nes.speed == 1 {
+					nes.audioSync.Delay()
+				} else {
+					nes.fps.Delay()
+				}
+			}
+
+			nes.frameEnded = true
+
+			if nes.options != nil && nes.options.Headless && nes.options.Frames > 0 && nes.frames >= uint64(nes.options.Frames) {
+				nes.state = Quitting
+			}
 		}
 
 		if mmc3 != nil && nes.PPU.TriggerScanlineCounter() {
@@ -498,16 +893,27 @@ func (nes *NES) step() (cycles uint16, err error) {
 	}
 
 	for i := uint16(0); i < cycles; i++ {
-		if sample, haveSample := nes.CPU.APU.Execute(); haveSample {
+		if sample, haveSample := nes.CPU.APU.Execute(); haveSample && !nes.quiet && nes.ahead == 0 {
 			nes.sample(sample)
 		}
 	}
 
+	nes.Tick += uint64(cycles)
+
+	if nes.frameEnded && nes.tas != nil {
+		nes.tas.capture()
+	}
+
+	if runAhead {
+		if err = nes.runFramesAhead(); err != nil {
+			return 0, err
+		}
+	}
+
 	return cycles, nil
 }
 
 func (nes *NES) runAsSlave() (err error) {
-	var cycles uint16
 	for nes.state != Quitting {
 		pkt := <-nes.bridge.incoming
 		if pkt.Ev.String() != "LoadStateEvent" {
@@ -516,8 +922,7 @@ func (nes *NES) runAsSlave() (err error) {
 			}
 			lock := <-nes.lock
 			for nes.Tick < pkt.Tick && err == nil {
-				cycles, err = nes.step()
-				nes.Tick += uint64(cycles)
+				_, err = nes.step()
 			}
 			if nes.Tick > pkt.Tick {
 				fmt.Fprintf(os.Stderr, "Failed to sync with master, quiting...\n")
@@ -526,7 +931,7 @@ func (nes *NES) runAsSlave() (err error) {
 			}
 			nes.lock <- lock
 		}
-		pkt.Ev.Process(nes)
+		nes.process(pkt.Ev)
 	}
 	return
 }
@@ -534,7 +939,7 @@ func (nes *NES) runAsSlave() (err error) {
 func (nes *NES) processPacket(pkt *Packet) {
 	lock := <-nes.lock
 	pkt.Tick = nes.Tick
-	pkt.Ev.Process(nes)
+	nes.process(pkt.Ev)
 	nes.lock <- lock
 	flag := pkt.Ev.Flag()
 	if nes.bridge.active && (flag&EvGlobal != 0) {
@@ -543,8 +948,6 @@ func (nes *NES) processPacket(pkt *Packet) {
 }
 
 func (nes *NES) runAsMaster() (err error) {
-	var cycles uint16
-
 	nes.state = Running
 
 	for nes.state != Quitting {
@@ -568,12 +971,16 @@ func (nes *NES) runAsMaster() (err error) {
 
 		if !nes.Paused {
 			lock := <-nes.lock
-			cycles, err = nes.step()
+			_, err = nes.step()
 			if err != nil {
 				return
 			}
 
-			nes.Tick += uint64(cycles)
+			if nes.stepping != NoStep && nes.stepped() {
+				nes.stepping = NoStep
+				nes.audio.TogglePaused()
+				nes.Paused = true
+			}
 
 			nes.lock <- lock
 		}
@@ -595,6 +1002,18 @@ func (nes *NES) frame(colors []uint8) {
 	colorsCpy := nes.framePool.Get().([]uint8)
 	copy(colorsCpy, colors)
 
+	if nes.movie != nil {
+		drawText(colorsCpy, 8, 16, nes.movie.String())
+	}
+
+	if nes.lagCounter {
+		drawText(colorsCpy, 8, 24, fmt.Sprintf("LAG %v", nes.LagFrames))
+	}
+
+	if nes.timer != nil {
+		drawText(colorsCpy, 8, 32, nes.timer.String())
+	}
+
 	e := &FrameEvent{
 		Colors: colorsCpy,
 	}
@@ -622,17 +1041,27 @@ func (nes *NES) Run() (err error) {
 	}
 	nes.Reset()
 
+	if nes.movie != nil {
+		nes.movie.start(nes)
+	}
+
+	if nes.tas != nil {
+		nes.tas.start()
+	}
+
 	nes.state = Running
 
 	go nes.audio.Run()
 	go nes.processEvents()
 
-	go func() {
-		if err := nes.runProcessors(); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-	}()
+	if !nes.options.Headless {
+		go func() {
+			if err := nes.runProcessors(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	if nes.recorder != nil {
 		go nes.recorder.Run()
@@ -662,7 +1091,35 @@ func (nes *NES) Run() (err error) {
 		defer pprof.StopCPUProfile()
 	}
 
-	nes.video.Run()
+	if nes.options.Headless {
+		start, frame := time.Now(), nes.frames
+
+		if err = nes.runProcessors(); err != nil {
+			return
+		}
+
+		frames := float64(nes.frames - frame)
+		elapsed := time.Since(start)
+
+		fmt.Printf("*** Ran %v frames in %v, %.1f FPS, %.0f%% of full speed\n", frames, elapsed,
+			frames/elapsed.Seconds(), 100*frames/elapsed.Seconds()/nes.DefaultFPS)
+
+		fmt.Printf("*** State hash %016x\n", nes.StateHash())
+	} else {
+		nes.video.Run()
+	}
+
+	if nes.movie != nil {
+		if err := nes.movie.Save(); err != nil {
+			fmt.Println("*** Error saving movie:", err)
+		}
+	}
+
+	if nes.timer != nil {
+		if err := nes.timer.Close(); err != nil {
+			fmt.Println("*** Error writing splits:", err)
+		}
+	}
 
 	if nes.recorder != nil {
 		nes.recorder.Quit()
@@ -1,6 +1,8 @@
 package nes
 
 import (
+	"bytes"
+	"math"
 	"testing"
 
 	"github.com/nwidger/nintengo/rp2cgo2"
@@ -195,3 +197,223 @@ func TestHorizontalMirroring(t *testing.T) {
 		ppu.Memory.Store(i, 0x00)
 	}
 }
+
+// Returns an NROM image whose NMI handler reads controller 1 and adds
+// its buttons into $01, counting frames in $02.
+func controllerTestROM() []byte {
+	buf := make([]byte, 16+0x4000+0x2000)
+
+	copy(buf, []byte{0x4e, 0x45, 0x53, 0x1a, 0x01, 0x01})
+
+	prg := buf[16 : 16+0x4000]
+
+	copy(prg, []byte{
+		// reset: enable NMI and rendering, which frames are only
+		// produced with, and loop
+		0x78, 0xd8, 0xa2, 0xff, 0x9a, 0xa9, 0x80, 0x8d, 0x00, 0x20,
+		0xa9, 0x18, 0x8d, 0x01, 0x20, 0x4c, 0x0f, 0x80,
+		// nmi: strobe $4016 and shift 8 buttons into $00
+		0xa9, 0x01, 0x8d, 0x16, 0x40, 0xa9, 0x00, 0x8d, 0x16, 0x40,
+		0xa2, 0x08,
+		0xad, 0x16, 0x40, 0x4a, 0x26, 0x00, 0xca, 0xd0, 0xf7,
+		// $01 += $00, $02++
+		0xa5, 0x00, 0x18, 0x65, 0x01, 0x85, 0x01, 0xe6, 0x02,
+		0x40,
+	})
+
+	// NMI, reset and IRQ vectors
+	copy(prg[0x3ffa:], []byte{0x12, 0x80, 0x00, 0x80, 0x12, 0x80})
+
+	return buf
+}
+
+func newTestNES(t *testing.T, options *Options) *NES {
+	options.Headless = true
+
+	// tests run the frames they need themselves
+	if options.Frames == 0 {
+		options.Frames = math.MaxInt32
+	}
+
+	nes, err := NewNESFromReader("test.nes", bytes.NewReader(controllerTestROM()), options)
+
+	if err != nil {
+		t.Fatalf("Error creating NES: %v", err)
+	}
+
+	nes.Reset()
+	nes.state = Running
+
+	return nes
+}
+
+func testMovie(frames int) *Movie {
+	m := &Movie{Ports: [3]int{FM2Gamepad, FM2Gamepad, FM2None}}
+
+	for i := 0; i < frames; i++ {
+		var frame MovieFrame
+
+		if i%7 > 2 {
+			frame.Buttons[0] = 1<<A | uint8(i)<<4
+		}
+
+		m.Frames = append(m.Frames, frame)
+	}
+
+	return m
+}
+
+// Plays m on a new NES and returns the state hash after every frame.
+func playTestMovie(t *testing.T, m *Movie) (hashes []uint64) {
+	nes := newTestNES(t, &Options{Region: "NTSC", Deterministic: true})
+
+	nes.movie = &MovieSession{Movie: m, mode: moviePlaying, ReadOnly: true}
+	nes.movie.start(nes)
+
+	for i := 0; i < len(m.Frames)-1; i++ {
+		// input arriving mid-frame waits for the end of the frame
+		// and is then ignored by the read-only movie
+		nes.process(&ControllerEvent{Controller: 0, Button: B, Down: i%2 == 0})
+
+		if err := nes.runFrame(); err != nil {
+			t.Fatalf("Error running frame %v: %v", i, err)
+		}
+
+		hashes = append(hashes, nes.StateHash())
+	}
+
+	if nes.CPU.Memory.Fetch(0x0002) == 0 {
+		t.Error("NMI handler did not run")
+	}
+
+	return
+}
+
+func TestStateHash(t *testing.T) {
+	m := testMovie(60)
+
+	first, second := playTestMovie(t, m), playTestMovie(t, m)
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("State hash differs after frame %v: %016x and %016x", i, first[i], second[i])
+		}
+	}
+
+	other := playTestMovie(t, testMovie(0))
+
+	if len(other) != 0 {
+		t.Fatalf("Played %v frames of an empty movie", len(other))
+	}
+
+	m.Frames[30].Buttons[0] ^= 1 << Select
+	changed := playTestMovie(t, m)
+
+	if changed[20] != first[20] || changed[40] == first[40] {
+		t.Error("State hash does not follow input")
+	}
+}
+
+func TestHeadlessFrames(t *testing.T) {
+	options := &Options{Region: "NTSC", Headless: true}
+
+	if _, err := NewNESFromReader("test.nes", bytes.NewReader(controllerTestROM()), options); err == nil {
+		t.Error("No error running headless without a movie or a number of frames")
+	}
+
+	nes := newTestNES(t, &Options{Region: "NTSC", Frames: 3})
+
+	for i := 0; i < 3; i++ {
+		if nes.state != Running {
+			t.Fatalf("Quit after %v frames not 3", i)
+		}
+
+		if err := nes.runFrame(); err != nil {
+			t.Fatalf("Error running frame: %v", err)
+		}
+	}
+
+	if nes.state != Quitting {
+		t.Error("Did not quit after 3 frames")
+	}
+}
+
+func TestLagFrames(t *testing.T) {
+	nes := newTestNES(t, &Options{Region: "NTSC"})
+
+	nes.movie = &MovieSession{
+		Movie: &Movie{Ports: [3]int{FM2Gamepad, FM2Gamepad, FM2None}},
+		mode:  movieRecording,
+	}
+
+	nes.movie.start(nes)
+
+	run := func(frames int) {
+		for i := 0; i < frames; i++ {
+			if err := nes.runFrame(); err != nil {
+				t.Fatalf("Error running frame: %v", err)
+			}
+		}
+	}
+
+	// the NMI handler reads the controllers every frame after the
+	// first
+	run(10)
+	lag := nes.LagFrames
+
+	if lag > 1 || nes.Lagged {
+		t.Fatalf("Counted %v lag frames while the controllers were read", lag)
+	}
+
+	// without the NMI the controllers are never read
+	nes.CPU.Memory.Store(0x2000, 0x00)
+	run(5)
+
+	if nes.LagFrames != lag+5 || !nes.Lagged {
+		t.Errorf("Counted %v lag frames, not %v", nes.LagFrames, lag+5)
+	}
+
+	m := nes.movie.Movie
+
+	if m.LagFrames() != nes.LagFrames || !m.IsLag(14) || m.IsLag(9) {
+		t.Errorf("Movie recorded lag frames %v", m.Lag)
+	}
+
+	var buf bytes.Buffer
+
+	if err := m.WriteFM2(&buf); err != nil {
+		t.Fatalf("Error writing movie: %v", err)
+	}
+
+	m2, err := ReadFM2(&buf)
+
+	if err != nil {
+		t.Fatalf("Error reading written movie: %v", err)
+	}
+
+	for frame := range m.Frames {
+		if m2.IsLag(frame) != m.IsLag(frame) {
+			t.Errorf("Frame %v read back with lag %v", frame, m2.IsLag(frame))
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	nes := newTestNES(t, &Options{Region: "NTSC"})
+	nes.master = true
+	nes.Paused = true
+
+	nes.Step(FrameStep)
+
+	if nes.Paused || nes.stepping != FrameStep {
+		t.Errorf("Stepping is %v with Paused %v", nes.stepping, nes.Paused)
+	}
+
+	// the lock is given back
+	select {
+	case lock := <-nes.lock:
+		nes.lock <- lock
+	default:
+		t.Error("Step kept the lock")
+	}
+}
@@ -0,0 +1,95 @@
+package nes
+
+import "strings"
+
+// The palette entries text is drawn with over the picture.
+const (
+	osdForeground uint8 = 0x30
+	osdBackground uint8 = 0x0f
+)
+
+// A 3x5 pixel font, each glyph given as five rows of three bits.
+var osdFont = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 3, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 2, 2},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'A': {2, 5, 7, 5, 5},
+	'B': {6, 5, 6, 5, 6},
+	'C': {3, 4, 4, 4, 3},
+	'D': {6, 5, 5, 5, 6},
+	'E': {7, 4, 6, 4, 7},
+	'F': {7, 4, 6, 4, 4},
+	'G': {3, 4, 5, 5, 3},
+	'H': {5, 5, 7, 5, 5},
+	'I': {7, 2, 2, 2, 7},
+	'J': {1, 1, 1, 5, 2},
+	'K': {5, 5, 6, 5, 5},
+	'L': {4, 4, 4, 4, 7},
+	'M': {5, 7, 7, 5, 5},
+	'N': {6, 5, 5, 5, 5},
+	'O': {2, 5, 5, 5, 2},
+	'P': {6, 5, 6, 4, 4},
+	'Q': {2, 5, 5, 6, 3},
+	'R': {6, 5, 6, 5, 5},
+	'S': {3, 4, 2, 1, 6},
+	'T': {7, 2, 2, 2, 2},
+	'U': {5, 5, 5, 5, 7},
+	'V': {5, 5, 5, 5, 2},
+	'W': {5, 5, 7, 7, 5},
+	'X': {5, 5, 2, 5, 5},
+	'Y': {5, 5, 2, 2, 2},
+	'Z': {7, 1, 2, 4, 7},
+	'/': {1, 1, 2, 4, 4},
+	':': {0, 2, 0, 2, 0},
+	'.': {0, 0, 0, 0, 2},
+	'-': {0, 0, 7, 0, 0},
+	' ': {0, 0, 0, 0, 0},
+}
+
+// Draws text into a frame of palette entries, 256 pixels wide, with
+// its top left corner at x, y and a one pixel border around it.
+// Lowercase letters are drawn as uppercase and characters the font
+// lacks as spaces.
+func drawText(colors []uint8, x, y int, text string) {
+	if text == "" {
+		return
+	}
+
+	text = strings.ToUpper(text)
+	width := 4*len(text) + 1
+
+	for row := -1; row < 6; row++ {
+		for col := -1; col < width; col++ {
+			px, py := x+col, y+row
+
+			if px < 0 || px >= 256 || py < 0 || (py<<8)+px >= len(colors) {
+				continue
+			}
+
+			colors[(py<<8)+px] = osdBackground
+		}
+	}
+
+	for i, c := range text {
+		glyph := osdFont[c]
+
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				px, py := x+4*i+col, y+row
+
+				if bits&(4>>uint(col)) == 0 || px < 0 || px >= 256 || py < 0 || (py<<8)+px >= len(colors) {
+					continue
+				}
+
+				colors[(py<<8)+px] = osdForeground
+			}
+		}
+	}
+}
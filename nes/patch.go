@@ -0,0 +1,421 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+var patchSuffixes = []string{".ips", ".IPS", ".ups", ".UPS", ".bps", ".BPS"}
+
+// Returns the name of a patch file sitting next to the ROM for the
+// given game and sharing its name, e.g. 'Game.ips' for 'Game.nes', or
+// the empty string if there is none.
+func findPatch(gamename string) string {
+	for _, suffix := range patchSuffixes {
+		if _, err := os.Stat(gamename + suffix); err == nil {
+			return gamename + suffix
+		}
+	}
+
+	return ""
+}
+
+// Applies the patch named patchname to buf, or the same-named patch
+// found by findPatch if patchname is empty.  buf is returned unchanged
+// if there is no patch to apply.
+func patchROM(buf []byte, gamename, patchname string) (patched []byte, err error) {
+	var patch []byte
+
+	patched = buf
+
+	if patchname == "" {
+		if patchname = findPatch(gamename); patchname == "" {
+			return
+		}
+	}
+
+	if patch, err = ioutil.ReadFile(patchname); err != nil {
+		return
+	}
+
+	fmt.Println("*** Applying patch", patchname)
+
+	if patched, err = ApplyPatch(buf, patch); err != nil {
+		err = fmt.Errorf("%v: %v", patchname, err)
+	}
+
+	return
+}
+
+// Applies an IPS, UPS or BPS patch to rom, detecting the patch format
+// from its header.
+func ApplyPatch(rom, patch []byte) (patched []byte, err error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte("PATCH")):
+		patched, err = ApplyIPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("UPS1")):
+		patched, err = ApplyUPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte("BPS1")):
+		patched, err = ApplyBPS(rom, patch)
+	default:
+		err = errors.New("Unknown patch format, must be IPS, UPS or BPS")
+	}
+
+	return
+}
+
+func ApplyIPS(rom, patch []byte) (patched []byte, err error) {
+	if !bytes.HasPrefix(patch, []byte("PATCH")) {
+		err = errors.New("Invalid IPS patch: Missing 'PATCH' header")
+		return
+	}
+
+	patched = make([]byte, len(rom))
+	copy(patched, rom)
+
+	i := 5
+
+	for {
+		if i+3 > len(patch) {
+			patched = nil
+			err = errors.New("Invalid IPS patch: Missing 'EOF' marker")
+			return
+		}
+
+		if string(patch[i:i+3]) == "EOF" {
+			i += 3
+			break
+		}
+
+		if i+5 > len(patch) {
+			patched = nil
+			err = errors.New("Invalid IPS patch: EOF in record header")
+			return
+		}
+
+		offset := int(patch[i])<<16 | int(patch[i+1])<<8 | int(patch[i+2])
+		size := int(patch[i+3])<<8 | int(patch[i+4])
+		i += 5
+
+		var data []byte
+
+		if size == 0 {
+			// RLE record
+			if i+3 > len(patch) {
+				patched = nil
+				err = errors.New("Invalid IPS patch: EOF in RLE record")
+				return
+			}
+
+			size = int(patch[i])<<8 | int(patch[i+1])
+			data = bytes.Repeat([]byte{patch[i+2]}, size)
+			i += 3
+		} else {
+			if i+size > len(patch) {
+				patched = nil
+				err = errors.New("Invalid IPS patch: EOF in record data")
+				return
+			}
+
+			data = patch[i : i+size]
+			i += size
+		}
+
+		if offset+size > len(patched) {
+			grown := make([]byte, offset+size)
+			copy(grown, patched)
+			patched = grown
+		}
+
+		copy(patched[offset:], data)
+	}
+
+	// optional truncation extension
+	if i+3 <= len(patch) {
+		if size := int(patch[i])<<16 | int(patch[i+1])<<8 | int(patch[i+2]); size < len(patched) {
+			patched = patched[:size]
+		}
+	}
+
+	return
+}
+
+// The largest ROM a UPS or BPS patch may produce, far larger than any
+// NES ROM.
+const maxPatchedSize = 64 * 1024 * 1024
+
+// Decodes one of the variable-length integers used by the UPS and BPS
+// formats.
+func decodePatchNumber(patch []byte, i *int) (value int, err error) {
+	shift := 1
+
+	for {
+		if *i < 0 || *i >= len(patch) {
+			err = errors.New("EOF in encoded number")
+			return
+		}
+
+		x := int(patch[*i])
+		*i++
+
+		value += (x & 0x7f) * shift
+
+		if x&0x80 != 0 {
+			break
+		}
+
+		if shift <<= 7; shift > 1<<56 {
+			err = errors.New("Encoded number too large")
+			return
+		}
+
+		value += shift
+	}
+
+	if value < 0 {
+		err = errors.New("Encoded number too large")
+	}
+
+	return
+}
+
+// Checks the source, target and patch checksums in the 12-byte footer
+// shared by the UPS and BPS formats.  Returns the footer-less body of
+// the patch.
+func checkPatchFooter(format string, rom, patch []byte) (body []byte, targetCRC uint32, err error) {
+	if len(patch) < 4+12 {
+		err = fmt.Errorf("Invalid %v patch: Missing checksums", format)
+		return
+	}
+
+	body = patch[:len(patch)-12]
+	footer := patch[len(patch)-12:]
+
+	sourceCRC := binary.LittleEndian.Uint32(footer[0:4])
+	targetCRC = binary.LittleEndian.Uint32(footer[4:8])
+	patchCRC := binary.LittleEndian.Uint32(footer[8:12])
+
+	if crc := crc32.ChecksumIEEE(patch[:len(patch)-4]); crc != patchCRC {
+		err = fmt.Errorf("Invalid %v patch: Patch checksum is %08X, expected %08X", format, crc, patchCRC)
+		return
+	}
+
+	if crc := crc32.ChecksumIEEE(rom); crc != sourceCRC {
+		err = fmt.Errorf("%v patch does not apply to this ROM: Source checksum is %08X, expected %08X", format, crc, sourceCRC)
+		return
+	}
+
+	return
+}
+
+func ApplyUPS(rom, patch []byte) (patched []byte, err error) {
+	var sourceSize, targetSize, offset int
+
+	if !bytes.HasPrefix(patch, []byte("UPS1")) {
+		err = errors.New("Invalid UPS patch: Missing 'UPS1' header")
+		return
+	}
+
+	body, targetCRC, err := checkPatchFooter("UPS", rom, patch)
+
+	if err != nil {
+		return
+	}
+
+	i := 4
+
+	if sourceSize, err = decodePatchNumber(body, &i); err != nil {
+		err = fmt.Errorf("Invalid UPS patch: %v", err)
+		return
+	}
+
+	if targetSize, err = decodePatchNumber(body, &i); err != nil {
+		err = fmt.Errorf("Invalid UPS patch: %v", err)
+		return
+	}
+
+	if sourceSize != len(rom) {
+		err = fmt.Errorf("UPS patch does not apply to this ROM: Source size is %v, expected %v", len(rom), sourceSize)
+		return
+	}
+
+	if targetSize > maxPatchedSize {
+		err = fmt.Errorf("Invalid UPS patch: Target size %v is too large", targetSize)
+		return
+	}
+
+	patched = make([]byte, targetSize)
+	copy(patched, rom)
+
+	for i < len(body) {
+		var skip int
+
+		if skip, err = decodePatchNumber(body, &i); err != nil {
+			patched = nil
+			err = fmt.Errorf("Invalid UPS patch: %v", err)
+			return
+		}
+
+		if skip > len(patched)-offset {
+			patched = nil
+			err = errors.New("Invalid UPS patch: Skip past end of target")
+			return
+		}
+
+		offset += skip
+
+		for ; i < len(body) && body[i] != 0; i++ {
+			if offset < len(patched) {
+				patched[offset] ^= body[i]
+			}
+
+			offset++
+		}
+
+		// skip terminating zero
+		i++
+		offset++
+	}
+
+	if crc := crc32.ChecksumIEEE(patched); crc != targetCRC {
+		patched = nil
+		err = fmt.Errorf("UPS patch failed: Target checksum is %08X, expected %08X", crc, targetCRC)
+		return
+	}
+
+	return
+}
+
+const (
+	bpsSourceRead uint8 = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+func ApplyBPS(rom, patch []byte) (patched []byte, err error) {
+	var sourceSize, targetSize, metadataSize int
+	var output, sourceOffset, targetOffset int
+
+	if !bytes.HasPrefix(patch, []byte("BPS1")) {
+		err = errors.New("Invalid BPS patch: Missing 'BPS1' header")
+		return
+	}
+
+	body, targetCRC, err := checkPatchFooter("BPS", rom, patch)
+
+	if err != nil {
+		return
+	}
+
+	i := 4
+
+	for _, n := range []*int{&sourceSize, &targetSize, &metadataSize} {
+		if *n, err = decodePatchNumber(body, &i); err != nil {
+			err = fmt.Errorf("Invalid BPS patch: %v", err)
+			return
+		}
+	}
+
+	if sourceSize != len(rom) {
+		err = fmt.Errorf("BPS patch does not apply to this ROM: Source size is %v, expected %v", len(rom), sourceSize)
+		return
+	}
+
+	if targetSize > maxPatchedSize {
+		err = fmt.Errorf("Invalid BPS patch: Target size %v is too large", targetSize)
+		return
+	}
+
+	if metadataSize > len(body)-i {
+		err = errors.New("Invalid BPS patch: EOF in metadata")
+		return
+	}
+
+	// metadata is ignored
+	i += metadataSize
+
+	patched = make([]byte, targetSize)
+
+	invalid := func(msg string) ([]byte, error) {
+		return nil, errors.New("Invalid BPS patch: " + msg)
+	}
+
+	for i < len(body) {
+		var data, relative int
+
+		if data, err = decodePatchNumber(body, &i); err != nil {
+			return invalid(err.Error())
+		}
+
+		command := uint8(data & 0x03)
+		length := (data >> 2) + 1
+
+		if length > len(patched)-output {
+			return invalid("Write past end of target")
+		}
+
+		switch command {
+		case bpsSourceRead:
+			if length > len(rom)-output {
+				return invalid("Read past end of source")
+			}
+
+			copy(patched[output:output+length], rom[output:output+length])
+			output += length
+		case bpsTargetRead:
+			if length > len(body)-i {
+				return invalid("EOF in target read")
+			}
+
+			copy(patched[output:output+length], body[i:i+length])
+			output += length
+			i += length
+		case bpsSourceCopy, bpsTargetCopy:
+			if relative, err = decodePatchNumber(body, &i); err != nil {
+				return invalid(err.Error())
+			}
+
+			if relative&0x01 != 0 {
+				relative = -(relative >> 1)
+			} else {
+				relative >>= 1
+			}
+
+			if command == bpsSourceCopy {
+				if sourceOffset += relative; sourceOffset < 0 || length > len(rom)-sourceOffset {
+					return invalid("Source copy out of range")
+				}
+
+				copy(patched[output:output+length], rom[sourceOffset:sourceOffset+length])
+				sourceOffset += length
+				output += length
+			} else {
+				if targetOffset += relative; targetOffset < 0 || targetOffset >= output {
+					return invalid("Target copy out of range")
+				}
+
+				// target copies may overlap the output, so
+				// copy byte by byte
+				for n := 0; n < length; n++ {
+					patched[output] = patched[targetOffset]
+					output++
+					targetOffset++
+				}
+			}
+		}
+	}
+
+	if crc := crc32.ChecksumIEEE(patched); crc != targetCRC {
+		patched = nil
+		err = fmt.Errorf("BPS patch failed: Target checksum is %08X, expected %08X", crc, targetCRC)
+		return
+	}
+
+	return
+}
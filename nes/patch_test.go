@@ -0,0 +1,207 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"testing"
+)
+
+func encodePatchNumber(n int) (buf []byte) {
+	for {
+		x := byte(n & 0x7f)
+
+		if n >>= 7; n == 0 {
+			buf = append(buf, 0x80|x)
+			break
+		}
+
+		buf = append(buf, x)
+		n--
+	}
+
+	return
+}
+
+func appendPatchFooter(patch, source, target []byte) []byte {
+	crcs := make([]byte, 8)
+	binary.LittleEndian.PutUint32(crcs[0:4], crc32.ChecksumIEEE(source))
+	binary.LittleEndian.PutUint32(crcs[4:8], crc32.ChecksumIEEE(target))
+	patch = append(patch, crcs...)
+
+	crc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(patch))
+
+	return append(patch, crc...)
+}
+
+func TestIPS(t *testing.T) {
+	rom := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}
+
+	patch := []byte("PATCH")
+	// 2 bytes at offset 1
+	patch = append(patch, 0x00, 0x00, 0x01, 0x00, 0x02, 0xaa, 0xbb)
+	// RLE of 3 0xcc bytes at offset 6, growing the ROM
+	patch = append(patch, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x03, 0xcc)
+	patch = append(patch, []byte("EOF")...)
+
+	patched, err := ApplyPatch(rom, patch)
+
+	if err != nil {
+		t.Fatalf("Error applying IPS patch: %v", err)
+	}
+
+	expected := []byte{0x00, 0xaa, 0xbb, 0x03, 0x04, 0x05, 0xcc, 0xcc, 0xcc}
+
+	if !bytes.Equal(patched, expected) {
+		t.Errorf("Patched ROM is % X not % X", patched, expected)
+	}
+
+	if rom[1] != 0x01 {
+		t.Error("IPS patch modified the original ROM")
+	}
+
+	// truncation extension
+	truncated, err := ApplyIPS(rom, append(append([]byte{}, patch...), 0x00, 0x00, 0x04))
+
+	if err != nil {
+		t.Fatalf("Error applying IPS patch: %v", err)
+	}
+
+	if !bytes.Equal(truncated, expected[:4]) {
+		t.Errorf("Patched ROM is % X not % X", truncated, expected[:4])
+	}
+
+	if _, err = ApplyIPS(rom, patch[:len(patch)-3]); err == nil {
+		t.Error("No error applying IPS patch missing EOF marker")
+	}
+}
+
+func TestUPS(t *testing.T) {
+	rom := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}
+	target := []byte{0x00, 0x01, 0xff, 0x03, 0x04, 0x05, 0x06, 0x70, 0x80}
+
+	patch := []byte("UPS1")
+	patch = append(patch, encodePatchNumber(len(rom))...)
+	patch = append(patch, encodePatchNumber(len(target))...)
+	// skip 2, xor 1 byte
+	patch = append(patch, encodePatchNumber(2)...)
+	patch = append(patch, 0x02^0xff, 0x00)
+	// skip 3 (offset 4 after terminator), xor 2 bytes
+	patch = append(patch, encodePatchNumber(3)...)
+	patch = append(patch, 0x07^0x70, 0x80, 0x00)
+	patch = appendPatchFooter(patch, rom, target)
+
+	patched, err := ApplyPatch(rom, patch)
+
+	if err != nil {
+		t.Fatalf("Error applying UPS patch: %v", err)
+	}
+
+	if !bytes.Equal(patched, target) {
+		t.Errorf("Patched ROM is % X not % X", patched, target)
+	}
+
+	rom[0] = 0xee
+
+	if _, err = ApplyUPS(rom, patch); err == nil {
+		t.Error("No error applying UPS patch to ROM with wrong source checksum")
+	}
+
+	rom[0] = 0x00
+	patch[len(patch)-1] ^= 0xff
+
+	if _, err = ApplyUPS(rom, patch); err == nil {
+		t.Error("No error applying UPS patch with wrong patch checksum")
+	}
+}
+
+func TestBPS(t *testing.T) {
+	rom := []byte("ABCDEFGH")
+	target := []byte("ABCDxyxyxyEF")
+
+	action := func(command, length int) []byte {
+		return encodePatchNumber(((length - 1) << 2) | command)
+	}
+
+	patch := []byte("BPS1")
+	patch = append(patch, encodePatchNumber(len(rom))...)
+	patch = append(patch, encodePatchNumber(len(target))...)
+	patch = append(patch, encodePatchNumber(0)...)
+	// ABCD
+	patch = append(patch, action(int(bpsSourceRead), 4)...)
+	// xy
+	patch = append(patch, action(int(bpsTargetRead), 2)...)
+	patch = append(patch, 'x', 'y')
+	// xyxy, overlapping target copy from offset 4
+	patch = append(patch, action(int(bpsTargetCopy), 4)...)
+	patch = append(patch, encodePatchNumber(4<<1)...)
+	// EF, source copy from offset 4
+	patch = append(patch, action(int(bpsSourceCopy), 2)...)
+	patch = append(patch, encodePatchNumber(4<<1)...)
+	patch = appendPatchFooter(patch, rom, target)
+
+	patched, err := ApplyPatch(rom, patch)
+
+	if err != nil {
+		t.Fatalf("Error applying BPS patch: %v", err)
+	}
+
+	if !bytes.Equal(patched, target) {
+		t.Errorf("Patched ROM is %q not %q", patched, target)
+	}
+
+	if _, err = ApplyBPS([]byte("ABCDEFGX"), patch); err == nil {
+		t.Error("No error applying BPS patch to ROM with wrong source checksum")
+	}
+}
+
+func TestMalformedPatches(t *testing.T) {
+	rom := []byte("ABCDEFGH")
+
+	header := func(format string, numbers ...int) []byte {
+		patch := append([]byte(format), encodePatchNumber(len(rom))...)
+
+		for _, n := range numbers {
+			patch = append(patch, encodePatchNumber(n)...)
+		}
+
+		return patch
+	}
+
+	for name, patch := range map[string][]byte{
+		"UPS number too large":    append([]byte("UPS1"), bytes.Repeat([]byte{0x7f}, 10)...),
+		"UPS target too large":    header("UPS1", 1<<40),
+		"UPS skip too large":      header("UPS1", 8, 1<<60),
+		"BPS number too large":    append(header("BPS1", 8, 0), bytes.Repeat([]byte{0x7f}, 10)...),
+		"BPS target too large":    header("BPS1", 1<<62, 0),
+		"BPS metadata too large":  header("BPS1", 8, 1<<60),
+		"BPS write too large":     header("BPS1", 8, 0, (1<<60)|int(bpsTargetRead)),
+		"BPS source copy too far": header("BPS1", 8, 0, int(bpsSourceCopy), 1<<60),
+		"BPS target copy too far": header("BPS1", 8, 0, int(bpsTargetCopy), 1<<60|1),
+	} {
+		if _, err := ApplyPatch(rom, appendPatchFooter(patch, rom, rom)); err == nil {
+			t.Errorf("No error applying patch with %v", name)
+		}
+	}
+
+	// random patches fail without panicking
+	r := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 10000; n++ {
+		format := []string{"UPS1", "BPS1"}[n%2]
+		patch := header(format)
+		body := make([]byte, r.Intn(32))
+
+		r.Read(body)
+
+		ApplyPatch(rom, appendPatchFooter(append(patch, body...), rom, rom))
+	}
+}
+
+func TestUnknownPatch(t *testing.T) {
+	if _, err := ApplyPatch([]byte{0x00}, []byte("NOTAPATCH")); err == nil {
+		t.Error("No error applying unknown patch format")
+	}
+}
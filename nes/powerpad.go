@@ -0,0 +1,115 @@
+package nes
+
+// The Power Pad exercise mat, plugged into a controller port.  Its
+// buttons are sent two at a time, one on bit 3 and one on bit 4 of
+// the port's register, in the order given by powerPadSerial.  Side B
+// has twelve buttons numbered 1-12 left to right and top to bottom.
+// Side A is the other side of the same mat and only has the eight
+// buttons which are not in the corners, seen mirrored left to right.
+type PowerPad struct {
+	sideA   bool
+	latch   uint8
+	low     uint8
+	high    uint8
+	buttons uint16
+}
+
+// The buttons sent on bit 3 and bit 4 of the first 8 reads, the last
+// 4 reads of bit 4 are always 1.
+var powerPadSerial = [2][]int{
+	{2, 1, 5, 9, 6, 10, 11, 7},
+	{4, 3, 12, 8},
+}
+
+func NewPowerPad(sideA bool) *PowerPad {
+	return &PowerPad{
+		sideA: sideA,
+	}
+}
+
+func (pp *PowerPad) Reset() {
+	pp.latch = 0
+	pp.low = 0
+	pp.high = 0
+	pp.buttons = 0
+}
+
+func (pp *PowerPad) load() {
+	pp.low, pp.high = 0, 0xf0
+
+	for i, button := range powerPadSerial[0] {
+		if pp.ButtonIsDown(button) {
+			pp.low |= 1 << uint(i)
+		}
+	}
+
+	for i, button := range powerPadSerial[1] {
+		if pp.ButtonIsDown(button) {
+			pp.high |= 1 << uint(i)
+		}
+	}
+}
+
+func (pp *PowerPad) save() func() {
+	saved := *pp
+	return func() { *pp = saved }
+}
+
+func (pp *PowerPad) Strobe(value uint8) {
+	pp.latch = value & 0x01
+
+	if pp.latch == 1 {
+		pp.load()
+	}
+}
+
+func (pp *PowerPad) Read(address uint16) (value uint8) {
+	if pp.latch == 1 {
+		pp.load()
+	}
+
+	value = (pp.low&0x01)<<3 | (pp.high&0x01)<<4
+
+	if pp.latch == 0 {
+		pp.low = pp.low>>1 | 0x80
+		pp.high = pp.high>>1 | 0x80
+	}
+
+	return
+}
+
+func (pp *PowerPad) KeyDown(btn Button) {}
+
+func (pp *PowerPad) KeyUp(btn Button) {}
+
+// Returns true if the given button (1-12) is being stepped on.
+func (pp *PowerPad) ButtonIsDown(button int) bool {
+	return pp.buttons&(1<<uint(button-1)) != 0
+}
+
+// Steps on or off the button at the given position (1-12) of the mat
+// as seen by the player, numbered left to right and top to bottom.
+func (pp *PowerPad) Press(position int, down bool) {
+	if position < 1 || position > 12 {
+		return
+	}
+
+	button := position
+
+	if pp.sideA {
+		row, column := (position-1)/4, (position-1)%4
+
+		// side A has no buttons in its corners
+		if row != 1 && (column == 0 || column == 3) {
+			return
+		}
+
+		button = row*4 + (3 - column) + 1
+	}
+
+	if down {
+		pp.buttons |= 1 << uint(button-1)
+	} else {
+		pp.buttons &^= 1 << uint(button-1)
+	}
+}
@@ -0,0 +1,87 @@
+package nes
+
+import "testing"
+
+func readPowerPad(ctrls *Controllers) (d3, d4 []uint8) {
+	ctrls.Store(0x4016, 1)
+	ctrls.Store(0x4016, 0)
+
+	for i := 0; i < 10; i++ {
+		value := ctrls.Fetch(0x4017)
+
+		d3 = append(d3, (value>>3)&0x01)
+		d4 = append(d4, (value>>4)&0x01)
+	}
+
+	return
+}
+
+func TestPowerPad(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Plug(Port2, "powerpad"); err != nil {
+		t.Fatalf("Error plugging in Power Pad: %v", err)
+	}
+
+	ctrls.PowerPad(1, true)
+	ctrls.PowerPad(7, true)
+	ctrls.PowerPad(12, true)
+
+	d3, d4 := readPowerPad(ctrls)
+
+	for i, expected := range [][2]uint8{
+		{0, 0}, // 2, 4
+		{1, 0}, // 1, 3
+		{0, 1}, // 5, 12
+		{0, 0}, // 9, 8
+		{0, 1}, // 6
+		{0, 1}, // 10
+		{0, 1}, // 11
+		{1, 1}, // 7
+		{1, 1},
+		{1, 1},
+	} {
+		if d3[i] != expected[0] || d4[i] != expected[1] {
+			t.Errorf("Read %v is %v, %v not %v, %v", i, d3[i], d4[i], expected[0], expected[1])
+		}
+	}
+
+	ctrls.PowerPad(1, false)
+
+	if pp := ctrls.Device(Port2).(*PowerPad); pp.ButtonIsDown(1) || !pp.ButtonIsDown(7) {
+		t.Error("Button 1 is still down or button 7 is not")
+	}
+}
+
+func TestPowerPadSideA(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Plug(Port2, "powerpad-a"); err != nil {
+		t.Fatalf("Error plugging in Power Pad: %v", err)
+	}
+
+	pp := ctrls.Device(Port2).(*PowerPad)
+
+	// corners are not buttons on side A
+	ctrls.PowerPad(1, true)
+	ctrls.PowerPad(12, true)
+
+	if pp.buttons != 0 {
+		t.Errorf("Buttons are %012b not 0", pp.buttons)
+	}
+
+	// side A is side B mirrored
+	for position, button := range map[int]int{2: 3, 5: 8, 6: 7, 11: 10} {
+		ctrls.PowerPad(position, true)
+
+		if !pp.ButtonIsDown(button) {
+			t.Errorf("Position %v does not press button %v", position, button)
+		}
+
+		ctrls.PowerPad(position, false)
+	}
+
+	if err := ctrls.Plug(ExpansionPort, "powerpad"); err == nil {
+		t.Error("No error plugging Power Pad into expansion port")
+	}
+}
@@ -5,11 +5,8 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"io"
+	"hash/crc32"
 	"io/ioutil"
-
-	"archive/zip"
-
 	"strings"
 
 	"github.com/nwidger/nintengo/rp2ago3"
@@ -57,13 +54,17 @@ type ROMFile struct {
 	Trainer     bool
 	FourScreen  bool
 	VSCart      bool
+	NES20       bool
 	Mapper      uint8
+	Submapper   uint8
 	RAMBanks    uint8
 	RegionFlag  Region
 	TrainerData []uint8
 	WRAMBanks   [][]uint8
 	ROMBanks    [][]uint8
 	VROMBanks   [][]uint8
+	CRC32       uint32
+	header      []uint8 // as read, for keeping the fields romf lacks
 	irq         func(state bool)
 	setTables   func(t0, t1, t2, t3 int)
 }
@@ -78,63 +79,14 @@ type ROM interface {
 	GetROMFile() *ROMFile
 }
 
-func getBuf(filename string) (buf []byte, suffix string, err error) {
-	var r *zip.ReadCloser
-	var rc io.ReadCloser
-
-	switch {
-	case strings.HasSuffix(filename, ".nes") || strings.HasSuffix(filename, ".NES"):
-		suffix = filename[len(filename)-len(".nes"):]
-		buf, err = ioutil.ReadFile(filename)
-	case strings.HasSuffix(filename, ".zip") || strings.HasSuffix(filename, ".ZIP"):
-		suffix = filename[len(filename)-len(".zip"):]
-
-		// Open a zip archive for reading.
-		r, err = zip.OpenReader(filename)
-
-		if err != nil {
-			return
-		}
-
-		defer r.Close()
-
-		// Iterate through the files in the archive,
-		// printing some of their contents.
-		for _, f := range r.File {
-			if !strings.HasSuffix(f.Name, ".nes") && !strings.HasSuffix(f.Name, ".NES") {
-				continue
-			}
-
-			rc, err = f.Open()
-
-			if err != nil {
-				return
-			}
-
-			buf, err = ioutil.ReadAll(rc)
-
-			if err != nil {
-				return
-			}
-
-			rc.Close()
-			break
-		}
-	default:
-		err = errors.New("Unknown filetype, must be .nes, .NES, .zip or .ZIP")
-	}
-
-	return
-}
-
 func NewROM(filename string, irq func(state bool), setTables func(t0, t1, t2, t3 int)) (rom ROM, err error) {
-	buf, suffix, err := getBuf(filename)
+	buf, gamename, err := ReadROMSource(filename)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return NewROMFromBuf(buf, filename, suffix, irq, setTables)
+	return NewROMFromBuf(buf, gamename, "", irq, setTables)
 }
 
 func NewROMFromBuf(buf []byte, filename, suffix string, irq func(state bool), setTables func(t0, t1, t2, t3 int)) (rom ROM, err error) {
@@ -185,13 +137,32 @@ func NewROMFile(buf []byte) (romf *ROMFile, err error) {
 		return
 	}
 
-	romf = &ROMFile{}
+	romf = &ROMFile{
+		header: append([]uint8{}, buf[:16]...),
+	}
 
 	i := 4
 
+	// NES 2.0 headers are identified by bits 2-3 of byte 7 being
+	// 0b10
+	romf.NES20 = buf[7]&0x0c == 0x08
+
+	// old dumping tools filled bytes 7-15 with garbage such as
+	// 'DiskDude!', ignore them if the padding at the end of the
+	// header is not zero
+	garbage := !romf.NES20 && (buf[12]|buf[13]|buf[14]|buf[15]) != 0
+
 	for ; i < 10; i++ {
 		byte := buf[i]
 
+		if garbage && i >= 7 {
+			if i == 8 {
+				romf.RAMBanks = 1
+			}
+
+			continue
+		}
+
 		switch i {
 		case 4:
 			romf.PRGBanks = uint16(byte)
@@ -223,18 +194,28 @@ func NewROMFile(buf []byte) (romf *ROMFile, err error) {
 			romf.Mapper |= byte & 0xf0
 
 		case 8:
+			if romf.NES20 {
+				romf.Submapper = byte >> 4
+				romf.RAMBanks = 1
+				break
+			}
+
 			romf.RAMBanks = byte
 
 			if romf.RAMBanks == 0 {
 				romf.RAMBanks = 1
 			}
 		case 9:
-			if byte&0x01 != 0 {
+			if !romf.NES20 && byte&0x01 != 0 {
 				romf.RegionFlag = PAL
 			}
 		}
 	}
 
+	if romf.NES20 && buf[12]&0x03 == 0x01 {
+		romf.RegionFlag = PAL
+	}
+
 	i += 6
 
 	if romf.Trainer {
@@ -250,6 +231,7 @@ func NewROMFile(buf []byte) (romf *ROMFile, err error) {
 		i += offset
 	}
 
+	data := i
 	offset = 1024 * 16
 
 	if len(buf) < (i + (offset * int(romf.PRGBanks))) {
@@ -280,6 +262,9 @@ func NewROMFile(buf []byte) (romf *ROMFile, err error) {
 		i += offset
 	}
 
+	// checksum of PRG and CHR data, as used by ROM databases
+	romf.CRC32 = crc32.ChecksumIEEE(buf[data:i])
+
 	offset = 1024 * 8
 
 	romf.WRAMBanks = make([][]uint8, romf.RAMBanks)
@@ -288,6 +273,8 @@ func NewROMFile(buf []byte) (romf *ROMFile, err error) {
 		romf.WRAMBanks[n] = make([]uint8, offset)
 	}
 
+	romf.loadTrainer()
+
 	return
 }
 
@@ -315,7 +302,112 @@ func (romf *ROMFile) String() string {
 		fmt.Sprintf("FourScreen: %v\n", romf.FourScreen) +
 		fmt.Sprintf("VS Cart: %v\n", romf.VSCart) +
 		fmt.Sprintf("RAM Banks: %v\n", romf.RAMBanks) +
-		fmt.Sprintf("Region: %v\n", romf.RegionFlag)
+		fmt.Sprintf("Region: %v\n", romf.RegionFlag) +
+		fmt.Sprintf("CRC32: %08X\n", romf.CRC32)
+}
+
+// Returns the 16-byte iNES header describing romf.  An NES 2.0 header
+// is returned if romf came from one, keeping the fields of the original
+// romf does not describe, or if romf has a submapper.
+func (romf *ROMFile) Header() (header []byte) {
+	header = []byte{
+		'N', 'E', 'S', 0x1a,
+		uint8(romf.PRGBanks), uint8(romf.CHRBanks), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	switch {
+	case romf.FourScreen || romf.Mirroring == rp2cgo2.FourScreen:
+		header[6] |= 0x08
+	case romf.Mirroring == rp2cgo2.Vertical:
+		header[6] |= 0x01
+	}
+
+	if romf.Battery {
+		header[6] |= 0x02
+	}
+
+	if romf.Trainer {
+		header[6] |= 0x04
+	}
+
+	header[6] |= (romf.Mapper & 0x0f) << 4
+
+	if romf.VSCart {
+		header[7] |= 0x01
+	}
+
+	header[7] |= romf.Mapper & 0xf0
+
+	if romf.NES20 && len(romf.header) == 16 {
+		// the sizes of PRG-RAM and CHR-RAM, the upper bits of the
+		// mapper and ROM sizes, the console type and the rest are
+		// kept as they were
+		copy(header[8:], romf.header[8:])
+
+		header[7] &^= 0x03
+
+		switch console := romf.header[7] & 0x03; {
+		case romf.VSCart && console == 0x00:
+			header[7] |= 0x01
+		case !romf.VSCart && console == 0x01:
+		default:
+			header[7] |= console
+		}
+
+		header[7] |= 0x08
+		header[8] = header[8]&0x0f | romf.Submapper<<4
+
+		// PRG-RAM is battery-backed or not as the battery says
+		switch {
+		case romf.Battery && header[10]&0xf0 == 0:
+			header[10] = header[10] << 4
+		case !romf.Battery && header[10]&0x0f == 0:
+			header[10] = header[10] >> 4
+		}
+
+		switch {
+		case romf.RegionFlag == PAL:
+			header[12] = header[12]&^0x03 | 0x01
+		case header[12]&0x03 == 0x01:
+			header[12] &^= 0x03
+		}
+	} else if romf.Submapper != 0 {
+		header[7] |= 0x08
+		header[8] = romf.Submapper << 4
+
+		// 8KB of PRG-RAM, battery-backed or not
+		if romf.Battery {
+			header[10] = 0x70
+		} else {
+			header[10] = 0x07
+		}
+
+		if romf.RegionFlag == PAL {
+			header[12] = 0x01
+		}
+	} else {
+		header[8] = romf.RAMBanks
+
+		if romf.RegionFlag == PAL {
+			header[9] = 0x01
+		}
+	}
+
+	return
+}
+
+// Copies the 512-byte trainer, if any, into PRG-RAM at $7000-$71FF,
+// where mappers which map PRG-RAM at $6000-$7FFF find it.  This is done
+// once, when the ROM is loaded and again after the battery is, as a
+// copier does at power on, so a soft reset leaves PRG-RAM alone.
+func (romf *ROMFile) loadTrainer() {
+	if !romf.Trainer || len(romf.WRAMBanks) == 0 {
+		return
+	}
+
+	copy(romf.WRAMBanks[0][0x1000:0x1200], romf.TrainerData)
 }
 
 func (romf *ROMFile) GameName() string {
@@ -344,6 +436,8 @@ func (romf *ROMFile) LoadBattery() {
 		}
 	}
 
+	romf.loadTrainer()
+
 	return
 }
 
@@ -3,6 +3,8 @@ package nes
 import (
 	"testing"
 
+	"github.com/nwidger/nintengo/m65go2"
+	"github.com/nwidger/nintengo/rp2ago3"
 	"github.com/nwidger/nintengo/rp2cgo2"
 )
 
@@ -632,3 +634,61 @@ func TestRegion(t *testing.T) {
 	}
 
 }
+
+func TestTrainerMapping(t *testing.T) {
+	header := []byte{
+		0x4e, 0x45, 0x53, 0x1a,
+		0x02, 0x01, 0x14, 0x00,
+		0x01, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+
+	trainer := make([]byte, 512)
+
+	// LDA #$42; STA $10; JMP $7004
+	copy(trainer, []byte{0xa9, 0x42, 0x85, 0x10, 0x4c, 0x04, 0x70})
+
+	prg := make([]byte, 2*16*1024)
+
+	// reset vector points into the trainer
+	prg[len(prg)-4] = 0x00
+	prg[len(prg)-3] = 0x70
+
+	buf := append(append(append(header, trainer...), prg...), make([]byte, 8*1024)...)
+
+	cpu := rp2ago3.NewRP2A03(44100)
+	rom, err := NewROMFromBuf(buf, "trainer", "", cpu.InterruptLine(m65go2.Irq), func(t0, t1, t2, t3 int) {})
+
+	if err != nil {
+		t.Fatalf("Error loading Rom with trainer: %v\n", err)
+	}
+
+	cpu.Memory.AddMappings(rom, rp2ago3.CPU)
+
+	cpu.Reset()
+
+	for address := uint16(0x7000); address <= 0x71ff; address++ {
+		if value := cpu.Memory.Fetch(address); value != trainer[address-0x7000] {
+			t.Fatalf("Memory at $%04X is $%02X not $%02X", address, value, trainer[address-0x7000])
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := cpu.Execute(); err != nil {
+			t.Fatalf("Error executing trainer: %v", err)
+		}
+	}
+
+	if value := cpu.Memory.Fetch(0x0010); value != 0x42 {
+		t.Errorf("Memory at $0010 is $%02X not $42", value)
+	}
+
+	// the trainer is not copied again on a soft reset
+	cpu.Memory.Store(0x7000, 0xff)
+	rom.Reset()
+	cpu.Reset()
+
+	if value := cpu.Memory.Fetch(0x7000); value != 0xff {
+		t.Errorf("Memory at $7000 is $%02X not $FF after reset", value)
+	}
+}
@@ -0,0 +1,290 @@
+package nes
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+)
+
+var archiveSuffixes = []string{".zip", ".7z", ".tar", ".tar.gz", ".tgz", ".gz"}
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	sevenZipMagic = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+	tarMagic      = []byte("ustar")
+)
+
+// A ROM source is either the path to a ROM file, the path to an
+// archive holding a single ROM, or the path to an archive followed by
+// a colon and the name of one of the ROMs inside it, e.g.
+// 'Games.zip:Game (U).nes'.
+func splitROMSource(source string) (filename, member string) {
+	filename = source
+
+	for i := 0; i < len(source); i++ {
+		if source[i] != ':' {
+			continue
+		}
+
+		if hasSuffixFold(source[:i], archiveSuffixes...) {
+			filename, member = source[:i], source[i+1:]
+			break
+		}
+	}
+
+	return
+}
+
+// Returns the path of the file a ROM source is read from, the archive
+// for a ROM inside one.
+func ROMSourceFile(source string) string {
+	filename, _ := splitROMSource(source)
+	return filename
+}
+
+func hasSuffixFold(s string, suffixes ...string) bool {
+	s = strings.ToLower(s)
+
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Strips the ROM and archive suffixes from name, e.g. 'Game.nes.gz'
+// becomes 'Game'.
+func trimROMSuffix(name string) string {
+	for _, suffix := range append([]string{".nes"}, archiveSuffixes...) {
+		if hasSuffixFold(name, suffix) {
+			name = name[:len(name)-len(suffix)]
+			return trimROMSuffix(name)
+		}
+	}
+
+	return name
+}
+
+// Reads the ROM named by source from disk, extracting it from an
+// archive if necessary.  Returns the ROM and the game name used for
+// battery and save state files.
+func ReadROMSource(source string) (buf []byte, gamename string, err error) {
+	filename, _ := splitROMSource(source)
+
+	if buf, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+
+	return extractROM(buf, source)
+}
+
+type archiveMember struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// Extracts the ROM named by source from buf, which holds the contents
+// of the file named by source.  The archive format is detected from
+// the contents of buf rather than the file name.  If buf is not an
+// archive it is returned as-is.
+func extractROM(buf []byte, source string) (rom []byte, gamename string, err error) {
+	var members []archiveMember
+
+	filename, member := splitROMSource(source)
+	gamename = trimROMSuffix(filename)
+
+	switch {
+	case bytes.HasPrefix(buf, gzipMagic):
+		var zr *gzip.Reader
+
+		if zr, err = gzip.NewReader(bytes.NewReader(buf)); err != nil {
+			return
+		}
+
+		if buf, err = ioutil.ReadAll(zr); err != nil {
+			return
+		}
+
+		if !isTar(buf) {
+			if member != "" {
+				err = fmt.Errorf("%v is not an archive of multiple ROMs", filename)
+				return
+			}
+
+			rom = buf
+			return
+		}
+
+		members, err = tarMembers(buf)
+	case isTar(buf):
+		members, err = tarMembers(buf)
+	case bytes.HasPrefix(buf, zipMagic):
+		members, err = zipMembers(buf)
+	case bytes.HasPrefix(buf, sevenZipMagic):
+		members, err = sevenZipMembers(buf)
+	default:
+		if member != "" {
+			err = fmt.Errorf("%v is not an archive", filename)
+			return
+		}
+
+		rom = buf
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	m, err := selectMember(filename, member, members)
+
+	if err != nil {
+		return
+	}
+
+	rc, err := m.open()
+
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+
+	if rom, err = ioutil.ReadAll(rc); err != nil {
+		return
+	}
+
+	if member != "" {
+		gamename = filepath.Join(filepath.Dir(filename), trimROMSuffix(path.Base(m.name)))
+	}
+
+	return
+}
+
+// Picks the ROM to load from an archive: the one named by member if
+// given, otherwise the only .nes file in the archive.
+func selectMember(filename, member string, members []archiveMember) (m archiveMember, err error) {
+	roms := []archiveMember{}
+
+	for _, m := range members {
+		if member != "" && (m.name == member || path.Base(m.name) == member) {
+			return m, nil
+		}
+
+		if hasSuffixFold(m.name, ".nes") {
+			roms = append(roms, m)
+		}
+	}
+
+	if member != "" {
+		err = fmt.Errorf("%v not found in %v", member, filename)
+		return
+	}
+
+	switch len(roms) {
+	case 0:
+		err = fmt.Errorf("No .nes file found in %v", filename)
+	case 1:
+		m = roms[0]
+	default:
+		names := []string{}
+
+		for _, rom := range roms {
+			names = append(names, rom.name)
+		}
+
+		sort.Strings(names)
+
+		err = fmt.Errorf("%v contains multiple ROMs, choose one with '%v:<rom>':\n  %v",
+			filename, filename, strings.Join(names, "\n  "))
+	}
+
+	return
+}
+
+func isTar(buf []byte) bool {
+	return len(buf) >= 262 && bytes.Equal(buf[257:262], tarMagic)
+}
+
+func tarMembers(buf []byte) (members []archiveMember, err error) {
+	var hdr *tar.Header
+
+	tr := tar.NewReader(bytes.NewReader(buf))
+
+	for {
+		if hdr, err = tr.Next(); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		var data []byte
+
+		if data, err = ioutil.ReadAll(tr); err != nil {
+			return
+		}
+
+		members = append(members, archiveMember{
+			name: hdr.Name,
+			open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(data)), nil
+			},
+		})
+	}
+
+	return
+}
+
+func zipMembers(buf []byte) (members []archiveMember, err error) {
+	var zr *zip.Reader
+
+	if zr, err = zip.NewReader(bytes.NewReader(buf), int64(len(buf))); err != nil {
+		return
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		members = append(members, archiveMember{name: f.Name, open: f.Open})
+	}
+
+	return
+}
+
+func sevenZipMembers(buf []byte) (members []archiveMember, err error) {
+	var sr *sevenzip.Reader
+
+	if sr, err = sevenzip.NewReader(bytes.NewReader(buf), int64(len(buf))); err != nil {
+		return
+	}
+
+	for _, f := range sr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		members = append(members, archiveMember{name: f.Name, open: f.Open})
+	}
+
+	return
+}
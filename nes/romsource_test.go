@@ -0,0 +1,172 @@
+package nes
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestSplitROMSource(t *testing.T) {
+	for _, test := range []struct {
+		source, filename, member string
+	}{
+		{"Game.nes", "Game.nes", ""},
+		{"Games.zip", "Games.zip", ""},
+		{"Games.zip:Game (U).nes", "Games.zip", "Game (U).nes"},
+		{"C:\\roms\\Games.ZIP:Game (U).nes", "C:\\roms\\Games.ZIP", "Game (U).nes"},
+		{"C:\\roms\\Game.nes", "C:\\roms\\Game.nes", ""},
+		{"roms/Games.tar.gz:dir/Game.nes", "roms/Games.tar.gz", "dir/Game.nes"},
+	} {
+		filename, member := splitROMSource(test.source)
+
+		if filename != test.filename || member != test.member {
+			t.Errorf("splitROMSource(%q) is %q, %q not %q, %q",
+				test.source, filename, member, test.filename, test.member)
+		}
+
+		if filename = ROMSourceFile(test.source); filename != test.filename {
+			t.Errorf("ROMSourceFile(%q) is %q not %q", test.source, filename, test.filename)
+		}
+	}
+}
+
+func TestTrimROMSuffix(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Game.nes":         "Game",
+		"Game.NES":         "Game",
+		"Game.zip":         "Game",
+		"Game.nes.gz":      "Game",
+		"Games.tar.gz":     "Games",
+		"Super Mario Bros": "Super Mario Bros",
+	} {
+		if trimmed := trimROMSuffix(name); trimmed != expected {
+			t.Errorf("trimROMSuffix(%q) is %q not %q", name, trimmed, expected)
+		}
+	}
+}
+
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for name, data := range files {
+		w, err := zw.Create(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write(data)
+	}
+
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+func TestExtractZip(t *testing.T) {
+	single := zipArchive(t, map[string][]byte{
+		"readme.txt": []byte("readme"),
+		"Game.nes":   []byte("game"),
+	})
+
+	rom, gamename, err := extractROM(single, "roms/Game.zip")
+
+	if err != nil {
+		t.Fatalf("Error extracting ROM: %v", err)
+	}
+
+	if string(rom) != "game" || gamename != "roms/Game" {
+		t.Errorf("Extracted %q, %q not %q, %q", rom, gamename, "game", "roms/Game")
+	}
+
+	multiple := zipArchive(t, map[string][]byte{
+		"Game (U).nes": []byte("usa"),
+		"Game (E).nes": []byte("europe"),
+	})
+
+	if _, _, err = extractROM(multiple, "roms/Games.zip"); err == nil {
+		t.Error("No error extracting ROM from archive with multiple ROMs")
+	}
+
+	rom, gamename, err = extractROM(multiple, "roms/Games.zip:Game (E).nes")
+
+	if err != nil {
+		t.Fatalf("Error extracting ROM: %v", err)
+	}
+
+	if string(rom) != "europe" || gamename != "roms/Game (E)" {
+		t.Errorf("Extracted %q, %q not %q, %q", rom, gamename, "europe", "roms/Game (E)")
+	}
+
+	if _, _, err = extractROM(multiple, "roms/Games.zip:Game (J).nes"); err == nil {
+		t.Error("No error extracting missing ROM from archive")
+	}
+}
+
+func TestExtractGzip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	gw.Write([]byte("game"))
+	gw.Close()
+
+	rom, gamename, err := extractROM(buf.Bytes(), "Game.nes.gz")
+
+	if err != nil {
+		t.Fatalf("Error extracting ROM: %v", err)
+	}
+
+	if string(rom) != "game" || gamename != "Game" {
+		t.Errorf("Extracted %q, %q not %q, %q", rom, gamename, "game", "Game")
+	}
+}
+
+func TestExtractTarGzip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+
+	for name, data := range map[string]string{
+		"Games/Game 1.nes": "one",
+		"Games/Game 2.nes": "two",
+	} {
+		tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		})
+		tw.Write([]byte(data))
+	}
+
+	tw.Close()
+	gw.Close()
+
+	rom, gamename, err := extractROM(buf.Bytes(), "Games.tar.gz:Game 2.nes")
+
+	if err != nil {
+		t.Fatalf("Error extracting ROM: %v", err)
+	}
+
+	if string(rom) != "two" || gamename != "Game 2" {
+		t.Errorf("Extracted %q, %q not %q, %q", rom, gamename, "two", "Game 2")
+	}
+}
+
+func TestExtractPlain(t *testing.T) {
+	rom, gamename, err := extractROM([]byte("NES\x1a"), "roms/Game.nes")
+
+	if err != nil {
+		t.Fatalf("Error extracting ROM: %v", err)
+	}
+
+	if string(rom) != "NES\x1a" || gamename != "roms/Game" {
+		t.Errorf("Extracted %q, %q not %q, %q", rom, gamename, "NES\x1a", "roms/Game")
+	}
+
+	if _, _, err = extractROM([]byte("NES\x1a"), "roms/Game.zip:Game.nes"); err == nil {
+		t.Error("No error extracting member from non-archive")
+	}
+}
@@ -0,0 +1,121 @@
+package nes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The most frames run-ahead can run past the current frame.
+const MaxRunAhead = 4
+
+// Returns an error if the run-ahead given in options cannot be used.
+func checkRunAhead(options *Options) error {
+	switch {
+	case options.RunAhead < 0 || options.RunAhead > MaxRunAhead:
+		return fmt.Errorf("Invalid run-ahead %v, must be 0-%v frames", options.RunAhead, MaxRunAhead)
+	case options.RunAhead > 0 && (options.Listen != "" || options.Connect != ""):
+		return errors.New("Run-ahead cannot be used with netplay")
+	}
+
+	return nil
+}
+
+// Returns true if the frame which is ending should be replaced on
+// screen by the one run-ahead frames after it.  Movies being played
+// are shown as they are, there being no input to respond to sooner.
+func (nes *NES) runningAhead() bool {
+	return nes.runAhead > 0 && !nes.quiet && nes.ahead == 0 &&
+		(nes.movie == nil || nes.movie.mode != moviePlaying)
+}
+
+// Called in place of the usual end of frame while running ahead.
+func (nes *NES) endFrameAhead(colors []uint8) {
+	nes.countLag()
+	nes.controllers.Frame()
+
+	if nes.ahead == 1 {
+		nes.frame(colors)
+	}
+}
+
+// A copy of the state of the emulated hardware returned by snapshot.
+type nesSnapshot struct {
+	state       []byte // the exported state, as in a save state
+	controllers func() // puts the controllers and devices back
+}
+
+// Returns a copy of the state of the emulated hardware, which unlike a
+// save state is neither compressed nor written with a copy of the ROM.
+// The PRG-ROM and CHR-ROM banks are left out, they never change, but
+// CHR-RAM is kept, as are the state of the controllers and the devices
+// plugged in, turbo and the microphone's WAV file included, which a
+// save state lacks.
+func (nes *NES) snapshot() (snapshot *nesSnapshot, err error) {
+	defer nes.withoutROMBanks()()
+
+	snapshot = &nesSnapshot{controllers: nes.controllers.save()}
+	snapshot.state, err = json.Marshal(nes)
+
+	return
+}
+
+// Goes back to a state returned by snapshot.  Unlike loading a save
+// state this leaves the movie where it is.
+func (nes *NES) restore(snapshot *nesSnapshot) error {
+	defer nes.withoutROMBanks()()
+
+	snapshot.controllers()
+
+	return json.Unmarshal(snapshot.state, nes)
+}
+
+// Takes the ROM banks which never change out of the ROM, returning a
+// function putting them back.
+func (nes *NES) withoutROMBanks() func() {
+	romf := nes.ROM.GetROMFile()
+	prg, chr := romf.ROMBanks, romf.VROMBanks
+
+	romf.ROMBanks = nil
+
+	if romf.CHRBanks > 0 {
+		romf.VROMBanks = nil
+	}
+
+	return func() {
+		romf.ROMBanks = prg
+
+		if romf.CHRBanks > 0 {
+			romf.VROMBanks = chr
+		}
+	}
+}
+
+// Called at the end of the instruction during which a frame ended,
+// runs the frames up to run-ahead frames after it with the input held
+// now, showing only the last of them, and goes back to where the
+// frame ended.  Games usually take a frame or more to respond to
+// input, which this hides.  The frames run ahead are neither heard nor
+// recorded in movies, and input queued in deterministic mode waits for
+// the end of the next real frame.
+func (nes *NES) runFramesAhead() (err error) {
+	state, err := nes.snapshot()
+
+	if err != nil {
+		return
+	}
+
+	for nes.ahead = nes.runAhead; nes.ahead > 0 && err == nil; nes.ahead-- {
+		err = nes.runFrame()
+	}
+
+	nes.ahead = 0
+
+	if e := nes.restore(state); err == nil {
+		err = e
+	}
+
+	nes.frameEnded = true
+
+	return
+}
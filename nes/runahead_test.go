@@ -0,0 +1,130 @@
+package nes
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+// Video keeping the frames it is given.
+type testVideo struct {
+	input  chan []uint8
+	frames [][]uint8
+}
+
+func (video *testVideo) Input() chan []uint8              { return video.input }
+func (video *testVideo) Events() chan Event               { return nil }
+func (video *testVideo) Run()                             {}
+func (video *testVideo) SetCaption(caption string)        {}
+func (video *testVideo) SetPalette(palette []color.Color) {}
+
+// Runs frames frames on a new NES with the given run-ahead, showing
+// the lag counter, which counts every frame once the NMI is switched
+// off, and returns the frames shown and the state hash after each.
+func runAheadTestNES(t *testing.T, runAhead, frames int) (shown [][]uint8, hashes []uint64) {
+	nes := newTestNES(t, &Options{Region: "NTSC", RunAhead: runAhead, LagCounter: true})
+
+	video := &testVideo{input: make(chan []uint8, 1)}
+	nes.video = video
+
+	nes.CPU.Memory.Store(0x2000, 0x00)
+
+	for i := 0; i < frames; i++ {
+		if err := nes.runFrame(); err != nil {
+			t.Fatalf("Error running frame %v: %v", i, err)
+		}
+
+		select {
+		case colors := <-video.input:
+			shown = append(shown, append([]uint8{}, colors...))
+		default:
+			t.Fatalf("No frame shown after frame %v", i)
+		}
+
+		hashes = append(hashes, nes.StateHash())
+	}
+
+	return
+}
+
+func TestRunAhead(t *testing.T) {
+	shown, hashes := runAheadTestNES(t, 0, 20)
+
+	for _, runAhead := range []int{1, 3} {
+		aheadShown, aheadHashes := runAheadTestNES(t, runAhead, 20-runAhead)
+
+		for i := range aheadShown {
+			if aheadHashes[i] != hashes[i] {
+				t.Errorf("Running %v frames ahead changed the state after frame %v", runAhead, i)
+			}
+
+			if !bytes.Equal(aheadShown[i], shown[i+runAhead]) {
+				t.Errorf("Running %v frames ahead showed a different frame after frame %v", runAhead, i)
+			}
+		}
+	}
+
+	if err := checkRunAhead(&Options{RunAhead: MaxRunAhead + 1}); err == nil {
+		t.Error("No error running too many frames ahead")
+	}
+
+	if err := checkRunAhead(&Options{RunAhead: 1, Listen: ":8080"}); err == nil {
+		t.Error("No error running ahead with netplay")
+	}
+}
+
+// Runs frames frames on a new NES with the given run-ahead, holding
+// TurboA down on controller I and playing a WAV file, loud every third
+// frame, to the microphone of Famicom controller II, and returns the
+// buttons the game read and whether the microphone heard something
+// after each frame.
+func runAheadInputTestNES(t *testing.T, runAhead, frames int) (read []uint8, heard []bool) {
+	nes := newTestNES(t, &Options{Region: "NTSC", RunAhead: runAhead, Port2: "famicom"})
+	nes.video = &testVideo{input: make(chan []uint8, 1)}
+
+	fc := nes.controllers.Device(Port2).(*FamicomController)
+	levels := make([]float64, frames+MaxRunAhead)
+
+	for i := range levels {
+		if i%3 == 0 {
+			levels[i] = 1
+		}
+	}
+
+	fc.setLevels(levels)
+	nes.controllers.KeyDown(0, TurboA)
+
+	for i := 0; i < frames; i++ {
+		if err := nes.runFrame(); err != nil {
+			t.Fatalf("Error running frame %v: %v", i, err)
+		}
+
+		select {
+		case <-nes.video.Input():
+		default:
+		}
+
+		read = append(read, nes.CPU.Memory.Fetch(0x0000))
+		heard = append(heard, fc.Microphone() != 0)
+	}
+
+	return
+}
+
+func TestRunAheadInput(t *testing.T) {
+	read, heard := runAheadInputTestNES(t, 0, 20)
+
+	for _, runAhead := range []int{1, 3} {
+		aheadRead, aheadHeard := runAheadInputTestNES(t, runAhead, 20)
+
+		for i := range read {
+			if aheadRead[i] != read[i] {
+				t.Errorf("Running %v frames ahead the game read $%02x not $%02x after frame %v", runAhead, aheadRead[i], read[i], i)
+			}
+
+			if aheadHeard[i] != heard[i] {
+				t.Errorf("Running %v frames ahead the microphone heard %v after frame %v", runAhead, aheadHeard[i], i)
+			}
+		}
+	}
+}
@@ -37,7 +37,7 @@ func NewAudio(frequency int, sampleSize int) (audio *SDLAudio, err error) {
 
 	audio = &SDLAudio{
 		samples: make([]int16, sampleSize),
-		input:   make(chan int16),
+		input:   make(chan int16, sampleSize),
 	}
 
 	return
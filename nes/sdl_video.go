@@ -5,8 +5,11 @@ package nes
 import (
 	"errors"
 	"fmt"
+	"image/color"
 	"math"
+	"sort"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/go-gl/gl"
@@ -36,6 +39,14 @@ type SDLVideo struct {
 	prog          gl.Program
 	texture       gl.Texture
 	width, height int
+	xOffset       int
+	yOffset       int
+	mouseButtons  [2]bool
+	bindings      *KeyBindings
+	joysticks     map[int]*sdl.Joystick // by SDL's index
+	devices       map[int]int           // GamepadEvent devices by SDL's index
+	names         map[int]string        // joystick names by device
+	nextDevice    int
 	textureUni    gl.AttribLocation
 	palette       []uint32
 	events        chan Event
@@ -45,10 +56,11 @@ type SDLVideo struct {
 	fps           float64
 }
 
-func NewVideo(caption string, events chan Event, framePool *sync.Pool, fps float64) (video *SDLVideo, err error) {
+func NewVideo(caption string, events chan Event, bindings *KeyBindings, framePool *sync.Pool, fps float64) (video *SDLVideo, err error) {
 	video = &SDLVideo{
 		input:     make(chan []uint8),
 		events:    events,
+		bindings:  bindings,
 		framePool: framePool,
 		palette:   SDLPalette,
 		overscan:  true,
@@ -78,13 +90,107 @@ func NewVideo(caption string, events chan Event, framePool *sync.Pool, fps float
 	video.initGL()
 	video.Reshape(int(video.screen.W), int(video.screen.H))
 
+	sdl.JoystickEventState(sdl.ENABLE)
+	video.openJoysticks()
+
 	return
 }
 
+// Opens the joysticks SDL found when its joystick subsystem was
+// initialized.  A joystick with the name of one open before keeps its
+// device, so a gamepad still plugged in carries on as it was, and a
+// GamepadEvent is sent for each joystick unplugged and then for each
+// one plugged in.
+func (video *SDLVideo) openJoysticks() {
+	var events []Event
+	var previous []int
+
+	for device := range video.names {
+		previous = append(previous, device)
+	}
+
+	sort.Ints(previous)
+
+	names := video.names
+	video.joysticks = map[int]*sdl.Joystick{}
+	video.devices = map[int]int{}
+	video.names = map[int]string{}
+
+	for i := 0; i < sdl.NumJoysticks(); i++ {
+		joystick := sdl.JoystickOpen(i)
+
+		if joystick == nil {
+			continue
+		}
+
+		name, device := sdl.JoystickName(i), -1
+
+		for j, d := range previous {
+			if names[d] == name {
+				device = d
+				previous = append(previous[:j], previous[j+1:]...)
+				break
+			}
+		}
+
+		if device < 0 {
+			device = video.nextDevice
+			video.nextDevice++
+			events = append(events, &GamepadEvent{Device: device, Name: name, Kind: GamepadConnected})
+		}
+
+		video.joysticks[i] = joystick
+		video.devices[i] = device
+		video.names[device] = name
+	}
+
+	// the joysticks unplugged free their controllers first
+	var disconnected []Event
+
+	for _, device := range previous {
+		disconnected = append(disconnected, &GamepadEvent{Device: device, Kind: GamepadDisconnected})
+	}
+
+	events = append(disconnected, events...)
+
+	if len(events) > 0 {
+		go func() {
+			for _, event := range events {
+				video.events <- event
+			}
+		}()
+	}
+}
+
+// SDL sends no events when joysticks are plugged in or unplugged and
+// only looks for them when its joystick subsystem is initialized, so
+// the subsystem is restarted, closing the joysticks open, and the
+// joysticks are opened again.
+func (video *SDLVideo) rescanJoysticks() {
+	for _, joystick := range video.joysticks {
+		joystick.Close()
+	}
+
+	sdl.QuitSubSystem(sdl.INIT_JOYSTICK)
+	sdl.InitSubSystem(sdl.INIT_JOYSTICK)
+	sdl.JoystickEventState(sdl.ENABLE)
+
+	video.openJoysticks()
+}
+
 func (video *SDLVideo) SetCaption(caption string) {
 	sdl.WM_SetCaption("nintengo - "+video.caption, "")
 }
 
+func (video *SDLVideo) SetPalette(palette []color.Color) {
+	video.palette = make([]uint32, len(palette))
+
+	for i, c := range palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		video.palette[i] = uint32(rgba.R)<<24 | uint32(rgba.G)<<16 | uint32(rgba.B)<<8
+	}
+}
+
 func (video *SDLVideo) Events() chan Event {
 	return video.events
 }
@@ -208,10 +314,25 @@ func (video *SDLVideo) Reshape(width int, height int) {
 
 	video.width = width
 	video.height = height
+	video.xOffset = x_offset
+	video.yOffset = y_offset
 
 	gl.Viewport(x_offset, y_offset, width, height)
 }
 
+// Returns a MouseEvent for the mouse at x, y in the window.
+func (video *SDLVideo) mouseEvent(x, y int) Event {
+	x, y = framePosition(x-video.xOffset, y-video.yOffset,
+		video.width, video.height, visibleFrame(video.overscan))
+
+	return &MouseEvent{
+		X:     x,
+		Y:     y,
+		Left:  video.mouseButtons[0],
+		Right: video.mouseButtons[1],
+	}
+}
+
 func (video *SDLVideo) Input() chan []uint8 {
 	return video.input
 }
@@ -240,8 +361,13 @@ func (video *SDLVideo) Run() {
 	running := true
 	frame := make([]uint32, 0xf000)
 
+	rescan := time.NewTicker(2 * time.Second)
+	defer rescan.Stop()
+
 	for running {
 		select {
+		case <-rescan.C:
+			video.rescanJoysticks()
 		case ev := <-sdl.Events:
 			var event Event
 
@@ -249,145 +375,64 @@ func (video *SDLVideo) Run() {
 			case sdl.QuitEvent:
 				running = false
 				event = &QuitEvent{}
-			case sdl.KeyboardEvent:
-				switch e.Keysym.Sym {
-				case sdl.K_BACKQUOTE:
-					if e.Type == sdl.KEYDOWN {
-						video.overscan = !video.overscan
-					}
-				case sdl.K_1:
-					if e.Type == sdl.KEYDOWN {
-						video.ResizeEvent(256, 240)
-					}
-				case sdl.K_2:
-					if e.Type == sdl.KEYDOWN {
-						video.ResizeEvent(512, 480)
-					}
-				case sdl.K_3:
-					if e.Type == sdl.KEYDOWN {
-						video.ResizeEvent(768, 720)
-					}
-				case sdl.K_4:
-					if e.Type == sdl.KEYDOWN {
-						video.ResizeEvent(1024, 960)
-					}
-				case sdl.K_5:
-					if e.Type == sdl.KEYDOWN {
-						video.ResizeEvent(2560, 1440)
-					}
-				case sdl.K_p:
-					if e.Type == sdl.KEYDOWN {
-						event = &PauseEvent{}
-					}
-				case sdl.K_n:
-					if e.Type == sdl.KEYDOWN {
-						event = &FrameStepEvent{}
-					}
-				case sdl.K_q:
-					if e.Type == sdl.KEYDOWN {
-						running = false
-						event = &QuitEvent{}
-					}
-				case sdl.K_l:
-					if e.Type == sdl.KEYDOWN {
-						event = &SavePatternTablesEvent{}
-					}
-				case sdl.K_r:
-					if e.Type == sdl.KEYDOWN {
-						event = &ResetEvent{}
-					}
-				case sdl.K_s:
-					if e.Type == sdl.KEYDOWN {
-						event = &RecordEvent{}
-					}
-				case sdl.K_d:
-					if e.Type == sdl.KEYDOWN {
-						event = &StopEvent{}
-					}
-				case sdl.K_KP_PLUS:
-					if e.Type == sdl.KEYDOWN {
-						event = &AudioRecordEvent{}
-					}
-				case sdl.K_KP_MINUS:
-					if e.Type == sdl.KEYDOWN {
-						event = &AudioStopEvent{}
-					}
-
-				case sdl.K_o:
-					if e.Type == sdl.KEYDOWN {
-						event = &CPUDecodeEvent{}
-					}
-				case sdl.K_i:
-					if e.Type == sdl.KEYDOWN {
-						event = &PPUDecodeEvent{}
-					}
-				case sdl.K_9:
-					if e.Type == sdl.KEYDOWN {
-						event = &ShowBackgroundEvent{}
-					}
-				case sdl.K_0:
-					if e.Type == sdl.KEYDOWN {
-						event = &ShowSpritesEvent{}
-					}
-				case sdl.K_F1:
-					if e.Type == sdl.KEYDOWN {
-						event = &SaveStateEvent{}
-					}
-				case sdl.K_F5:
-					if e.Type == sdl.KEYDOWN {
-						event = &LoadStateEvent{}
-					}
-				case sdl.K_F8:
-					if e.Type == sdl.KEYDOWN {
-						event = &FPSEvent{2.}
-					}
-				case sdl.K_F9:
-					if e.Type == sdl.KEYDOWN {
-						event = &FPSEvent{1.}
-					}
-				case sdl.K_F10:
-					if e.Type == sdl.KEYDOWN {
-						event = &FPSEvent{.75}
-					}
-				case sdl.K_F11:
-					if e.Type == sdl.KEYDOWN {
-						event = &FPSEvent{.5}
-					}
-				case sdl.K_F12:
-					if e.Type == sdl.KEYDOWN {
-						event = &FPSEvent{.25}
-					}
-				case sdl.K_KP0:
-					if e.Type == sdl.KEYDOWN {
-						event = &MuteEvent{}
-					}
-				case sdl.K_KP1:
-					if e.Type == sdl.KEYDOWN {
-						event = &MutePulse1Event{}
-					}
-				case sdl.K_KP2:
-					if e.Type == sdl.KEYDOWN {
-						event = &MutePulse2Event{}
-					}
-				case sdl.K_KP3:
-					if e.Type == sdl.KEYDOWN {
-						event = &MuteTriangleEvent{}
-					}
-				case sdl.K_KP4:
-					if e.Type == sdl.KEYDOWN {
-						event = &MuteNoiseEvent{}
-					}
-				case sdl.K_KP5:
-					if e.Type == sdl.KEYDOWN {
-						event = &MuteDMCEvent{}
-					}
+			case sdl.JoyAxisEvent:
+				event = &GamepadEvent{
+					Device: video.devices[int(e.Which)],
+					Kind:   GamepadAxis,
+					Index:  int(e.Axis),
+					Value:  float64(e.Value) / 32768,
+				}
+			case sdl.JoyHatEvent:
+				event = &GamepadEvent{
+					Device: video.devices[int(e.Which)],
+					Kind:   GamepadHat,
+					Index:  int(e.Hat),
+					Value:  float64(e.Value),
+				}
+			case sdl.JoyButtonEvent:
+				value := 0.0
+
+				if e.State == sdl.PRESSED {
+					value = 1.0
+				}
+
+				event = &GamepadEvent{
+					Device: video.devices[int(e.Which)],
+					Kind:   GamepadButton,
+					Index:  int(e.Button),
+					Value:  value,
+				}
+			case sdl.MouseMotionEvent:
+				event = video.mouseEvent(int(e.X), int(e.Y))
+			case sdl.MouseButtonEvent:
+				switch e.Button {
+				case sdl.BUTTON_LEFT:
+					video.mouseButtons[0] = e.Type == sdl.MOUSEBUTTONDOWN
+				case sdl.BUTTON_RIGHT:
+					video.mouseButtons[1] = e.Type == sdl.MOUSEBUTTONDOWN
 				}
 
-				if event == nil && running {
-					event = &ControllerEvent{
-						Button: button(e),
-						Down:   e.Type == sdl.KEYDOWN,
-					}
+				event = video.mouseEvent(int(e.X), int(e.Y))
+			case sdl.KeyboardEvent:
+				var action string
+
+				event, action = video.bindings.Handle(sdlKeyName(e.Keysym.Sym), e.Type == sdl.KEYDOWN)
+
+				switch action {
+				case "overscan":
+					video.overscan = !video.overscan
+				case "size-1":
+					video.ResizeEvent(256, 240)
+				case "size-2":
+					video.ResizeEvent(512, 480)
+				case "size-3":
+					video.ResizeEvent(768, 720)
+				case "size-4":
+					video.ResizeEvent(1024, 960)
+				case "size-5":
+					video.ResizeEvent(2560, 1440)
+				case "quit":
+					running = false
 				}
 			}
 
@@ -433,27 +478,77 @@ func (video *SDLVideo) Run() {
 	}
 }
 
-func button(ev interface{}) Button {
-	if k, ok := ev.(sdl.KeyboardEvent); ok {
-		switch k.Keysym.Sym {
-		case sdl.K_z: // A
-			return A
-		case sdl.K_x: // B
-			return B
-		case sdl.K_RSHIFT: // Select
-			return Select
-		case sdl.K_RETURN: // Start
-			return Start
-		case sdl.K_UP: // Up
-			return Up
-		case sdl.K_DOWN: // Down
-			return Down
-		case sdl.K_LEFT: // Left
-			return Left
-		case sdl.K_RIGHT: // Right
-			return Right
-		}
+// The names keys other than letters and digits are bound by in
+// ~/.nintengorc.
+var sdlKeyNames = map[uint32]string{
+	sdl.K_KP0:          "kp0",
+	sdl.K_KP1:          "kp1",
+	sdl.K_KP2:          "kp2",
+	sdl.K_KP3:          "kp3",
+	sdl.K_KP4:          "kp4",
+	sdl.K_KP5:          "kp5",
+	sdl.K_KP6:          "kp6",
+	sdl.K_KP7:          "kp7",
+	sdl.K_KP8:          "kp8",
+	sdl.K_KP9:          "kp9",
+	sdl.K_F1:           "f1",
+	sdl.K_F2:           "f2",
+	sdl.K_F3:           "f3",
+	sdl.K_F4:           "f4",
+	sdl.K_F5:           "f5",
+	sdl.K_F6:           "f6",
+	sdl.K_F7:           "f7",
+	sdl.K_F8:           "f8",
+	sdl.K_F9:           "f9",
+	sdl.K_F10:          "f10",
+	sdl.K_F11:          "f11",
+	sdl.K_F12:          "f12",
+	sdl.K_UP:           "up",
+	sdl.K_DOWN:         "down",
+	sdl.K_LEFT:         "left",
+	sdl.K_RIGHT:        "right",
+	sdl.K_RETURN:       "enter",
+	sdl.K_SPACE:        "space",
+	sdl.K_TAB:          "tab",
+	sdl.K_BACKSPACE:    "backspace",
+	sdl.K_ESCAPE:       "escape",
+	sdl.K_INSERT:       "insert",
+	sdl.K_DELETE:       "delete",
+	sdl.K_HOME:         "home",
+	sdl.K_END:          "end",
+	sdl.K_PAGEUP:       "pageup",
+	sdl.K_PAGEDOWN:     "pagedown",
+	sdl.K_LSHIFT:       "lshift",
+	sdl.K_RSHIFT:       "rshift",
+	sdl.K_LCTRL:        "lctrl",
+	sdl.K_RCTRL:        "rctrl",
+	sdl.K_LALT:         "lalt",
+	sdl.K_RALT:         "ralt",
+	sdl.K_BACKQUOTE:    "backquote",
+	sdl.K_MINUS:        "minus",
+	sdl.K_EQUALS:       "equals",
+	sdl.K_LEFTBRACKET:  "leftbracket",
+	sdl.K_RIGHTBRACKET: "rightbracket",
+	sdl.K_BACKSLASH:    "backslash",
+	sdl.K_SEMICOLON:    "semicolon",
+	sdl.K_QUOTE:        "quote",
+	sdl.K_COMMA:        "comma",
+	sdl.K_PERIOD:       "period",
+	sdl.K_SLASH:        "slash",
+	sdl.K_KP_PLUS:      "kpplus",
+	sdl.K_KP_MINUS:     "kpminus",
+	sdl.K_KP_MULTIPLY:  "kpmultiply",
+	sdl.K_KP_DIVIDE:    "kpdivide",
+	sdl.K_KP_PERIOD:    "kpperiod",
+	sdl.K_KP_ENTER:     "kpenter",
+}
+
+// Returns the name key is bound by, letters and digits being their
+// own ASCII codes.
+func sdlKeyName(key uint32) string {
+	if (key >= 'a' && key <= 'z') || (key >= '0' && key <= '9') {
+		return string(rune(key))
 	}
 
-	return One
+	return sdlKeyNames[key]
 }
@@ -0,0 +1,100 @@
+package nes
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The slowest speed the NES can run at, as a fraction of full speed.
+const MinSpeed = 0.05
+
+// Runs the NES as fast as it can be emulated.
+var UnlimitedSpeed = math.Inf(1)
+
+// The speeds the speed-up and slow-down actions step through.
+var Speeds = []float64{0.05, 0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 4, UnlimitedSpeed}
+
+// Parses a speed given as a fraction of full speed, e.g. '0.5', as a
+// percentage, e.g. '50%', or as 'unlimited'.
+func ParseSpeed(s string) (speed float64, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch {
+	case s == "unlimited":
+		return UnlimitedSpeed, nil
+	case strings.HasSuffix(s, "%"):
+		if speed, err = strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64); err == nil {
+			speed /= 100
+		}
+	default:
+		speed, err = strconv.ParseFloat(s, 64)
+	}
+
+	if err != nil || speed < MinSpeed || math.IsNaN(speed) {
+		err = fmt.Errorf("Invalid speed '%v', must be %v%% or more or 'unlimited'", s, MinSpeed*100)
+	}
+
+	return
+}
+
+func FormatSpeed(speed float64) string {
+	if math.IsInf(speed, 1) {
+		return "unlimited"
+	}
+
+	return strconv.FormatFloat(speed*100, 'f', -1, 64) + "%"
+}
+
+// Returns the speed delta steps up or down the list of Speeds from
+// speed.
+func nextSpeed(speed float64, delta int) float64 {
+	i := 0
+
+	for i < len(Speeds)-1 && Speeds[i] < speed {
+		i++
+	}
+
+	// a speed between two in the list counts as the one below when
+	// stepping up
+	if delta > 0 && Speeds[i] > speed {
+		i--
+	}
+
+	i += delta
+
+	switch {
+	case i < 0:
+		i = 0
+	case i >= len(Speeds):
+		i = len(Speeds) - 1
+	}
+
+	return Speeds[i]
+}
+
+// Runs the NES at speed times full speed.  Sound is muted at any speed
+// but full speed, since the audio device plays at a fixed rate, and
+// with audio sync full speed is paced by the audio device.
+func (nes *NES) SetSpeed(speed float64) {
+	if speed < MinSpeed {
+		speed = MinSpeed
+	}
+
+	nes.speed = speed
+
+	if math.IsInf(speed, 1) || (speed == 1 && nes.audioSync != nil) {
+		nes.fps.Disable()
+	} else {
+		nes.fps.SetRate(nes.DefaultFPS * speed)
+	}
+
+	// samples reach the audio device at full speed only, so it
+	// always plays at its own rate
+	nes.audio.SetSpeed(1)
+}
+
+func (nes *NES) Speed() float64 {
+	return nes.speed
+}
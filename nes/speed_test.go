@@ -0,0 +1,85 @@
+package nes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpeed(t *testing.T) {
+	for s, expected := range map[string]float64{
+		"1":         1,
+		"0.05":      0.05,
+		"50%":       0.5,
+		"250%":      2.5,
+		"Unlimited": UnlimitedSpeed,
+	} {
+		if speed, err := ParseSpeed(s); err != nil || speed != expected {
+			t.Errorf("Parsed '%v' as %v, %v not %v", s, speed, err, expected)
+		}
+	}
+
+	for _, s := range []string{"", "0", "4%", "-1", "fast", "NaN"} {
+		if _, err := ParseSpeed(s); err == nil {
+			t.Errorf("No error parsing '%v'", s)
+		}
+	}
+
+	if s := FormatSpeed(0.25); s != "25%" {
+		t.Errorf("Formatted 0.25 as %v", s)
+	}
+}
+
+func TestNextSpeed(t *testing.T) {
+	for _, test := range []struct {
+		speed    float64
+		delta    int
+		expected float64
+	}{
+		{1, 1, 1.5},
+		{1, -1, 0.75},
+		{0.6, 1, 0.75},
+		{0.6, -1, 0.5},
+		{0.05, -1, 0.05},
+		{4, 1, UnlimitedSpeed},
+		{UnlimitedSpeed, 1, UnlimitedSpeed},
+		{UnlimitedSpeed, -1, 4},
+	} {
+		if speed := nextSpeed(test.speed, test.delta); speed != test.expected {
+			t.Errorf("Stepping %v from %v gave %v not %v", test.delta, test.speed, speed, test.expected)
+		}
+	}
+}
+
+func TestFPSDelay(t *testing.T) {
+	fps := NewFPS(400)
+	start := time.Now()
+
+	for i := 0; i < 20; i++ {
+		fps.Delay()
+	}
+
+	// 20 frames at 400 FPS take 50ms
+	if elapsed := time.Since(start); elapsed < 49*time.Millisecond {
+		t.Errorf("20 frames at 400 FPS took %v", elapsed)
+	}
+}
+
+func TestFastForward(t *testing.T) {
+	nes := newTestNES(t, &Options{Region: "NTSC"})
+	nes.SetSpeed(0.5)
+
+	(&FastForwardEvent{Down: true}).Process(nes)
+
+	// a held key repeating does not lose the speed before
+	(&FastForwardEvent{Down: true}).Process(nes)
+
+	if nes.Speed() != 2 {
+		t.Errorf("Speed is %v fast-forwarding", nes.Speed())
+	}
+
+	(&FastForwardEvent{Down: false}).Process(nes)
+
+	if nes.Speed() != 0.5 {
+		t.Errorf("Speed is %v after fast-forwarding not 0.5", nes.Speed())
+	}
+}
@@ -0,0 +1,483 @@
+package nes
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var splitsSuffixes = []string{".splits.yml", ".splits.yaml"}
+
+// Returns the name of a splits file sitting next to the ROM for the
+// given game and sharing its name, e.g. 'Game.splits.yml' for
+// 'Game.nes', or the empty string if there is none.
+func findSplits(gamename string) string {
+	for _, suffix := range splitsSuffixes {
+		if _, err := os.Stat(gamename + suffix); err == nil {
+			return gamename + suffix
+		}
+	}
+
+	return ""
+}
+
+// A condition on a byte of CPU RAM, checked against its value at the
+// end of a frame and at the end of the frame before.
+type splitCondition struct {
+	address uint16
+	changes bool // the value must differ from the frame before's
+	from    int  // the frame before's value, -1 for any
+	to      int  // this frame's value, -1 for any
+	not     bool // this frame's value must not be to
+}
+
+func (c *splitCondition) matches(last, ram *[0x800]uint8) bool {
+	old, value := int(last[c.address]), int(ram[c.address])
+
+	switch {
+	case c.changes && old == value:
+		return false
+	case c.from >= 0 && old != c.from:
+		return false
+	case c.to >= 0 && (value == c.to) == c.not:
+		return false
+	}
+
+	return true
+}
+
+// A rule which starts, splits or resets the timer at the end of a frame
+// when all of its conditions hold.
+type SplitRule []splitCondition
+
+func (rule SplitRule) matches(last, ram *[0x800]uint8) bool {
+	for i := range rule {
+		if !rule[i].matches(last, ram) {
+			return false
+		}
+	}
+
+	return len(rule) > 0
+}
+
+// Parses a byte value or a RAM address given in hex with a '$' or '0x'
+// prefix, or in decimal.
+func parseSplitValue(s string, max uint64) (value int, err error) {
+	var v uint64
+
+	switch {
+	case strings.HasPrefix(s, "$"):
+		v, err = strconv.ParseUint(s[1:], 16, 16)
+	case strings.HasPrefix(s, "0x"):
+		v, err = strconv.ParseUint(s[2:], 16, 16)
+	default:
+		v, err = strconv.ParseUint(s, 10, 16)
+	}
+
+	if err == nil && v > max {
+		err = fmt.Errorf("'%v' is more than $%x", s, max)
+	}
+
+	return int(v), err
+}
+
+// Parses a rule made of conditions joined by 'and', each one of:
+//
+//	$0770 changes
+//	$0770 changes to 0
+//	$0770 changes from 1
+//	$0770 changes from 1 to 0
+//	$0770 is 0
+//	$0770 is not 0
+//
+// Addresses are in the CPU's 2KB of RAM, $0000-$07ff.  Addresses and
+// values are given in hex with a '$' or '0x' prefix, or in decimal.
+func ParseSplitRule(s string) (rule SplitRule, err error) {
+	fields := strings.Fields(strings.ToLower(s))
+
+	for start, i := 0, 0; i <= len(fields); i++ {
+		if i < len(fields) && fields[i] != "and" {
+			continue
+		}
+
+		var c splitCondition
+
+		if c, err = parseSplitCondition(fields[start:i]); err != nil {
+			err = fmt.Errorf("Invalid rule '%v': %v", s, err)
+			return
+		}
+
+		rule = append(rule, c)
+		start = i + 1
+	}
+
+	return
+}
+
+func parseSplitCondition(fields []string) (c splitCondition, err error) {
+	var address int
+	var values []string
+
+	c.from, c.to = -1, -1
+
+	if len(fields) < 2 {
+		err = errors.New("Condition must be an address followed by 'changes' or 'is'")
+		return
+	}
+
+	if address, err = parseSplitValue(fields[0], 0x7ff); err != nil {
+		return
+	}
+
+	c.address = uint16(address)
+
+	switch fields = fields[1:]; {
+	case fields[0] == "changes" && len(fields) == 1:
+	case fields[0] == "changes" && len(fields) == 3 && fields[1] == "to":
+		values = []string{"", fields[2]}
+	case fields[0] == "changes" && len(fields) == 3 && fields[1] == "from":
+		values = []string{fields[2], ""}
+	case fields[0] == "changes" && len(fields) == 5 && fields[1] == "from" && fields[3] == "to":
+		values = []string{fields[2], fields[4]}
+	case fields[0] == "is" && len(fields) == 2:
+		values = []string{"", fields[1]}
+	case fields[0] == "is" && len(fields) == 3 && fields[1] == "not":
+		values = []string{"", fields[2]}
+		c.not = true
+	default:
+		err = fmt.Errorf("Unknown condition '%v'", strings.Join(fields, " "))
+		return
+	}
+
+	c.changes = fields[0] == "changes"
+
+	for i, v := range values {
+		if v == "" {
+			continue
+		}
+
+		var value int
+
+		if value, err = parseSplitValue(v, 0xff); err != nil {
+			return
+		}
+
+		if i == 0 {
+			c.from = value
+		} else {
+			c.to = value
+		}
+	}
+
+	return
+}
+
+// A splits file, in YAML, names the game and category being run, gives
+// the rules which start and, optionally, reset the timer and lists the
+// splits in order with the rule for each:
+//
+//	game: Super Mario Bros.
+//	category: Any%
+//	start: $0770 changes from 0 to 1
+//	reset: $0770 changes to 0
+//	splits:
+//	  - name: 1-1
+//	    split: $0760 changes from 0 to 1
+//	  - name: 1-2
+//	    split: $075f changes from 0 to 3
+//
+// The last split ends the run.
+type SplitsFile struct {
+	Game     string `yaml:"game"`
+	Category string `yaml:"category"`
+	Start    string `yaml:"start"`
+	Reset    string `yaml:"reset"`
+	Splits   []struct {
+		Name  string `yaml:"name"`
+		Split string `yaml:"split"`
+	} `yaml:"splits"`
+}
+
+// A run of the timer: when it started and ended, by the wall clock,
+// and the in-game and real time at each split it reached, from the
+// start of the run.
+type SplitAttempt struct {
+	Started  time.Time
+	Ended    time.Time
+	Frames   []int
+	RealTime []time.Duration
+}
+
+func framesToDuration(frames int, fps float64) time.Duration {
+	return time.Duration(float64(frames) / fps * float64(time.Second))
+}
+
+// Times speedruns, starting, splitting and resetting by rules checked
+// against CPU RAM at the end of every frame.  In-game time counts the
+// frames emulated since the start rule held, so it is unaffected by
+// the speed, pauses and the host's clock.  Every run finished or reset
+// is kept and added to a LiveSplit splits file when a run finishes and
+// when nintengo quits.
+type SplitTimer struct {
+	Game     string
+	Category string
+	Names    []string
+	Attempts []SplitAttempt
+	Running  bool
+	Frame    int // frames since the start of the current run
+	lss      string
+	fps      float64
+	start    SplitRule
+	reset    SplitRule
+	splits   []SplitRule
+	current  *SplitAttempt
+	address  []uint16
+	last     [0x800]uint8
+	ram      [0x800]uint8
+	primed   bool
+	previous *lssRun // the LiveSplit file before the timer started, nil if there was none
+}
+
+// Returns a timer using the splits file given by the -splits option,
+// or the same-named splits file found by findSplits if none is given,
+// adding split times to the file given by -lss, nil if there is no
+// splits file.
+func NewSplitTimer(options *Options, gamename string, fps float64) (timer *SplitTimer, err error) {
+	filename := options.Splits
+
+	if filename == "" {
+		if filename = findSplits(gamename); filename == "" {
+			return
+		}
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return
+	}
+
+	if timer, err = ParseSplits(buf, fps); err != nil {
+		err = fmt.Errorf("%v: %v", filename, err)
+		return
+	}
+
+	if timer.lss = options.LSS; timer.lss == "" {
+		timer.lss = strings.TrimSuffix(strings.TrimSuffix(filename, filepath.Ext(filename)), ".splits") + ".lss"
+	}
+
+	// runs are added to those in the LiveSplit file already
+	if timer.previous, err = readLSS(timer.lss); err != nil {
+		err = fmt.Errorf("%v: %v", timer.lss, err)
+		return
+	}
+
+	if timer.previous != nil && len(timer.previous.Segments) != len(timer.Names) {
+		err = fmt.Errorf("%v has %v segments, %v has %v splits", timer.lss, len(timer.previous.Segments), filename, len(timer.Names))
+		return
+	}
+
+	fmt.Println("*** Loaded splits", filename)
+
+	return
+}
+
+// Returns a timer for a YAML splits file, counting fps frames to the
+// second of in-game time.
+func ParseSplits(buf []byte, fps float64) (timer *SplitTimer, err error) {
+	var file SplitsFile
+
+	if err = yaml.Unmarshal(buf, &file); err != nil {
+		return
+	}
+
+	if len(file.Splits) == 0 {
+		err = errors.New("No splits given")
+		return
+	}
+
+	timer = &SplitTimer{
+		Game:     file.Game,
+		Category: file.Category,
+		fps:      fps,
+	}
+
+	if timer.start, err = ParseSplitRule(file.Start); err != nil {
+		err = fmt.Errorf("start: %v", err)
+		return
+	}
+
+	if file.Reset != "" {
+		if timer.reset, err = ParseSplitRule(file.Reset); err != nil {
+			err = fmt.Errorf("reset: %v", err)
+			return
+		}
+	}
+
+	for _, split := range file.Splits {
+		var rule SplitRule
+
+		if rule, err = ParseSplitRule(split.Split); err != nil {
+			err = fmt.Errorf("%v: %v", split.Name, err)
+			return
+		}
+
+		timer.Names = append(timer.Names, split.Name)
+		timer.splits = append(timer.splits, rule)
+	}
+
+	// only the addresses the rules check are read each frame
+	seen := map[uint16]bool{}
+
+	for _, rule := range append([]SplitRule{timer.start, timer.reset}, timer.splits...) {
+		for _, c := range rule {
+			if !seen[c.address] {
+				seen[c.address] = true
+				timer.address = append(timer.address, c.address)
+			}
+		}
+	}
+
+	return
+}
+
+// Called at the end of every frame with a function reading CPU
+// memory.
+func (timer *SplitTimer) frame(fetch func(address uint16) uint8) {
+	timer.last = timer.ram
+
+	for _, address := range timer.address {
+		timer.ram[address] = fetch(address)
+	}
+
+	if timer.Running {
+		timer.Frame++
+	}
+
+	// nothing has changed on the first frame
+	if !timer.primed {
+		timer.primed = true
+		return
+	}
+
+	switch {
+	case timer.Running && timer.reset.matches(&timer.last, &timer.ram):
+		timer.end()
+		fmt.Println("*** Timer reset")
+	case timer.Running:
+		if timer.splits[len(timer.current.Frames)].matches(&timer.last, &timer.ram) {
+			timer.split()
+		}
+	case timer.start.matches(&timer.last, &timer.ram):
+		timer.Running = true
+		timer.Frame = 0
+		timer.current = &SplitAttempt{Started: time.Now()}
+		fmt.Println("*** Timer started")
+	}
+}
+
+// Called when RAM changes other than by running a frame, when a state
+// is loaded or the console is reset, so that the rules only compare
+// frames run one after the other.
+func (timer *SplitTimer) skip() {
+	timer.primed = false
+}
+
+func (timer *SplitTimer) split() {
+	i := len(timer.current.Frames)
+
+	timer.current.Frames = append(timer.current.Frames, timer.Frame)
+	timer.current.RealTime = append(timer.current.RealTime, time.Since(timer.current.Started))
+
+	fmt.Printf("*** Split %v at %v\n", timer.Names[i], formatSplitTime(timer.Time()))
+
+	if i+1 < len(timer.splits) {
+		return
+	}
+
+	timer.end()
+	fmt.Println("*** Run finished in", formatSplitTime(timer.Time()))
+
+	if err := timer.Save(); err != nil {
+		fmt.Println("*** Error writing splits:", err)
+	}
+}
+
+// Ends the current run, keeping it with the others.
+func (timer *SplitTimer) end() {
+	timer.current.Ended = time.Now()
+	timer.Attempts = append(timer.Attempts, *timer.current)
+	timer.current = nil
+	timer.Running = false
+}
+
+// Returns the in-game time of the current run, or of the last one if
+// none is running.
+func (timer *SplitTimer) Time() time.Duration {
+	return framesToDuration(timer.Frame, timer.fps)
+}
+
+// Writes the LiveSplit file with the runs timed so far added to it.
+func (timer *SplitTimer) Save() (err error) {
+	if timer.lss == "" || len(timer.Attempts) == 0 {
+		return
+	}
+
+	f, err := os.Create(timer.lss)
+
+	if err != nil {
+		return
+	}
+
+	if err = timer.WriteLSS(f); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+
+	if err == nil {
+		fmt.Println("*** Wrote splits to", timer.lss)
+	}
+
+	return
+}
+
+// Ends any run in progress as if reset and writes the runs timed to
+// the LiveSplit file.
+func (timer *SplitTimer) Close() error {
+	if timer.Running {
+		timer.end()
+	}
+
+	return timer.Save()
+}
+
+// Formats a time as minutes, seconds and hundredths, with hours if
+// there are any, e.g. '1:23.45'.
+func formatSplitTime(d time.Duration) string {
+	cs := int64(d / (10 * time.Millisecond))
+	h, m, s := cs/360000, cs/6000%60, cs/100%60
+
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, cs%100)
+	}
+
+	return fmt.Sprintf("%d:%02d.%02d", m, s, cs%100)
+}
+
+// Returns the text shown over the picture: the next split and the
+// in-game time while running, the time of the last run otherwise.
+func (timer *SplitTimer) String() string {
+	if timer.Running {
+		return timer.Names[len(timer.current.Frames)] + " " + formatSplitTime(timer.Time())
+	}
+
+	return formatSplitTime(timer.Time())
+}
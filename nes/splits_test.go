@@ -0,0 +1,211 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestParseSplitRule(t *testing.T) {
+	var last, ram [0x800]uint8
+
+	last[0x770], ram[0x770] = 1, 0
+	last[0x10], ram[0x10] = 5, 5
+
+	for s, expected := range map[string]bool{
+		"$0770 changes":                          true,
+		"$0770 changes to 0":                     true,
+		"$0770 changes to 1":                     false,
+		"$0770 changes from 1":                   true,
+		"$0770 changes from 1 to 0":              true,
+		"0x770 changes from 0 to 1":              false,
+		"$0010 changes":                          false,
+		"16 is 5":                                true,
+		"$10 is not 5":                           false,
+		"$0770 changes to $00 and $0010 is 5":    true,
+		"$0770 Changes To 0 AND $0010 is not $5": false,
+	} {
+		rule, err := ParseSplitRule(s)
+
+		if err != nil {
+			t.Errorf("Error parsing '%v': %v", s, err)
+		} else if rule.matches(&last, &ram) != expected {
+			t.Errorf("Rule '%v' matches is %v", s, !expected)
+		}
+	}
+
+	for _, s := range []string{"", "$0770", "$0800 is 0", "$0770 is 256", "$0770 becomes 1", "$0770 is 0 and", "$xyz is 0"} {
+		if _, err := ParseSplitRule(s); err == nil {
+			t.Errorf("No error parsing '%v'", s)
+		}
+	}
+}
+
+var splitsTestFile = []byte(`game: Test
+category: Any%
+start: $0000 changes from 0 to 1
+reset: $0000 changes to 0
+splits:
+  - name: One
+    split: $0001 changes to 1
+  - name: Two
+    split: $0001 changes to 2
+`)
+
+func TestSplitTimer(t *testing.T) {
+	timer, err := ParseSplits(splitsTestFile, 60)
+
+	if err != nil {
+		t.Fatalf("Error parsing splits: %v", err)
+	}
+
+	var ram [0x800]uint8
+	fetch := func(address uint16) uint8 { return ram[address] }
+
+	// values of $0000 and $0001 for each frame: a run reset after the
+	// first split and a finished run
+	for _, values := range [][2]uint8{
+		{0, 0}, {1, 0}, {1, 0}, {1, 1}, {0, 1},
+		{1, 0}, {1, 0}, {1, 1}, {1, 1}, {1, 1}, {1, 2}, {1, 2},
+	} {
+		ram[0], ram[1] = values[0], values[1]
+		timer.frame(fetch)
+	}
+
+	if timer.Running || len(timer.Attempts) != 2 {
+		t.Fatalf("Running is %v with %v attempts", timer.Running, len(timer.Attempts))
+	}
+
+	if frames := timer.Attempts[0].Frames; len(frames) != 1 || frames[0] != 2 {
+		t.Errorf("Reset run split at frames %v", frames)
+	}
+
+	if frames := timer.Attempts[1].Frames; len(frames) != 2 || frames[0] != 2 || frames[1] != 5 {
+		t.Errorf("Finished run split at frames %v", frames)
+	}
+
+	if timer.Time() != 5*time.Second/60 {
+		t.Errorf("Run took %v", timer.Time())
+	}
+
+	var buf bytes.Buffer
+
+	if err = timer.WriteLSS(&buf); err != nil {
+		t.Fatalf("Error writing LiveSplit file: %v", err)
+	}
+
+	var run lssRun
+
+	if err = xml.Unmarshal(buf.Bytes(), &run); err != nil {
+		t.Fatalf("Error reading LiveSplit file: %v", err)
+	}
+
+	if run.GameName != "Test" || run.AttemptCount != 2 || len(run.Segments) != 2 {
+		t.Fatalf("LiveSplit file is %+v", run)
+	}
+
+	if s := run.Segments[1].SplitTimes[0].GameTime; s != "00:00:00.0833333" {
+		t.Errorf("Personal best is %v", s)
+	}
+
+	if s := run.Segments[1].BestSegmentTime.GameTime; s != "00:00:00.0500000" {
+		t.Errorf("Best second segment is %v", s)
+	}
+
+	if n := len(run.Segments[0].SegmentHistory); n != 2 {
+		t.Errorf("First segment run %v times", n)
+	}
+
+	// runs timed later are added to the file, a slower one keeping the
+	// personal best and a faster second segment its best time
+	later, _ := ParseSplits(splitsTestFile, 60)
+	later.previous = &run
+
+	for _, values := range [][2]uint8{
+		{0, 0}, {1, 0}, {1, 0}, {1, 0}, {1, 0}, {1, 0}, {1, 1}, {1, 2},
+	} {
+		ram[0], ram[1] = values[0], values[1]
+		later.frame(fetch)
+	}
+
+	buf.Reset()
+
+	if err = later.WriteLSS(&buf); err != nil {
+		t.Fatalf("Error writing LiveSplit file: %v", err)
+	}
+
+	run = lssRun{}
+
+	if err = xml.Unmarshal(buf.Bytes(), &run); err != nil {
+		t.Fatalf("Error reading LiveSplit file: %v", err)
+	}
+
+	if run.AttemptCount != 3 || len(run.AttemptHistory) != 3 || run.AttemptHistory[2].ID != 3 {
+		t.Fatalf("LiveSplit file has attempts %+v", run.AttemptHistory)
+	}
+
+	if s := run.Segments[1].SplitTimes[0].GameTime; s != "00:00:00.0833333" {
+		t.Errorf("Personal best is %v", s)
+	}
+
+	if s := run.Segments[1].BestSegmentTime.GameTime; s != "00:00:00.0166666" {
+		t.Errorf("Best second segment is %v", s)
+	}
+
+	if h := run.Segments[1].SegmentHistory; len(h) != 2 || h[1].ID != 3 {
+		t.Errorf("Second segment history is %+v", h)
+	}
+}
+
+func TestSplitTimerSkip(t *testing.T) {
+	timer, err := ParseSplits(splitsTestFile, 60)
+
+	if err != nil {
+		t.Fatalf("Error parsing splits: %v", err)
+	}
+
+	var ram [0x800]uint8
+	fetch := func(address uint16) uint8 { return ram[address] }
+
+	timer.frame(fetch)
+
+	// a state loaded with $0000 set does not start the timer
+	nes := newTestNES(t, &Options{Region: "NTSC"})
+	nes.timer = timer
+
+	var buf bytes.Buffer
+
+	if err = nes.SaveStateToWriter(&buf); err != nil {
+		t.Fatalf("Error saving state: %v", err)
+	}
+
+	if err = nes.LoadStateFromReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatalf("Error loading state: %v", err)
+	}
+
+	ram[0] = 1
+	timer.frame(fetch)
+
+	if timer.Running {
+		t.Error("Loading a state started the timer")
+	}
+
+	// nor does a reset, which still counts as a frame of a run
+	ram[0] = 0
+	timer.frame(fetch)
+	ram[0] = 1
+	timer.frame(fetch)
+
+	if !timer.Running {
+		t.Fatal("Timer did not start")
+	}
+
+	nes.Reset()
+	ram[1] = 1
+	timer.frame(fetch)
+
+	if len(timer.current.Frames) != 0 || timer.Frame != 1 {
+		t.Errorf("Reset split at frames %v with the run at frame %v", timer.current.Frames, timer.Frame)
+	}
+}
@@ -0,0 +1,418 @@
+package nes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// The number of most recent frames the greenzone keeps the state
+	// of every frame for.  Before them it keeps every
+	// TASKeyframeInterval'th frame.
+	TASGreenzoneFrames  = 1800
+	TASKeyframeInterval = 60
+
+	TASBookmarks = 10
+
+	// The number of frames with rendering switched off a seek runs
+	// through before giving up.
+	TASMaxBlankFrames = 3600
+)
+
+// A branch of the movie saved by a bookmark: the input of every frame
+// and the state at Frame.
+type TASBookmark struct {
+	Frame  int
+	Frames []MovieFrame
+	state  *nesSnapshot
+}
+
+// A piano roll style editor for the movie being played.  The state at
+// the start of every frame played is kept in the greenzone, so that
+// seeking to any frame played before only loads its state, while
+// seeking further runs the frames in between without showing them.
+// Changing the input of a frame drops the states of the frames after
+// it, and of the frame itself if its commands change.  Bookmarks save
+// the current frame along with the whole movie, so that loading one
+// goes back to that branch of the movie.
+type TASEditor struct {
+	nes       *NES
+	session   *MovieSession
+	greenzone []*nesSnapshot
+	stale     bool // the current state depends on input which changed
+	bookmarks [TASBookmarks]*TASBookmark
+}
+
+func NewTASEditor(nes *NES) (tas *TASEditor, err error) {
+	if nes.movie == nil {
+		err = errors.New("The TAS editor needs a movie, given by -play-movie or -record-movie")
+		return
+	}
+
+	nes.movie.extend = true
+
+	tas = &TASEditor{
+		nes:     nes,
+		session: nes.movie,
+	}
+
+	return
+}
+
+// Starts editing, after the movie has started.
+func (tas *TASEditor) start() {
+	tas.session.mode = moviePlaying
+	tas.session.ReadOnly = true
+
+	tas.capture()
+
+	fmt.Println("*** Editing movie", tas.session.filename)
+}
+
+// Saves the state at the start of the current frame if the greenzone
+// lacks it.  Called at the end of the instruction during which the
+// last frame ended.
+func (tas *TASEditor) capture() {
+	frame := tas.session.frame
+
+	if tas.session.mode != moviePlaying || (frame < len(tas.greenzone) && tas.greenzone[frame] != nil) {
+		return
+	}
+
+	state, err := tas.nes.snapshot()
+
+	if err != nil {
+		return
+	}
+
+	for len(tas.greenzone) <= frame {
+		tas.greenzone = append(tas.greenzone, nil)
+	}
+
+	tas.greenzone[frame] = state
+
+	if old := frame - TASGreenzoneFrames; old > 0 && old%TASKeyframeInterval != 0 {
+		tas.greenzone[old] = nil
+	}
+}
+
+// Drops the states of frame and the frames after it, except for the
+// state at power on.
+func (tas *TASEditor) invalidate(frame int) {
+	if frame < 1 {
+		frame = 1
+	}
+
+	if frame < len(tas.greenzone) {
+		tas.greenzone = tas.greenzone[:frame]
+	}
+
+	if tas.session.frame >= frame {
+		tas.stale = true
+	}
+}
+
+// Runs the movie from the state closest before frame up to frame.
+func (tas *TASEditor) seek(frame int) (err error) {
+	nes := tas.nes
+	session := tas.session
+
+	if frame < 0 || frame >= len(session.Movie.Frames) {
+		return fmt.Errorf("Invalid frame %v, the movie has %v", frame, len(session.Movie.Frames))
+	}
+
+	base := frame
+
+	for base > 0 && (base >= len(tas.greenzone) || tas.greenzone[base] == nil) {
+		base--
+	}
+
+	nes.quiet = true
+	defer func() { nes.quiet = false }()
+
+	if tas.stale || session.frame > frame || session.frame < base {
+		paused := nes.Paused
+
+		if err = nes.restore(tas.greenzone[base]); err != nil {
+			return
+		}
+
+		nes.Paused = paused
+		session.loadedState(nes)
+
+		if nes.timer != nil {
+			nes.timer.skip()
+		}
+		tas.stale = false
+	}
+
+	for blank := 0; session.frame < frame; {
+		start := session.frame
+
+		if err = nes.runFrame(); err != nil {
+			return
+		}
+
+		if session.frame == start {
+			if blank++; blank > TASMaxBlankFrames {
+				return fmt.Errorf("Rendering stayed off for %v frames after frame %v", blank, start)
+			}
+		}
+	}
+
+	return
+}
+
+// Called after the input of frame and the frames after it has
+// changed.  commands is true if the commands of frame changed.
+func (tas *TASEditor) changed(frame int, commands bool) (err error) {
+	session := tas.session
+	last := len(session.Movie.Frames) - 1
+
+	session.modified = true
+	session.Movie.truncateLag(frame)
+
+	if commands {
+		tas.invalidate(frame)
+	} else {
+		tas.invalidate(frame + 1)
+	}
+
+	switch {
+	case tas.stale || session.frame > last:
+		if frame > last {
+			frame = last
+		}
+
+		return tas.seek(frame)
+	case session.frame == frame:
+		for controller, buttons := range session.Movie.Frames[frame].Buttons {
+			tas.nes.controllers.SetButtons(controller, buttons)
+		}
+	}
+
+	return
+}
+
+// The current frame, the length of the movie, the number of frames
+// with a state in the greenzone and the number of lag frames so far.
+type TASStatus struct {
+	Frame     int   `json:"frame"`
+	Frames    int   `json:"frames"`
+	Greenzone int   `json:"greenzone"`
+	LagFrames int   `json:"lagFrames"`
+	Bookmarks []int `json:"bookmarks"`
+}
+
+func (tas *TASEditor) Status() (status TASStatus) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	status.Frame = tas.session.frame
+	status.Frames = len(tas.session.Movie.Frames)
+	status.LagFrames = tas.nes.LagFrames
+
+	for _, state := range tas.greenzone {
+		if state != nil {
+			status.Greenzone++
+		}
+	}
+
+	for _, bookmark := range tas.bookmarks {
+		frame := -1
+
+		if bookmark != nil {
+			frame = bookmark.Frame
+		}
+
+		status.Bookmarks = append(status.Bookmarks, frame)
+	}
+
+	return
+}
+
+// Returns the first and last frame, plus one, of count frames from
+// frame on, as far as the movie goes.
+func (tas *TASEditor) span(frame, count int) (first, last int) {
+	frames := len(tas.session.Movie.Frames)
+
+	if frame < 0 {
+		frame = 0
+	}
+
+	if frame > frames {
+		frame = frames
+	}
+
+	if count < 0 || frame+count > frames {
+		count = frames - frame
+	}
+
+	return frame, frame + count
+}
+
+// Returns the input of count frames from frame on.
+func (tas *TASEditor) Input(frame, count int) []MovieFrame {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	first, last := tas.span(frame, count)
+
+	return append([]MovieFrame{}, tas.session.Movie.Frames[first:last]...)
+}
+
+// Returns whether each of count frames from frame on was a lag frame
+// when last played.
+func (tas *TASEditor) Lag(frame, count int) (lag []bool) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	first, last := tas.span(frame, count)
+
+	for i := first; i < last; i++ {
+		lag = append(lag, tas.session.Movie.IsLag(i))
+	}
+
+	return
+}
+
+// Sets the input of frame, adding it to the end of the movie if frame
+// is the length of the movie.
+func (tas *TASEditor) SetInput(frame int, input MovieFrame) (err error) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	m := tas.session.Movie
+
+	switch {
+	case frame < 0 || frame > len(m.Frames):
+		return fmt.Errorf("Invalid frame %v, the movie has %v", frame, len(m.Frames))
+	case frame == len(m.Frames):
+		m.Frames = append(m.Frames, input)
+		return tas.changed(frame, true)
+	case m.Frames[frame] == input:
+		return
+	}
+
+	commands := m.Frames[frame].Commands != input.Commands
+	m.Frames[frame] = input
+
+	return tas.changed(frame, commands)
+}
+
+// Inserts count blank frames before frame.
+func (tas *TASEditor) Insert(frame, count int) (err error) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	m := tas.session.Movie
+
+	if frame < 1 || frame > len(m.Frames) || count < 1 {
+		return fmt.Errorf("Cannot insert %v frames at frame %v", count, frame)
+	}
+
+	m.Frames = append(m.Frames[:frame], append(make([]MovieFrame, count), m.Frames[frame:]...)...)
+
+	return tas.changed(frame, true)
+}
+
+// Deletes count frames from frame on.
+func (tas *TASEditor) Delete(frame, count int) (err error) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	m := tas.session.Movie
+
+	if frame < 1 || count < 1 || frame+count > len(m.Frames) {
+		return fmt.Errorf("Cannot delete %v frames from frame %v", count, frame)
+	}
+
+	m.Frames = append(m.Frames[:frame], m.Frames[frame+count:]...)
+
+	return tas.changed(frame, true)
+}
+
+// Moves to frame, which must be part of the movie.
+func (tas *TASEditor) Seek(frame int) (err error) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	return tas.seek(frame)
+}
+
+// Saves the current frame and the movie to a bookmark.
+func (tas *TASEditor) SetBookmark(slot int) (err error) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	if slot < 0 || slot >= TASBookmarks {
+		return fmt.Errorf("Invalid bookmark %v, must be 0-%v", slot, TASBookmarks-1)
+	}
+
+	// bookmarks keep the state at the start of the frame, as the
+	// greenzone does
+	if err = tas.seek(tas.session.frame); err != nil {
+		return
+	}
+
+	frame := tas.session.frame
+
+	if frame >= len(tas.greenzone) || tas.greenzone[frame] == nil {
+		return fmt.Errorf("There is no state for frame %v", frame)
+	}
+
+	tas.bookmarks[slot] = &TASBookmark{
+		Frame:  frame,
+		Frames: append([]MovieFrame{}, tas.session.Movie.Frames...),
+		state:  tas.greenzone[frame],
+	}
+
+	fmt.Printf("*** Bookmark %v set at frame %v\n", slot, tas.session.frame)
+
+	return
+}
+
+// Goes back to the branch of the movie saved by a bookmark.
+func (tas *TASEditor) LoadBookmark(slot int) (err error) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	if slot < 0 || slot >= TASBookmarks || tas.bookmarks[slot] == nil {
+		return fmt.Errorf("Bookmark %v is not set", slot)
+	}
+
+	bookmark := tas.bookmarks[slot]
+	m := tas.session.Movie
+
+	// the greenzone holds up to the first frame the branches differ
+	// in
+	diff := 0
+
+	for diff < len(m.Frames) && diff < len(bookmark.Frames) && m.Frames[diff] == bookmark.Frames[diff] {
+		diff++
+	}
+
+	m.Frames = append([]MovieFrame{}, bookmark.Frames...)
+	m.truncateLag(diff)
+	tas.session.modified = true
+	tas.invalidate(diff)
+
+	for len(tas.greenzone) <= bookmark.Frame {
+		tas.greenzone = append(tas.greenzone, nil)
+	}
+
+	tas.greenzone[bookmark.Frame] = bookmark.state
+
+	fmt.Printf("*** Loading bookmark %v at frame %v\n", slot, bookmark.Frame)
+
+	return tas.seek(bookmark.Frame)
+}
+
+// Writes the movie as an .fm2 file.
+func (tas *TASEditor) WriteFM2(writer io.Writer) (err error) {
+	lock := <-tas.nes.lock
+	defer func() { tas.nes.lock <- lock }()
+
+	return tas.session.Movie.WriteFM2(writer)
+}
@@ -0,0 +1,177 @@
+package nes
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTASEditor(t *testing.T) {
+	nes := newTestNES(t, &Options{Region: "NTSC"})
+
+	nes.movie = &MovieSession{Movie: testMovie(50), mode: moviePlaying}
+
+	tas, err := NewTASEditor(nes)
+
+	if err != nil {
+		t.Fatalf("Error creating TAS editor: %v", err)
+	}
+
+	nes.tas = tas
+	nes.movie.start(nes)
+	tas.start()
+
+	hashes := []uint64{nes.StateHash()}
+
+	for i := 0; i < 45; i++ {
+		if err = nes.runFrame(); err != nil {
+			t.Fatalf("Error running frame %v: %v", i, err)
+		}
+
+		hashes = append(hashes, nes.StateHash())
+	}
+
+	if status := tas.Status(); status.Frame != 45 || status.Greenzone != 46 {
+		t.Fatalf("Status is %+v after 45 frames", status)
+	}
+
+	if !bytes.Contains(tas.greenzone[45].state, []byte(`"ROMBanks":null`)) {
+		t.Error("The greenzone keeps a copy of the ROM")
+	}
+
+	// seeking loads states from the greenzone or runs up to the frame
+	for _, frame := range []int{10, 25, 3, 45, 0, 30} {
+		if err = tas.Seek(frame); err != nil {
+			t.Fatalf("Error seeking to frame %v: %v", frame, err)
+		}
+
+		if nes.MovieFrame != frame || nes.StateHash() != hashes[frame] {
+			t.Errorf("Seeking to frame %v reached frame %v with a different state", frame, nes.MovieFrame)
+		}
+	}
+
+	if err = tas.SetBookmark(1); err != nil {
+		t.Fatalf("Error setting bookmark: %v", err)
+	}
+
+	// changing input drops the greenzone after it and moves back to it
+	input := tas.Input(15, 1)[0]
+	input.Buttons[0] ^= 1 << Select
+
+	if err = tas.SetInput(15, input); err != nil {
+		t.Fatalf("Error setting input: %v", err)
+	}
+
+	if status := tas.Status(); status.Frame != 15 || status.Greenzone != 16 {
+		t.Errorf("Status is %+v after changing frame 15", status)
+	}
+
+	if err = tas.Seek(20); err != nil {
+		t.Fatalf("Error seeking to frame 20: %v", err)
+	}
+
+	if nes.StateHash() == hashes[20] {
+		t.Error("Changing input did not change the state")
+	}
+
+	// running past the end adds blank frames
+	if err = tas.Seek(49); err != nil {
+		t.Fatalf("Error seeking to frame 49: %v", err)
+	}
+
+	if err = nes.runFrame(); err != nil {
+		t.Fatalf("Error running frame: %v", err)
+	}
+
+	if status := tas.Status(); status.Frames != 51 {
+		t.Errorf("Movie has %v frames after running past its end", status.Frames)
+	}
+
+	if err = tas.LoadBookmark(1); err != nil {
+		t.Fatalf("Error loading bookmark: %v", err)
+	}
+
+	if nes.MovieFrame != 30 || nes.StateHash() != hashes[30] || tas.Input(15, 1)[0] == input {
+		t.Error("Loading the bookmark did not go back to its branch")
+	}
+
+	if err = tas.Seek(40); err != nil || nes.StateHash() != hashes[40] {
+		t.Errorf("Seeking to frame 40 of the bookmark's branch reached a different state: %v", err)
+	}
+
+	if err = tas.Insert(5, 3); err != nil || len(tas.Input(0, -1)) != 53 || nes.MovieFrame != 5 {
+		t.Errorf("Inserting frames left %v frames at frame %v: %v", len(tas.Input(0, -1)), nes.MovieFrame, err)
+	}
+
+	if err = tas.Delete(5, 3); err != nil || len(tas.Input(0, -1)) != 50 {
+		t.Errorf("Deleting frames left %v frames: %v", len(tas.Input(0, -1)), err)
+	}
+
+	if err = tas.Seek(40); err != nil || nes.StateHash() != hashes[40] {
+		t.Errorf("Seeking to frame 40 after inserting and deleting reached a different state: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err = tas.WriteFM2(&buf); err != nil {
+		t.Fatalf("Error writing movie: %v", err)
+	}
+
+	if m, err := ReadFM2(&buf); err != nil || len(m.Frames) != 50 {
+		t.Errorf("Error reading the written movie: %v", err)
+	}
+}
+
+func TestTASEditorInput(t *testing.T) {
+	nes := newTestNES(t, &Options{Region: "NTSC", Port2: "famicom"})
+
+	nes.movie = &MovieSession{Movie: testMovie(40), mode: moviePlaying}
+
+	tas, err := NewTASEditor(nes)
+
+	if err != nil {
+		t.Fatalf("Error creating TAS editor: %v", err)
+	}
+
+	// TurboA held down and a WAV file played to the microphone, loud
+	// every third frame
+	fc := nes.controllers.Device(Port2).(*FamicomController)
+	levels := make([]float64, 50)
+
+	for i := range levels {
+		if i%3 == 0 {
+			levels[i] = 1
+		}
+	}
+
+	fc.setLevels(levels)
+	nes.controllers.KeyDown(0, TurboA)
+
+	nes.movie.start(nes)
+	tas.start()
+
+	hashes, heard := []uint64{nes.StateHash()}, []bool{fc.Microphone() != 0}
+
+	for i := 0; i < 30; i++ {
+		if err = nes.runFrame(); err != nil {
+			t.Fatalf("Error running frame %v: %v", i, err)
+		}
+
+		hashes = append(hashes, nes.StateHash())
+		heard = append(heard, fc.Microphone() != 0)
+	}
+
+	// seeking back and playing the frames again goes the same way
+	if err = tas.Seek(10); err != nil {
+		t.Fatalf("Error seeking to frame 10: %v", err)
+	}
+
+	for frame := 10; frame <= 30; frame++ {
+		if nes.StateHash() != hashes[frame] || (fc.Microphone() != 0) != heard[frame] {
+			t.Errorf("Playing frame %v again reached a different state", frame)
+		}
+
+		if err = nes.runFrame(); err != nil {
+			t.Fatalf("Error running frame %v: %v", frame, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package nes
+
+import "fmt"
+
+// The default number of frames turbo buttons hold A or B down and then
+// let it up for, firing 15 times a second at 60 frames per second.
+const (
+	DefaultTurboOn  = 2
+	DefaultTurboOff = 2
+)
+
+// Autofire for the A and B buttons of each controller.  While TurboA or
+// TurboB is held down the button is pressed for On frames and released
+// for Off frames, counted by Frame.  The rate and the count are part of
+// the NES's saved state so that a netplay slave, which starts from the
+// master's state, fires on exactly the same frames.
+type Turbo struct {
+	On    int
+	Off   int
+	Frame int
+	turbo [4]uint8 // the turbo buttons held down, as 1<<A and 1<<B
+	held  [4]uint8 // A and B held down by themselves
+}
+
+func NewTurbo(on, off int) (turbo *Turbo, err error) {
+	if on == 0 {
+		on = DefaultTurboOn
+	}
+
+	if off == 0 {
+		off = DefaultTurboOff
+	}
+
+	if on < 0 || off < 0 {
+		err = fmt.Errorf("Invalid turbo rate of %v frames on and %v off", on, off)
+		return
+	}
+
+	turbo = &Turbo{
+		On:  on,
+		Off: off,
+	}
+
+	return
+}
+
+// Returns true while turbo buttons press their button.
+func (turbo *Turbo) Firing() bool {
+	return turbo.Frame < turbo.On
+}
+
+// Returns the button a turbo button fires, and true if btn is a turbo
+// button.
+func turboButton(btn Button) (Button, bool) {
+	switch btn {
+	case TurboA:
+		return A, true
+	case TurboB:
+		return B, true
+	}
+
+	return btn, false
+}
+
+// Returns true if btn is held down on controller, by itself or by its
+// turbo button while it fires.
+func (turbo *Turbo) down(controller int, btn Button) bool {
+	mask := uint8(1) << btn
+
+	return turbo.held[controller]&mask != 0 || (turbo.turbo[controller]&mask != 0 && turbo.Firing())
+}
+
+// Records btn being pressed or released on controller and returns the
+// button to press or release on the controller itself, or One if
+// nothing changes.
+func (turbo *Turbo) press(controller int, btn Button, down bool) Button {
+	if controller < 0 || controller > 3 {
+		return btn
+	}
+
+	fired, isTurbo := turboButton(btn)
+
+	if fired != A && fired != B {
+		return btn
+	}
+
+	before := turbo.down(controller, fired)
+
+	buttons := &turbo.held[controller]
+
+	if isTurbo {
+		buttons = &turbo.turbo[controller]
+	}
+
+	if down {
+		*buttons |= 1 << fired
+	} else {
+		*buttons &^= 1 << fired
+	}
+
+	if turbo.down(controller, fired) == before {
+		return One
+	}
+
+	return fired
+}
+
+// Advances to the next frame, calling set for every button whose turbo
+// button presses or releases it this frame.
+func (turbo *Turbo) step(set func(controller int, btn Button, down bool)) {
+	firing := turbo.Firing()
+
+	if turbo.Frame++; turbo.Frame >= turbo.On+turbo.Off {
+		turbo.Frame = 0
+	}
+
+	if turbo.Firing() == firing {
+		return
+	}
+
+	for controller := range turbo.turbo {
+		for _, btn := range []Button{A, B} {
+			mask := uint8(1) << btn
+
+			if turbo.turbo[controller]&mask != 0 && turbo.held[controller]&mask == 0 {
+				set(controller, btn, !firing)
+			}
+		}
+	}
+}
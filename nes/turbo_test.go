@@ -0,0 +1,63 @@
+package nes
+
+import "testing"
+
+func TestTurbo(t *testing.T) {
+	ctrls := NewControllers()
+
+	if ctrls.turbo, _ = NewTurbo(1, 2); ctrls.turbo == nil {
+		t.Fatal("Error creating turbo")
+	}
+
+	ctrl := ctrls.Device(Port1).(*Controller)
+
+	ctrls.KeyDown(0, TurboA)
+
+	var pressed []bool
+
+	for i := 0; i < 6; i++ {
+		pressed = append(pressed, ctrl.KeyIsDown(A))
+		ctrls.Frame()
+	}
+
+	for i, expected := range []bool{true, false, false, true, false, false} {
+		if pressed[i] != expected {
+			t.Errorf("A down on frame %v is %v not %v", i, pressed[i], expected)
+		}
+	}
+
+	// A held by itself stays down while turbo A fires
+	ctrls.KeyDown(0, A)
+
+	for i := 0; i < 3; i++ {
+		if ctrls.Frame(); !ctrl.KeyIsDown(A) {
+			t.Errorf("A is up on frame %v while held", i)
+		}
+	}
+
+	ctrls.KeyUp(0, A)
+	ctrls.KeyUp(0, TurboA)
+
+	for i := 0; i < 3; i++ {
+		if ctrls.Frame(); ctrl.KeyIsDown(A) {
+			t.Errorf("A is down on frame %v after turbo A is let up", i)
+		}
+	}
+
+	// turbo B on player 2 leaves player 1 alone
+	ctrls.KeyDown(1, TurboB)
+
+	if ctrl.KeyIsDown(B) || !ctrls.Device(Port2).(*Controller).KeyIsDown(B) {
+		t.Error("Turbo B for player 2 did not press B on player 2 only")
+	}
+}
+
+func TestTurboRate(t *testing.T) {
+	if turbo, err := NewTurbo(0, 0); err != nil || turbo.On != DefaultTurboOn || turbo.Off != DefaultTurboOff {
+		t.Errorf("Turbo is %+v, %v not the default rate", turbo, err)
+	}
+
+	if _, err := NewTurbo(-1, 2); err == nil {
+		t.Error("No error creating turbo with a negative rate")
+	}
+}
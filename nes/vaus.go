@@ -0,0 +1,162 @@
+package nes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// The potentiometer counts at the ends of the knob's travel on a
+	// factory calibrated controller.
+	DefaultVausMin = 0x62
+	DefaultVausMax = 0xf2
+)
+
+// The Arkanoid Vaus paddle controller.  The NES version is plugged
+// into a controller port and sends its button on bit 3 and the
+// position of its knob on bit 4 of that port's register, the Famicom
+// version is plugged into the expansion port and sends its button on
+// bit 1 of $4016 and its position on bit 1 of $4017.  The position is
+// sent inverted, one bit per read, most significant bit first.
+type Vaus struct {
+	famicom bool
+	latch   uint8
+	shift   uint8
+
+	// The knob turns by Sensitivity times the calibrated range as
+	// the mouse moves across the width of the picture.
+	Sensitivity float64
+	Min, Max    uint8
+	Position    uint8
+	Button      bool
+}
+
+func NewVaus(famicom bool) *Vaus {
+	return &Vaus{
+		famicom:     famicom,
+		Sensitivity: 1.0,
+		Min:         DefaultVausMin,
+		Max:         DefaultVausMax,
+		Position:    DefaultVausMin + (DefaultVausMax-DefaultVausMin)/2,
+	}
+}
+
+// Returns a Vaus with the sensitivity and calibrated range given in
+// options, which may be nil.
+func vausFromOptions(options *Options, famicom bool) (vaus *Vaus, err error) {
+	vaus = NewVaus(famicom)
+
+	if options == nil {
+		return
+	}
+
+	if options.VausRange != "" {
+		if vaus.Min, vaus.Max, err = ParseVausRange(options.VausRange); err != nil {
+			return
+		}
+
+		vaus.Position = vaus.Min + (vaus.Max-vaus.Min)/2
+	}
+
+	if options.VausSensitivity < 0 {
+		err = fmt.Errorf("Invalid Vaus sensitivity %v", options.VausSensitivity)
+		return
+	}
+
+	if options.VausSensitivity != 0 {
+		vaus.Sensitivity = options.VausSensitivity
+	}
+
+	return
+}
+
+// Parses a calibrated range given as two potentiometer counts, e.g.
+// '98-242' or '0x62-0xf2'.
+func ParseVausRange(s string) (min, max uint8, err error) {
+	counts := strings.Split(s, "-")
+
+	if len(counts) == 2 {
+		lo, err1 := strconv.ParseUint(strings.TrimSpace(counts[0]), 0, 8)
+		hi, err2 := strconv.ParseUint(strings.TrimSpace(counts[1]), 0, 8)
+
+		if err1 == nil && err2 == nil && lo < hi {
+			return uint8(lo), uint8(hi), nil
+		}
+	}
+
+	err = fmt.Errorf("Invalid Vaus range %v", s)
+
+	return
+}
+
+func (vaus *Vaus) Reset() {
+	vaus.latch = 0
+	vaus.shift = 0
+	vaus.Button = false
+}
+
+func (vaus *Vaus) save() func() {
+	saved := *vaus
+	return func() { *vaus = saved }
+}
+
+// The knob's position is sampled into the shift register while the
+// strobe is high.
+func (vaus *Vaus) Strobe(value uint8) {
+	vaus.latch = value & 0x01
+
+	if vaus.latch == 1 {
+		vaus.shift = vaus.Position
+	}
+}
+
+func (vaus *Vaus) Read(address uint16) (value uint8) {
+	var button uint8
+
+	if vaus.Button {
+		button = 1
+	}
+
+	if vaus.famicom && address == 0x4016 {
+		return button << 1
+	}
+
+	bit := (^vaus.shift >> 7) & 0x01
+
+	if vaus.latch == 0 {
+		vaus.shift <<= 1
+	}
+
+	if vaus.famicom {
+		return bit << 1
+	}
+
+	return button<<3 | bit<<4
+}
+
+func (vaus *Vaus) KeyDown(btn Button) {}
+
+func (vaus *Vaus) KeyUp(btn Button) {}
+
+// The knob follows the mouse across the picture, centered when the
+// mouse is in the middle, and the left button is the Vaus' button.
+func (vaus *Vaus) Mouse(x, y int, left, right bool) {
+	vaus.Button = left
+
+	if x < 0 {
+		return
+	}
+
+	span := float64(vaus.Max - vaus.Min)
+	position := float64(vaus.Min) + span/2 + float64(x-128)*vaus.Sensitivity*span/256
+
+	switch {
+	case position < float64(vaus.Min):
+		position = float64(vaus.Min)
+	case position > float64(vaus.Max):
+		position = float64(vaus.Max)
+	}
+
+	vaus.Position = uint8(position)
+}
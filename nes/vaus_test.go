@@ -0,0 +1,104 @@
+package nes
+
+import "testing"
+
+// Reads 8 bits from each register the way the vaus-test ROM does,
+// returning the bits seen on D1, D3 and D4 of $4016 and $4017.
+func readVaus(ctrls *Controllers) (d1, d3, d4 [2]uint8) {
+	ctrls.Store(0x4016, 1)
+	ctrls.Store(0x4016, 0)
+
+	for i := 0; i < 8; i++ {
+		for x := 0; x < 2; x++ {
+			value := ctrls.Fetch(0x4016 + uint16(x))
+
+			d1[x] = d1[x]<<1 | (value>>1)&0x01
+			d3[x] = d3[x]<<1 | (value>>3)&0x01
+			d4[x] = d4[x]<<1 | (value>>4)&0x01
+		}
+	}
+
+	return
+}
+
+func TestVaus(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Plug(Port2, "vaus"); err != nil {
+		t.Fatalf("Error plugging in Vaus: %v", err)
+	}
+
+	vaus := ctrls.Device(Port2).(*Vaus)
+
+	for _, test := range []struct {
+		x        int
+		left     bool
+		position uint8
+	}{
+		{128, false, 0xaa},
+		{0, true, 0x62},
+		{255, false, 0xf1},
+		{-1, false, 0xf1},
+	} {
+		ctrls.Mouse(test.x, 100, test.left, false)
+
+		if vaus.Position != test.position {
+			t.Errorf("Position with mouse at %v is $%02X not $%02X", test.x, vaus.Position, test.position)
+		}
+
+		_, d3, d4 := readVaus(ctrls)
+
+		if d4[1] != ^test.position {
+			t.Errorf("Position read is $%02X not $%02X", d4[1], ^test.position)
+		}
+
+		if (d3[1] == 0xff) != test.left {
+			t.Errorf("Button read is $%02X with button down %v", d3[1], test.left)
+		}
+	}
+
+	vaus.Sensitivity = 2.0
+	ctrls.Mouse(64, 100, false, false)
+
+	if vaus.Position != 0x62 {
+		t.Errorf("Position with sensitivity 2 is $%02X not $62", vaus.Position)
+	}
+}
+
+func TestFamicomVaus(t *testing.T) {
+	ctrls := NewControllers()
+
+	if err := ctrls.Configure(nil, &Options{Expansion: "vaus", VausRange: "0x54-0xf4"}); err != nil {
+		t.Fatalf("Error plugging in Vaus: %v", err)
+	}
+
+	vaus := ctrls.Device(ExpansionPort).(*Vaus)
+
+	if vaus.Min != 0x54 || vaus.Max != 0xf4 || vaus.Position != 0xa4 {
+		t.Errorf("Vaus range is $%02X-$%02X, position $%02X", vaus.Min, vaus.Max, vaus.Position)
+	}
+
+	ctrls.Mouse(128, 100, true, false)
+
+	d1, _, _ := readVaus(ctrls)
+
+	if d1[0] != 0xff {
+		t.Errorf("Button read is $%02X not $FF", d1[0])
+	}
+
+	if d1[1] != ^uint8(0xa4) {
+		t.Errorf("Position read is $%02X not $%02X", d1[1], ^uint8(0xa4))
+	}
+}
+
+func TestParseVausRange(t *testing.T) {
+	if min, max, err := ParseVausRange("98-242"); err != nil || min != 0x62 || max != 0xf2 {
+		t.Errorf("ParseVausRange is %v, %v, %v", min, max, err)
+	}
+
+	for _, s := range []string{"242-98", "98", "98-300", "low-high"} {
+		if _, _, err := ParseVausRange(s); err == nil {
+			t.Errorf("No error parsing invalid Vaus range %q", s)
+		}
+	}
+}
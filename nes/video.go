@@ -15,6 +15,7 @@ type Video interface {
 	Events() chan Event
 	Run()
 	SetCaption(caption string)
+	SetPalette(palette []color.Color)
 }
 
 var RGBAPalette []color.Color = []color.Color{
@@ -86,6 +87,7 @@ var RGBAPalette []color.Color = []color.Color{
 
 type Recorder interface {
 	Input() chan []uint8
+	SetPalette(palette []color.Color)
 	Record()
 	Stop()
 	Quit()
@@ -115,6 +117,10 @@ func (video *JPEGRecorder) Input() chan []uint8 {
 	return video.input
 }
 
+func (video *JPEGRecorder) SetPalette(palette []color.Color) {
+	video.palette = palette
+}
+
 func (video *JPEGRecorder) Record() {
 	if video.frame != nil {
 		fo, _ := os.Create(fmt.Sprintf("frame.jpg"))
@@ -187,6 +193,10 @@ func (video *GIFRecorder) Input() chan []uint8 {
 	return video.input
 }
 
+func (video *GIFRecorder) SetPalette(palette []color.Color) {
+	video.palette = palette
+}
+
 func (video *GIFRecorder) Record() {
 	fmt.Println("*** Recording started")
 
@@ -250,3 +260,27 @@ func (video *GIFRecorder) Run() {
 func pixelInFrame(x, y int, overscan bool) bool {
 	return !overscan || (y >= 8 && y <= 231 && x >= 8 && x <= 247)
 }
+
+// Returns the part of the frame shown with or without overscan.
+func visibleFrame(overscan bool) image.Rectangle {
+	if overscan {
+		return image.Rect(8, 8, 248, 232)
+	}
+
+	return image.Rect(0, 0, 256, 240)
+}
+
+// Converts a position x, y within a picture of width by height pixels
+// showing the visible part of the frame to NES pixel coordinates, or
+// -1, -1 if the position is outside the picture.  This is how mouse
+// positions are made independent of the window's size.
+func framePosition(x, y, width, height int, visible image.Rectangle) (fx, fy int) {
+	if x < 0 || y < 0 || x >= width || y >= height {
+		return -1, -1
+	}
+
+	fx = visible.Min.X + x*visible.Dx()/width
+	fy = visible.Min.Y + y*visible.Dy()/height
+
+	return
+}
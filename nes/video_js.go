@@ -3,6 +3,8 @@
 package nes
 
 import (
+	"image"
+	"image/color"
 	"reflect"
 	"strconv"
 	"sync"
@@ -28,17 +30,21 @@ var JSPalette []uint = []uint{
 
 type JSVideo struct {
 	input         chan []uint8
+	palette       []uint
 	events        chan Event
 	framePool     *sync.Pool
 	canvas        js.Value
 	width, height int
 	overscan      bool
+	bindings      *KeyBindings
 }
 
-func NewVideo(caption string, events chan Event, framePool *sync.Pool, fps float64) (video *JSVideo, err error) {
+func NewVideo(caption string, events chan Event, bindings *KeyBindings, framePool *sync.Pool, fps float64) (video *JSVideo, err error) {
 	video = &JSVideo{
 		input:     make(chan []uint8),
+		palette:   JSPalette,
 		events:    events,
+		bindings:  bindings,
 		framePool: framePool,
 		overscan:  true,
 		width:     256,
@@ -64,82 +70,76 @@ func (video *JSVideo) SetCaption(caption string) {
 	}
 }
 
-func button(keyCode int) Button {
-	switch keyCode {
-	case 37:
-		return Left
-	case 38:
-		return Up
-	case 39:
-		return Right
-	case 40:
-		return Down
-	case 90:
-		return A
-	case 88:
-		return B
-	case 13:
-		return Start
-	case 16:
-		return Select
-	default:
-		return One
+func (video *JSVideo) SetPalette(palette []color.Color) {
+	video.palette = make([]uint, len(palette))
+
+	for i, c := range palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		video.palette[i] = 0xff000000 | uint(rgba.B)<<16 | uint(rgba.G)<<8 | uint(rgba.R)
 	}
 }
 
-func (video *JSVideo) handleKey(code int, down bool) {
-	var event Event
+// The names keys other than letters and digits are bound by in
+// ~/.nintengorc, by keyCode.
+var jsKeyNames = map[int]string{
+	37: "left", 38: "up", 39: "right", 40: "down",
+	13: "enter", 32: "space", 9: "tab", 8: "backspace", 27: "escape",
+	45: "insert", 46: "delete", 36: "home", 35: "end", 33: "pageup", 34: "pagedown",
+	192: "backquote", 189: "minus", 173: "minus", 187: "equals", 61: "equals",
+	219: "leftbracket", 221: "rightbracket", 220: "backslash",
+	186: "semicolon", 59: "semicolon", 222: "quote",
+	188: "comma", 190: "period", 191: "slash",
+	106: "kpmultiply", 107: "kpplus", 109: "kpminus", 110: "kpperiod", 111: "kpdivide",
+}
+
+// Returns the name the key with keyCode at location (as in
+// KeyboardEvent.location) is bound by.
+func jsKeyName(keyCode, location int) string {
+	switch {
+	case keyCode >= 65 && keyCode <= 90: // a-z
+		return string(rune('a' + keyCode - 65))
+	case keyCode >= 48 && keyCode <= 57: // 0-9
+		return strconv.Itoa(keyCode - 48)
+	case keyCode >= 96 && keyCode <= 105: // numeric keypad 0-9
+		return "kp" + strconv.Itoa(keyCode-96)
+	case keyCode >= 112 && keyCode <= 123: // F1-F12
+		return "f" + strconv.Itoa(keyCode-111)
+	case keyCode == 13 && location == 3:
+		return "kpenter"
+	case keyCode >= 16 && keyCode <= 18: // shift, ctrl, alt
+		side := "r"
+
+		if location == 1 {
+			side = "l"
+		}
+
+		return side + [...]string{"shift", "ctrl", "alt"}[keyCode-16]
+	}
+
+	return jsKeyNames[keyCode]
+}
 
+func (video *JSVideo) handleKey(code, location int, down bool) {
 	setSize := func(width, height int) {
 		video.canvas.Get("style").Set("width", strconv.Itoa(width)+"px")
 		video.canvas.Get("style").Set("height", strconv.Itoa(height)+"px")
 	}
 
-	if down {
-		switch code {
-		case 192: // backtick `
-			video.overscan = !video.overscan
-		case 82: // r
-			event = &ResetEvent{}
-		case 80: // p
-			event = &PauseEvent{}
-		case 49: // 1
-			setSize(256, 240)
-		case 50: // 2
-			setSize(512, 480)
-		case 51: // 3
-			setSize(768, 720)
-		case 52: // 4
-			setSize(1024, 960)
-		case 53: // 5
-			setSize(2560, 1440)
-		case 57: // 9
-			event = &ShowBackgroundEvent{}
-		case 48: // 0
-			event = &ShowSpritesEvent{}
-		case 96: // NP-0
-			event = &MuteEvent{}
-		case 97: // NP-1
-			event = &MutePulse1Event{}
-		case 98: // NP-2
-			event = &MutePulse2Event{}
-		case 99: // NP-3
-			event = &MuteTriangleEvent{}
-		case 100: // NP-4
-			event = &MuteNoiseEvent{}
-		case 101: // NP-5
-			event = &MuteDMCEvent{}
-		}
-	}
-
-	if event == nil {
-		button := button(code)
-		if button != One {
-			event = &ControllerEvent{
-				Button: button,
-				Down:   down,
-			}
-		}
+	event, action := video.bindings.Handle(jsKeyName(code, location), down)
+
+	switch action {
+	case "overscan":
+		video.overscan = !video.overscan
+	case "size-1":
+		setSize(256, 240)
+	case "size-2":
+		setSize(512, 480)
+	case "size-3":
+		setSize(768, 720)
+	case "size-4":
+		setSize(1024, 960)
+	case "size-5":
+		setSize(2560, 1440)
 	}
 
 	if event != nil {
@@ -147,6 +147,21 @@ func (video *JSVideo) handleKey(code int, down bool) {
 	}
 }
 
+// Sends a MouseEvent for the mouse at x, y in the canvas.  The
+// overscan border is drawn over the picture so the whole frame is
+// always shown.
+func (video *JSVideo) handleMouse(x, y, buttons int) {
+	x, y = framePosition(x, y, video.canvas.Get("clientWidth").Int(),
+		video.canvas.Get("clientHeight").Int(), image.Rect(0, 0, 256, 240))
+
+	video.events <- &MouseEvent{
+		X:     x,
+		Y:     y,
+		Left:  buttons&0x01 != 0,
+		Right: buttons&0x02 != 0,
+	}
+}
+
 func (video *JSVideo) Run() {
 	imgWidth, imgHeight := 256, 240
 
@@ -155,7 +170,7 @@ func (video *JSVideo) Run() {
 	onkeydownCallback := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 		go func() {
 			e := args[0]
-			video.handleKey(e.Get("keyCode").Int(), true)
+			video.handleKey(e.Get("keyCode").Int(), e.Get("location").Int(), true)
 		}()
 		return nil
 	})
@@ -165,7 +180,7 @@ func (video *JSVideo) Run() {
 	onkeyupCallback := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 		go func() {
 			e := args[0]
-			video.handleKey(e.Get("keyCode").Int(), false)
+			video.handleKey(e.Get("keyCode").Int(), e.Get("location").Int(), false)
 		}()
 		return nil
 	})
@@ -181,6 +196,19 @@ func (video *JSVideo) Run() {
 
 	video.canvas = canvas
 
+	onmouseCallback := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		e := args[0]
+		e.Call("preventDefault")
+
+		go video.handleMouse(e.Get("offsetX").Int(), e.Get("offsetY").Int(), e.Get("buttons").Int())
+		return nil
+	})
+	defer onmouseCallback.Release()
+	canvas.Set("onmousemove", onmouseCallback)
+	canvas.Set("onmousedown", onmouseCallback)
+	canvas.Set("onmouseup", onmouseCallback)
+	canvas.Set("oncontextmenu", onmouseCallback)
+
 	ctx := canvas.Call("getContext", "2d")
 	img := ctx.Call("getImageData", 0, 0, imgWidth, imgHeight)
 
@@ -211,7 +239,7 @@ func (video *JSVideo) Run() {
 		colors := <-video.input
 
 		for i, c := range colors {
-			buf32[i] = uint32(JSPalette[c])
+			buf32[i] = uint32(video.palette[c])
 		}
 		video.framePool.Put(colors)
 
@@ -0,0 +1,238 @@
+package nes
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/nwidger/nintengo/rp2ago3"
+)
+
+// The RGB palette of the RC2C03 used by Vs. System and PlayChoice-10
+// boards as 3-bit red, green and blue levels.  The unused entries
+// $xD-$xF hold the extra colors found only in the RP2C04 palettes so
+// that every Vs. palette can be expressed as a lookup into this one.
+var vsRGB = []uint16{
+	0333, 0014, 0006, 0326, 0403, 0503, 0510, 0420,
+	0320, 0120, 0031, 0040, 0022, 0111, 0003, 0020,
+	0555, 0036, 0027, 0407, 0507, 0704, 0700, 0630,
+	0430, 0140, 0040, 0053, 0044, 0222, 0200, 0310,
+	0777, 0357, 0447, 0637, 0707, 0737, 0740, 0750,
+	0660, 0360, 0070, 0276, 0077, 0444, 0000, 0000,
+	0777, 0567, 0657, 0757, 0747, 0755, 0764, 0770,
+	0773, 0572, 0473, 0276, 0467, 0666, 0653, 0760,
+}
+
+// Maps each palette index written by a game to an entry of vsRGB for
+// each of the Vs. System PPUs.  The RP2C04 palette ROMs are scrambled
+// differently on each revision so that games only run on the PPU they
+// were released with.
+var vsPPUs = map[string][]uint8{
+	"RC2C03": {
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x2e, 0x2e, 0x2e,
+		0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x2e, 0x2e, 0x2e,
+		0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29, 0x2a, 0x2b, 0x2c, 0x2e, 0x2e, 0x2e,
+		0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x3b, 0x3c, 0x2e, 0x2e, 0x2e,
+	},
+	"RP2C04-0001": {
+		0x35, 0x23, 0x16, 0x22, 0x1c, 0x09, 0x1d, 0x15, 0x20, 0x00, 0x27, 0x05, 0x04, 0x28, 0x08, 0x20,
+		0x21, 0x3e, 0x1f, 0x29, 0x3c, 0x32, 0x36, 0x12, 0x3f, 0x2b, 0x2e, 0x1e, 0x3d, 0x2d, 0x24, 0x01,
+		0x0e, 0x31, 0x33, 0x2a, 0x2c, 0x0c, 0x1b, 0x14, 0x2e, 0x07, 0x34, 0x06, 0x13, 0x02, 0x26, 0x2e,
+		0x2e, 0x19, 0x10, 0x0a, 0x39, 0x03, 0x37, 0x17, 0x0f, 0x11, 0x0b, 0x0d, 0x38, 0x25, 0x18, 0x3a,
+	},
+	"RP2C04-0002": {
+		0x2e, 0x27, 0x18, 0x39, 0x3a, 0x25, 0x1c, 0x31, 0x16, 0x13, 0x38, 0x34, 0x20, 0x23, 0x3c, 0x0b,
+		0x0f, 0x21, 0x06, 0x3d, 0x1b, 0x29, 0x1e, 0x22, 0x1d, 0x24, 0x0e, 0x2b, 0x32, 0x08, 0x2e, 0x03,
+		0x04, 0x36, 0x26, 0x33, 0x11, 0x1f, 0x10, 0x02, 0x14, 0x3f, 0x00, 0x09, 0x12, 0x2e, 0x28, 0x20,
+		0x3e, 0x0d, 0x2a, 0x17, 0x0c, 0x01, 0x15, 0x19, 0x2e, 0x2c, 0x07, 0x37, 0x35, 0x05, 0x0a, 0x2d,
+	},
+	"RP2C04-0003": {
+		0x14, 0x25, 0x3a, 0x10, 0x0b, 0x20, 0x31, 0x09, 0x01, 0x2e, 0x36, 0x08, 0x15, 0x3d, 0x3e, 0x3c,
+		0x22, 0x1c, 0x05, 0x12, 0x19, 0x18, 0x17, 0x1b, 0x00, 0x03, 0x2e, 0x02, 0x16, 0x06, 0x34, 0x35,
+		0x23, 0x0f, 0x0e, 0x37, 0x0d, 0x27, 0x26, 0x20, 0x29, 0x04, 0x21, 0x24, 0x11, 0x2d, 0x2e, 0x1f,
+		0x2c, 0x1e, 0x39, 0x33, 0x07, 0x2a, 0x28, 0x1d, 0x0a, 0x2e, 0x32, 0x38, 0x13, 0x2b, 0x3f, 0x0c,
+	},
+	"RP2C04-0004": {
+		0x18, 0x03, 0x1c, 0x28, 0x2e, 0x35, 0x01, 0x17, 0x10, 0x1f, 0x2a, 0x0e, 0x36, 0x37, 0x1a, 0x39,
+		0x25, 0x1e, 0x12, 0x34, 0x2e, 0x1d, 0x06, 0x26, 0x3e, 0x1b, 0x22, 0x19, 0x04, 0x2e, 0x3a, 0x21,
+		0x05, 0x0a, 0x07, 0x02, 0x13, 0x14, 0x00, 0x15, 0x0c, 0x3d, 0x11, 0x0f, 0x0d, 0x38, 0x2d, 0x24,
+		0x33, 0x20, 0x08, 0x16, 0x3f, 0x2b, 0x20, 0x3c, 0x2e, 0x27, 0x23, 0x31, 0x29, 0x32, 0x2c, 0x09,
+	},
+}
+
+// Returns the 64-color palette of the given Vs. System PPU.
+func VSPalette(ppu string) (palette []color.Color, err error) {
+	lut, ok := vsPPUs[strings.ToUpper(ppu)]
+
+	if !ok {
+		err = fmt.Errorf("Invalid Vs. System PPU %v, must be RC2C03, RP2C04-0001, RP2C04-0002, RP2C04-0003 or RP2C04-0004", ppu)
+		return
+	}
+
+	palette = make([]color.Color, len(lut))
+
+	for i, index := range lut {
+		rgb := vsRGB[index]
+
+		level := func(shift uint) uint8 {
+			return uint8(((rgb >> shift) & 0x07) * 0xff / 0x07)
+		}
+
+		palette[i] = color.RGBA{level(6), level(3), level(0), 0xff}
+	}
+
+	return
+}
+
+// Parses a DIP switch setting given either as a number or as a string
+// of eight 0s and 1s listing switches 1 through 8, e.g. '01000000' to
+// turn on switch 2 only.
+func ParseDIPSwitches(s string) (dip uint8, err error) {
+	if len(s) == 8 && strings.Trim(s, "01") == "" {
+		for i, c := range s {
+			if c == '1' {
+				dip |= 1 << uint(i)
+			}
+		}
+
+		return
+	}
+
+	value, err := strconv.ParseUint(s, 0, 8)
+
+	if err != nil {
+		err = fmt.Errorf("Invalid DIP switches %v", s)
+		return
+	}
+
+	dip = uint8(value)
+
+	return
+}
+
+// Returns the Vs. System for romf configured from its ROM database
+// entry and then options, or nil if romf is not a Vs. System game.
+func vsSystemForROM(romf *ROMFile, options *Options) (vs *VSSystem, err error) {
+	var dip uint8
+
+	ppu, dipSwitches := "", ""
+
+	if game := LookupGame(romf.CRC32); game != nil {
+		ppu, dipSwitches = game.VSPPU, game.VSDIP
+	}
+
+	if !romf.VSCart && ppu == "" && options.VSPPU == "" {
+		return
+	}
+
+	if options.VSPPU != "" {
+		ppu = options.VSPPU
+	}
+
+	if ppu == "" {
+		ppu = "RC2C03"
+	}
+
+	if options.VSDIP != "" {
+		dipSwitches = options.VSDIP
+	}
+
+	if dipSwitches != "" {
+		if dip, err = ParseDIPSwitches(dipSwitches); err != nil {
+			return
+		}
+	}
+
+	if _, err = VSPalette(ppu); err != nil {
+		return
+	}
+
+	vs = NewVSSystem(ppu, dip)
+
+	return
+}
+
+// The coin slots, service button, DIP switches and coin counter of a
+// Vs. UniSystem cabinet.  Bit n of DIP is DIP switch n+1.
+type VSSystem struct {
+	PPU         string
+	DIP         uint8
+	Coins       [2]bool
+	Service     bool
+	CoinCounter bool
+}
+
+func NewVSSystem(ppu string, dip uint8) *VSSystem {
+	return &VSSystem{
+		PPU: strings.ToUpper(ppu),
+		DIP: dip,
+	}
+}
+
+func (vs *VSSystem) String() string {
+	switches := ""
+
+	for i := uint(0); i < 8; i++ {
+		switches += strconv.Itoa(int(vs.DIP>>i) & 0x01)
+	}
+
+	return fmt.Sprintf("Vs. System: PPU %v, DIP switches %v", vs.PPU, switches)
+}
+
+func (vs *VSSystem) Reset() {
+	vs.Coins[0] = false
+	vs.Coins[1] = false
+	vs.Service = false
+	vs.CoinCounter = false
+}
+
+func (vs *VSSystem) Mappings(which rp2ago3.Mapping) (fetch, store []uint16) {
+	switch which {
+	case rp2ago3.CPU:
+		fetch = []uint16{}
+		store = []uint16{0x4020}
+	}
+
+	return
+}
+
+// Returns the cabinet bits read alongside the controller data at
+// $4016 and $4017.
+func (vs *VSSystem) Fetch(address uint16) (value uint8) {
+	switch address {
+	case 0x4016:
+		if vs.Service {
+			value |= 0x04
+		}
+
+		// DIP switches 1-2
+		value |= (vs.DIP & 0x03) << 3
+
+		if vs.Coins[0] {
+			value |= 0x20
+		}
+
+		if vs.Coins[1] {
+			value |= 0x40
+		}
+	case 0x4017:
+		// DIP switches 3-8
+		value |= vs.DIP & 0xfc
+	}
+
+	return
+}
+
+func (vs *VSSystem) Store(address uint16, value uint8) (oldValue uint8) {
+	switch address {
+	case 0x4020:
+		if vs.CoinCounter {
+			oldValue = 1
+		}
+
+		vs.CoinCounter = value&0x01 != 0
+	}
+
+	return
+}
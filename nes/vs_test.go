@@ -0,0 +1,82 @@
+package nes
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestVSPalettes(t *testing.T) {
+	for ppu := range vsPPUs {
+		palette, err := VSPalette(ppu)
+
+		if err != nil {
+			t.Fatalf("Error getting %v palette: %v", ppu, err)
+		}
+
+		if len(palette) != 64 {
+			t.Errorf("%v palette has %v colors not 64", ppu, len(palette))
+		}
+	}
+
+	palette, _ := VSPalette("rc2c03")
+
+	if c := palette[0x20]; c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("RC2C03 color $20 is %v not white", c)
+	}
+
+	if c := palette[0x0d]; c != (color.RGBA{0x00, 0x00, 0x00, 0xff}) {
+		t.Errorf("RC2C03 color $0D is %v not black", c)
+	}
+
+	if _, err := VSPalette("RP2C02"); err == nil {
+		t.Error("No error getting palette of invalid Vs. System PPU")
+	}
+}
+
+func TestParseDIPSwitches(t *testing.T) {
+	for s, expected := range map[string]uint8{
+		"00000000": 0x00,
+		"10000000": 0x01,
+		"01000001": 0x82,
+		"0x82":     0x82,
+		"3":        0x03,
+	} {
+		if dip, err := ParseDIPSwitches(s); err != nil || dip != expected {
+			t.Errorf("ParseDIPSwitches(%q) is %v, %v not %v", s, dip, err, expected)
+		}
+	}
+
+	if _, err := ParseDIPSwitches("switches"); err == nil {
+		t.Error("No error parsing invalid DIP switches")
+	}
+}
+
+func TestVSRegisters(t *testing.T) {
+	vs := NewVSSystem("RC2C03", 0xff)
+	ctrls := NewControllers()
+	ctrls.vs = vs
+
+	ctrls.KeyDown(0, A)
+
+	// player 1 is read through $4017
+	if value := ctrls.Fetch(0x4017); value != 0xfd {
+		t.Errorf("$4017 is $%02X not $FD", value)
+	}
+
+	if value := ctrls.Fetch(0x4016); value != 0x18 {
+		t.Errorf("$4016 is $%02X not $18", value)
+	}
+
+	vs.Coins[0] = true
+	vs.Service = true
+
+	if value := ctrls.Fetch(0x4016); value != 0x3c {
+		t.Errorf("$4016 is $%02X not $3C", value)
+	}
+
+	vs.Store(0x4020, 0x01)
+
+	if !vs.CoinCounter {
+		t.Error("Coin counter is not set")
+	}
+}
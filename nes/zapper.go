@@ -0,0 +1,140 @@
+package nes
+
+import "image/color"
+
+// The picture being drawn by the PPU as seen by light guns.
+type screen interface {
+	Pixel(x, y int) uint8
+	Beam() (scanline, cycle int)
+}
+
+// Implemented by input devices which take input from the mouse.
+type MouseDevice interface {
+	// Called with the mouse position in NES pixels, -1, -1 if the
+	// mouse is outside the picture, and the state of its left and
+	// right buttons.
+	Mouse(x, y int, left, right bool)
+}
+
+const (
+	// The Zapper's light sensor sees pixels within this many pixels
+	// of where it is aimed.
+	zapperRadius = 2
+
+	// The sensor keeps reporting light for this many scanlines after
+	// the beam has drawn a bright pixel.
+	zapperScanlines = 20
+
+	// Pixels with at least this luma are bright enough to be seen by
+	// the sensor.
+	zapperBrightness = 0x80
+)
+
+// The Zapper light gun.  The NES Zapper is plugged into a controller
+// port and reads through that port's register, the Famicom Zapper is
+// plugged into the expansion port and reads through $4017.  Bit 3 is
+// clear while the sensor sees light and bit 4 is set while the trigger
+// is pulled.
+type Zapper struct {
+	famicom bool
+	ppu     screen
+	palette []color.Color
+	X, Y    int
+	Trigger bool
+}
+
+func NewZapper(ppu screen, palette []color.Color, famicom bool) *Zapper {
+	return &Zapper{
+		famicom: famicom,
+		ppu:     ppu,
+		palette: palette,
+		X:       -1,
+		Y:       -1,
+	}
+}
+
+func (z *Zapper) Reset() {
+	z.Trigger = false
+}
+
+func (z *Zapper) save() func() {
+	saved := *z
+	return func() { *z = saved }
+}
+
+func (z *Zapper) Strobe(value uint8) {}
+
+func (z *Zapper) Read(address uint16) (value uint8) {
+	if z.famicom && address != 0x4017 {
+		return
+	}
+
+	if !z.Light() {
+		value |= 0x08
+	}
+
+	if z.Trigger {
+		value |= 0x10
+	}
+
+	return
+}
+
+func (z *Zapper) KeyDown(btn Button) {}
+
+func (z *Zapper) KeyUp(btn Button) {}
+
+// The left button pulls the trigger, the right button pulls it while
+// aiming away from the screen as games like Duck Hunt have players do
+// to reload.
+func (z *Zapper) Mouse(x, y int, left, right bool) {
+	z.X, z.Y = x, y
+
+	if right {
+		z.X, z.Y = -1, -1
+	}
+
+	z.Trigger = left || right
+}
+
+// Returns true if the sensor sees a bright pixel near where the
+// Zapper is aimed that the beam has drawn recently.
+func (z *Zapper) Light() bool {
+	if z.ppu == nil || z.X < 0 || z.Y < 0 {
+		return false
+	}
+
+	scanline, cycle := z.ppu.Beam()
+
+	for y := z.Y - zapperRadius; y <= z.Y+zapperRadius; y++ {
+		age := scanline - y
+
+		if y < 0 || y > 239 || age < 0 || age > zapperScanlines {
+			continue
+		}
+
+		for x := z.X - zapperRadius; x <= z.X+zapperRadius; x++ {
+			// pixel x is drawn on cycle x+1
+			if x < 0 || x > 255 || (age == 0 && x+1 >= cycle) {
+				continue
+			}
+
+			if z.bright(z.ppu.Pixel(x, y)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (z *Zapper) bright(index uint8) bool {
+	if int(index) >= len(z.palette) {
+		return false
+	}
+
+	r, g, b, _ := z.palette[index].RGBA()
+	luma := (299*r + 587*g + 114*b) / 1000
+
+	return luma>>8 >= zapperBrightness
+}
@@ -0,0 +1,101 @@
+package nes
+
+import "testing"
+
+type testScreen struct {
+	pixels   [240][256]uint8
+	scanline int
+	cycle    int
+}
+
+func (s *testScreen) Pixel(x, y int) uint8 {
+	return s.pixels[y][x]
+}
+
+func (s *testScreen) Beam() (scanline, cycle int) {
+	return s.scanline, s.cycle
+}
+
+func TestZapper(t *testing.T) {
+	s := &testScreen{}
+
+	ctrls := NewControllers()
+	ctrls.ppu = s
+
+	if err := ctrls.Plug(Port2, "zapper"); err != nil {
+		t.Fatalf("Error plugging in Zapper: %v", err)
+	}
+
+	// a white box on a black screen
+	for y := 100; y < 116; y++ {
+		for x := 120; x < 136; x++ {
+			s.pixels[y][x] = 0x30
+		}
+	}
+
+	for i := range s.pixels {
+		for j := range s.pixels[i] {
+			if s.pixels[i][j] == 0 {
+				s.pixels[i][j] = 0x0f
+			}
+		}
+	}
+
+	ctrls.Mouse(128, 108, false, false)
+
+	for _, test := range []struct {
+		scanline, cycle int
+		value           uint8
+	}{
+		{0, 0, 0x48},     // box not drawn yet
+		{106, 121, 0x48}, // box drawn above but not below the sensor
+		{108, 120, 0x40},
+		{120, 0, 0x40},
+		{140, 0, 0x48}, // box drawn too long ago
+	} {
+		s.scanline, s.cycle = test.scanline, test.cycle
+
+		if value := ctrls.Fetch(0x4017); value != test.value {
+			t.Errorf("$4017 at scanline %v, cycle %v is $%02X not $%02X",
+				test.scanline, test.cycle, value, test.value)
+		}
+	}
+
+	s.scanline, s.cycle = 120, 0
+	ctrls.Mouse(128, 108, true, false)
+
+	if value := ctrls.Fetch(0x4017); value != 0x50 {
+		t.Errorf("$4017 with trigger pulled is $%02X not $50", value)
+	}
+
+	ctrls.Mouse(128, 108, false, true)
+
+	if value := ctrls.Fetch(0x4017); value != 0x58 {
+		t.Errorf("$4017 when reloading is $%02X not $58", value)
+	}
+
+	if value := ctrls.Fetch(0x4016); value != 0x40 {
+		t.Errorf("$4016 is $%02X not $40", value)
+	}
+}
+
+func TestFramePosition(t *testing.T) {
+	for _, test := range []struct {
+		x, y, width, height int
+		overscan            bool
+		fx, fy              int
+	}{
+		{0, 0, 256, 240, false, 0, 0},
+		{511, 479, 512, 480, false, 255, 239},
+		{256, 240, 512, 480, false, 128, 120},
+		{0, 0, 480, 448, true, 8, 8},
+		{720, 672, 720, 672, true, -1, -1},
+	} {
+		fx, fy := framePosition(test.x, test.y, test.width, test.height, visibleFrame(test.overscan))
+
+		if fx != test.fx || fy != test.fy {
+			t.Errorf("framePosition(%v, %v) in %vx%v is %v, %v not %v, %v",
+				test.x, test.y, test.width, test.height, fx, fy, test.fx, test.fy)
+		}
+	}
+}
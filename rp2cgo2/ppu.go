@@ -285,6 +285,17 @@ func (ppu *RP2C02) TriggerScanlineCounter() (trigger bool) {
 	return
 }
 
+// Returns the palette index of the pixel at x, y in the frame being
+// drawn.  Pixels below the beam still hold the previous frame.
+func (ppu *RP2C02) Pixel(x, y int) uint8 {
+	return ppu.colors[(y<<8)+x]
+}
+
+// Returns the scanline and cycle the PPU will render next.
+func (ppu *RP2C02) Beam() (scanline, cycle int) {
+	return int(ppu.Scanline), int(ppu.Cycle)
+}
+
 func (ppu *RP2C02) ToggleDecode() bool {
 	ppu.decode = !ppu.decode
 	return ppu.decode